@@ -5,11 +5,372 @@
 package data
 
 import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+
 	"github.com/proio-org/go-proio"
 )
 
+// UnmatchedSamplesKey is the metadata key of merged events that holds the
+// number of samples missed by each HPS so far, as "<hex UID>: <count>" pairs
+const UnmatchedSamplesKey = "Unmatched Samples"
+
+// DefaultMergeMaxLag is the MaxLag of a CmMerger that does not set one
+const DefaultMergeMaxLag = 100000
+
+// CmMerger combines the current-mode frames of several HPSs that read out one
+// detector.  Samples of different HPSs with FPGA sample numbers within Window
+// of each other are merged into a single sample with an entry in Hps for each
+// HPS, and frames of merged samples are passed on in new events carrying the
+// metadata of the first HPS.  Sample numbers are followed through their wraps
+// by HPS.  Merged samples keep the timestamp of the first HPS, or of the
+// first HPS with the sample, since the timestamps of HPSs read out by
+// different hosts are anchored to different clocks.
+//
+// The HPSs merged are those in UIDs, or else every HPS seen in the stream, in
+// which case an HPS is forgotten once it falls MaxLag samples behind.  A
+// sample still missing an HPS is unmatched once each missing HPS has moved
+// past it, or once the stream is MaxLag samples ahead of it.  Unmatched
+// samples are dropped unless Partial is set, and are counted by missing HPS in
+// the UnmatchedSamplesKey metadata of the following events.
+//
+// Until a second HPS is seen, events pass through unchanged.
+type CmMerger struct {
+	UIDs    []uint64
+	Window  uint64
+	MaxLag  uint64
+	Partial bool
+
+	merging   bool
+	primary   uint64
+	latest    map[uint64]uint64
+	lastSn    map[uint64]uint32
+	wraps     map[uint64]uint64
+	metadata  map[string][]byte
+	pending   []*mergeSample
+	done      []*currentmode.Sample
+	unmatched map[uint64]uint64
+	report    []byte
+	reported  []byte
+	ignored   map[uint64]bool
+}
+
+// mergeSample is a sample being merged, at the position pos given by the
+// unwrapped FPGA sample numbers of its HPSs
+type mergeSample struct {
+	pos    uint64
+	sample *currentmode.Sample
+}
+
+// CmMerge merges the frames of every HPS in the stream with the default
+// settings of CmMerger
 func CmMerge(input <-chan *proio.Event, output chan<- *proio.Event) {
+	(&CmMerger{}).Merge(input, output)
+}
+
+// Merge is a StreamProcessor that merges the frames of the input
+func (c *CmMerger) Merge(input <-chan *proio.Event, output chan<- *proio.Event) {
+	c.latest = make(map[uint64]uint64)
+	c.lastSn = make(map[uint64]uint32)
+	c.wraps = make(map[uint64]uint64)
+	c.unmatched = make(map[uint64]uint64)
+	c.ignored = make(map[uint64]bool)
+	c.merging = len(c.UIDs) > 0
+	if c.merging {
+		c.primary = c.UIDs[0]
+	}
+	if c.MaxLag == 0 {
+		c.MaxLag = DefaultMergeMaxLag
+	}
+
 	for event := range input {
-		output <- event
+		if len(event.Metadata["UID"]) < 8 {
+			output <- event
+			continue
+		}
+		uid := binary.BigEndian.Uint64(event.Metadata["UID"])
+
+		if !c.merging {
+			if len(c.latest) == 0 {
+				c.primary = uid
+				c.latest[uid] = 0
+			}
+			if uid == c.primary {
+				output <- event
+				continue
+			}
+			log.Printf("merging frames of HPS %016x with HPS %016x\n", uid, c.primary)
+			c.merging = true
+		}
+
+		if !c.expected(uid) {
+			if !c.ignored[uid] {
+				c.ignored[uid] = true
+				log.Printf("ignoring frames of HPS %016x, which is not merged\n", uid)
+			}
+			continue
+		}
+		if uid == c.primary || c.metadata == nil {
+			c.metadata = event.Metadata
+		}
+
+		frameIds := event.TaggedEntries("Frame")
+		if len(frameIds) == 0 {
+			output <- event
+			continue
+		}
+		for _, frameId := range frameIds {
+			frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+			if !ok {
+				continue
+			}
+			for _, sample := range frame.Sample {
+				if hpsSample := sample.Hps[uid]; hpsSample != nil {
+					pos := c.position(uid, hpsSample.SampleNumber)
+					c.add(uid, pos, frame.Timestamp+sample.Timestamp, sample)
+				}
+			}
+		}
+
+		if merged := c.flush(false); merged != nil {
+			output <- merged
+		}
+	}
+
+	if merged := c.flush(true); merged != nil {
+		output <- merged
+	}
+	if len(c.unmatched) > 0 {
+		log.Println("unmatched samples by HPS:", string(c.report))
+	}
+}
+
+// expected returns whether the samples of an HPS are merged, learning the
+// HPS if UIDs is not set
+func (c *CmMerger) expected(uid uint64) bool {
+	if len(c.UIDs) == 0 {
+		if _, ok := c.latest[uid]; !ok {
+			c.latest[uid] = 0
+		}
+		return true
+	}
+	for _, expected := range c.UIDs {
+		if uid == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// position returns the position of a sample number of an HPS, counting the
+// wraps of its sample numbers
+func (c *CmMerger) position(uid uint64, sampleNumber uint32) uint64 {
+	if last, ok := c.lastSn[uid]; ok && sampleNumber < last && last-sampleNumber >= 1<<31 {
+		c.wraps[uid]++
+	}
+	c.lastSn[uid] = sampleNumber
+	return c.wraps[uid]<<32 | uint64(sampleNumber)
+}
+
+// add merges the HPS samples of a sample of uid at position pos with timestamp
+// ts into the pending samples.  Merged samples are placeholders if any of
+// their parts are.
+func (c *CmMerger) add(uid, pos, ts uint64, part *currentmode.Sample) {
+	hps := part.Hps
+	for uid := range hps {
+		latest, ok := c.latest[uid]
+		if ok && latest > pos+c.MaxLag {
+			// sample numbers have been reset, so nothing pending can be
+			// matched anymore
+			log.Printf("sample numbers of HPS %016x jumped back, restarting merge\n", uid)
+			c.ready(true)
+			for uid := range c.latest {
+				c.latest[uid] = 0
+			}
+			latest = 0
+		}
+		if pos > latest {
+			c.latest[uid] = pos
+		}
+	}
+
+	// merge into the nearest sample in the window that the HPSs are missing
+	var nearest *mergeSample
+	var nearestDist uint64
+	start := sort.Search(len(c.pending), func(i int) bool {
+		return c.pending[i].pos+c.Window >= pos
+	})
+	for i := start; i < len(c.pending) && c.pending[i].pos <= pos+c.Window; i++ {
+		pending := c.pending[i]
+		free := true
+		for uid := range hps {
+			if pending.sample.Hps[uid] != nil {
+				free = false
+				break
+			}
+		}
+		dist := pending.pos - pos
+		if pos > pending.pos {
+			dist = pos - pending.pos
+		}
+		if free && (nearest == nil || dist < nearestDist) {
+			nearest, nearestDist = pending, dist
+		}
+	}
+	if nearest != nil {
+		for uid, hpsSample := range hps {
+			nearest.sample.Hps[uid] = hpsSample
+		}
+		nearest.sample.Placeholder = nearest.sample.Placeholder || part.Placeholder
+		if uid == c.primary {
+			nearest.sample.Timestamp = ts
+		}
+		return
+	}
+
+	sample := &currentmode.Sample{
+		Timestamp:   ts,
+		Hps:         make(map[uint64]*currentmode.HpsSample),
+		Placeholder: part.Placeholder,
+	}
+	for uid, hpsSample := range hps {
+		sample.Hps[uid] = hpsSample
+	}
+	i := sort.Search(len(c.pending), func(i int) bool {
+		return c.pending[i].pos > pos
+	})
+	c.pending = append(c.pending, nil)
+	copy(c.pending[i+1:], c.pending[i:])
+	c.pending[i] = &mergeSample{pos: pos, sample: sample}
+}
+
+// missing returns the merged HPSs without an entry in a sample
+func (c *CmMerger) missing(sample *currentmode.Sample) []uint64 {
+	var missing []uint64
+	if len(c.UIDs) > 0 {
+		for _, uid := range c.UIDs {
+			if sample.Hps[uid] == nil {
+				missing = append(missing, uid)
+			}
+		}
+		return missing
+	}
+	for uid := range c.latest {
+		if sample.Hps[uid] == nil {
+			missing = append(missing, uid)
+		}
+	}
+	return missing
+}
+
+// ready moves the samples that are complete or unmatched from the front of
+// the pending samples to the samples to pass on, keeping them in order.  If
+// all is set, every pending sample is moved.
+func (c *CmMerger) ready(all bool) {
+	var newest uint64
+	for _, latest := range c.latest {
+		if latest > newest {
+			newest = latest
+		}
+	}
+
+	if len(c.UIDs) == 0 {
+		for uid, latest := range c.latest {
+			if uid != c.primary && newest-latest > c.MaxLag {
+				log.Printf("HPS %016x fell behind, no longer merging its frames\n", uid)
+				delete(c.latest, uid)
+			}
+		}
+	}
+
+	for len(c.pending) > 0 {
+		pending := c.pending[0]
+		if missing := c.missing(pending.sample); len(missing) > 0 {
+			passed := true
+			for _, uid := range missing {
+				if c.latest[uid] <= pending.pos+c.Window {
+					passed = false
+					break
+				}
+			}
+			if !all && !passed && pending.pos+c.MaxLag >= newest {
+				break
+			}
+
+			for _, uid := range missing {
+				c.unmatched[uid]++
+			}
+			if !c.Partial {
+				c.pending = c.pending[1:]
+				continue
+			}
+		}
+		c.done = append(c.done, pending.sample)
+		c.pending = c.pending[1:]
+	}
+}
+
+// flush returns an event with a frame of the samples that are ready, or nil
+// if there are none and the unmatched counts have not changed.  If all is
+// set, every pending sample is passed on.
+func (c *CmMerger) flush(all bool) *proio.Event {
+	c.ready(all)
+	if len(c.unmatched) > 0 {
+		c.updateReport()
+	}
+	samples := c.done
+	c.done = nil
+	if len(samples) == 0 && string(c.report) == string(c.reported) {
+		return nil
+	}
+	c.reported = c.report
+
+	event := proio.NewEvent()
+	for key, value := range c.metadata {
+		event.Metadata[key] = value
+	}
+	if c.report != nil {
+		event.Metadata[UnmatchedSamplesKey] = c.report
+	}
+	if len(samples) == 0 {
+		return event
+	}
+
+	// the timestamps of samples from different HPSs need not be in order
+	frame := &currentmode.Frame{Timestamp: samples[0].Timestamp}
+	for _, sample := range samples {
+		if sample.Timestamp < frame.Timestamp {
+			frame.Timestamp = sample.Timestamp
+		}
+	}
+	for _, sample := range samples {
+		sample.Timestamp -= frame.Timestamp
+	}
+	frame.Sample = samples
+	event.AddEntry("Frame", frame)
+	return event
+}
+
+// updateReport formats the unmatched sample counts, keeping the previous
+// report if the counts have not changed
+func (c *CmMerger) updateReport() {
+	var uids []uint64
+	for uid := range c.unmatched {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	var counts []string
+	for _, uid := range uids {
+		counts = append(counts, fmt.Sprintf("%016x: %v", uid, c.unmatched[uid]))
+	}
+	report := strings.Join(counts, ", ")
+	if report != string(c.report) {
+		c.report = []byte(report)
 	}
 }
@@ -17,14 +17,16 @@ import (
 type BeamReconstruction struct {
 	hpsConfig        *detmapmodel.HpsConfig
 	linEstT, meanPos *mat.Dense
+	// secondMom holds the x^2, y^2 and x*y moment estimators
+	secondMom *mat.Dense
 }
 
-func NewBeamReconstruction(uid uint64) *BeamReconstruction {
+func NewBeamReconstruction(mapper *Mapper) *BeamReconstruction {
 	r := &BeamReconstruction{
-		hpsConfig: GetHpsConfig(uid),
+		hpsConfig: mapper.HpsConfig(),
 	}
 
-	imageConfs := GetImageConfigs(uid)
+	imageConfs := mapper.ImageConfigs()
 	if len(imageConfs) > 0 {
 		imageConf := imageConfs[0]
 		if len(imageConf.LinEstT) > 0 {
@@ -45,6 +47,17 @@ func NewBeamReconstruction(uid uint64) *BeamReconstruction {
 			}
 			r.meanPos = &mat.Dense{}
 			r.meanPos.Mul(pos, r.linEstT.T())
+
+			_, nPos := pos.Dims()
+			posProd := mat.NewDense(3, nPos, nil)
+			for j := 0; j < nPos; j++ {
+				x, y := pos.At(0, j), pos.At(1, j)
+				posProd.Set(0, j, x*x)
+				posProd.Set(1, j, y*y)
+				posProd.Set(2, j, x*y)
+			}
+			r.secondMom = &mat.Dense{}
+			r.secondMom.Mul(posProd, r.linEstT.T())
 		}
 	}
 
@@ -74,6 +87,10 @@ func (r *BeamReconstruction) FillBeamInfo(event *proio.Event) {
 		}
 
 		for _, sample := range frame.Sample {
+			// placeholders for lost samples say nothing about the beam
+			if sample.Placeholder {
+				continue
+			}
 			reducedSample := &currentmode.Sample{
 				Timestamp: sample.Timestamp,
 				BeamInfo:  &currentmode.Sample_BeamInfo{},
@@ -99,6 +116,12 @@ func (r *BeamReconstruction) FillBeamInfo(event *proio.Event) {
 				beamInfo.MeanXPos = float32(pos.AtVec(0))
 				beamInfo.MeanYPos = float32(pos.AtVec(1))
 				beamInfo.TotalCurrent = float32(sum)
+
+				var mom mat.VecDense
+				mom.MulVec(r.secondMom, qVec)
+				beamInfo.XVar = float32(mom.AtVec(0) - pos.AtVec(0)*pos.AtVec(0))
+				beamInfo.YVar = float32(mom.AtVec(1) - pos.AtVec(1)*pos.AtVec(1))
+				beamInfo.XYCov = float32(mom.AtVec(2) - pos.AtVec(0)*pos.AtVec(1))
 			}
 		}
 
@@ -0,0 +1,364 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/proio-org/go-proio"
+)
+
+// BranchPolicy decides what a Tee does with an event when the buffer of a
+// branch is full
+type BranchPolicy int
+
+const (
+	// BranchBlock waits for the branch, holding back the whole stream
+	BranchBlock BranchPolicy = iota
+	// BranchDropOldest discards the oldest event in the buffer
+	BranchDropOldest
+	// BranchDropNewest discards the new event
+	BranchDropNewest
+)
+
+func (p BranchPolicy) String() string {
+	switch p {
+	case BranchDropOldest:
+		return "drop-oldest"
+	case BranchDropNewest:
+		return "drop-newest"
+	}
+	return "block"
+}
+
+// ParseBranchPolicy parses "block", "drop-oldest" or "drop-newest", with an
+// empty string meaning block
+func ParseBranchPolicy(text string) (BranchPolicy, error) {
+	switch text {
+	case "", "block":
+		return BranchBlock, nil
+	case "drop-oldest":
+		return BranchDropOldest, nil
+	case "drop-newest":
+		return BranchDropNewest, nil
+	}
+	return BranchBlock, fmt.Errorf("unknown branch policy \"%v\"", text)
+}
+
+// Branch is a sub-pipeline fed by a Tee.  Events wait for Ops in a buffer of
+// Buffer events, and Policy decides what happens when the buffer is full.
+//
+// Each branch gets its own copy of each event, unless Share is set, in which
+// case the branch gets the events passed on by the Tee and neither the branch
+// nor the ops after the Tee may modify them.
+//
+// The output of the branch is attached to Merge if it is set, and is
+// otherwise discarded.
+type Branch struct {
+	Name   string
+	Ops    OpArray
+	Policy BranchPolicy
+	Buffer int
+	Share  bool
+	Merge  *Merge
+
+	dropped uint64
+}
+
+// Dropped returns the number of events dropped by the branch
+func (b *Branch) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// send queues an event for the branch following its policy, returning false
+// if ctx is done
+func (b *Branch) send(ctx context.Context, feed chan *proio.Event, event *proio.Event) bool {
+	switch b.Policy {
+	case BranchDropNewest:
+		select {
+		case feed <- event:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	case BranchDropOldest:
+		for {
+			select {
+			case feed <- event:
+				return true
+			default:
+			}
+			select {
+			case <-feed:
+				atomic.AddUint64(&b.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case feed <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// Tee passes its input on unchanged while also feeding each of Branches
+type Tee struct {
+	Description string
+	Branches    []*Branch
+	MaxEventBuf int
+}
+
+func (t Tee) GetDescription() string {
+	return t.Description
+}
+
+func (t Tee) Run(input <-chan *proio.Event) <-chan *proio.Event {
+	return t.RunContext(context.Background(), input, logFail)
+}
+
+func (t Tee) RunContext(ctx context.Context, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
+	if t.MaxEventBuf == 0 {
+		t.MaxEventBuf = *maxEventBuf
+	}
+
+	feeds := make([]chan *proio.Event, len(t.Branches))
+	for i, b := range t.Branches {
+		buffer := b.Buffer
+		if buffer == 0 {
+			buffer = t.MaxEventBuf
+		}
+		feeds[i] = make(chan *proio.Event, buffer)
+
+		name := b.Name
+		branchOutput := b.Ops.runContext(ctx, feeds[i], func(err error) {
+			fail(fmt.Errorf("branch %v: %w", name, err))
+		})
+		if b.Merge != nil {
+			b.Merge.Attach(branchOutput)
+		} else {
+			go func() {
+				for range branchOutput {
+				}
+			}()
+		}
+	}
+
+	output := make(chan *proio.Event, t.MaxEventBuf)
+	go func() {
+		defer close(output)
+		defer func() {
+			for i, feed := range feeds {
+				close(feed)
+				if dropped := t.Branches[i].Dropped(); dropped > 0 {
+					log.Printf("branch %v dropped %v events", t.Branches[i].Name, dropped)
+				}
+			}
+		}()
+
+		branchEvents := make([]*proio.Event, len(t.Branches))
+		for {
+			var event *proio.Event
+			select {
+			case event = <-input:
+			case <-ctx.Done():
+				return
+			}
+			if event == nil {
+				return
+			}
+
+			// make all copies before any branch can touch the event
+			for i, b := range t.Branches {
+				branchEvents[i] = event
+				if !b.Share {
+					branchEvents[i] = proio.CopyEvent(event)
+				}
+			}
+			for i, b := range t.Branches {
+				if !b.send(ctx, feeds[i], branchEvents[i]) {
+					return
+				}
+			}
+
+			select {
+			case output <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return output
+}
+
+// Merge passes on its input along with the events of the streams attached to
+// it, in the order they arrive.  Its output is closed once its input and all
+// attached streams are closed.  Streams attached while the Merge is not
+// running are merged into its next run.
+type Merge struct {
+	Description string
+	MaxEventBuf int
+
+	ctx     context.Context
+	output  chan *proio.Event
+	pending []<-chan *proio.Event
+	active  int
+	running bool
+	mutex   sync.Mutex
+}
+
+// Attach adds a stream to be merged
+func (m *Merge) Attach(stream <-chan *proio.Event) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.running {
+		m.start(stream)
+	} else {
+		m.pending = append(m.pending, stream)
+	}
+}
+
+func (m *Merge) GetDescription() string {
+	return m.Description
+}
+
+func (m *Merge) Run(input <-chan *proio.Event) <-chan *proio.Event {
+	return m.RunContext(context.Background(), input, logFail)
+}
+
+func (m *Merge) RunContext(ctx context.Context, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	bufSize := m.MaxEventBuf
+	if bufSize == 0 {
+		bufSize = *maxEventBuf
+	}
+	m.ctx = ctx
+	m.output = make(chan *proio.Event, bufSize)
+	m.running = true
+
+	m.start(input)
+	for _, stream := range m.pending {
+		m.start(stream)
+	}
+	m.pending = nil
+
+	return m.output
+}
+
+// start forwards a stream to the output, closing the output once no streams
+// are left.  It must be called with the mutex held.
+func (m *Merge) start(stream <-chan *proio.Event) {
+	m.active++
+	ctx, output := m.ctx, m.output
+
+	go func() {
+		defer func() {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+
+			m.active--
+			if m.active == 0 {
+				m.running = false
+				close(output)
+			}
+		}()
+
+		for {
+			var event *proio.Event
+			select {
+			case event = <-stream:
+			case <-ctx.Done():
+				return
+			}
+			if event == nil {
+				return
+			}
+
+			select {
+			case output <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func init() {
+	RegisterOp("tee", "Feeds branches of ops with copies of the stream (branches: name, policy, buffer, share, join, ops)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			var cfg struct {
+				Branches []struct {
+					Name   string
+					Policy string
+					Buffer int
+					Share  bool
+					Join   string
+					Ops    []interface{}
+				}
+			}
+			if err := params.Decode(&cfg); err != nil {
+				return nil, err
+			}
+
+			tee := Tee{Description: "Feeds branches of ops with copies of the stream"}
+			for i, branchCfg := range cfg.Branches {
+				branch := &Branch{
+					Name:   branchCfg.Name,
+					Buffer: branchCfg.Buffer,
+					Share:  branchCfg.Share,
+				}
+				if branch.Name == "" {
+					branch.Name = fmt.Sprint(i)
+				}
+
+				var err error
+				if branch.Policy, err = ParseBranchPolicy(branchCfg.Policy); err != nil {
+					return nil, err
+				}
+				pipeline, err := parseSteps(branchCfg.Ops)
+				if err != nil {
+					return nil, fmt.Errorf("branch %v: %v", branch.Name, err)
+				}
+				if branch.Ops, err = pipeline.build(env); err != nil {
+					return nil, fmt.Errorf("branch %v: %v", branch.Name, err)
+				}
+				if branchCfg.Join != "" {
+					if env.placed[branchCfg.Join] {
+						return nil, fmt.Errorf("branch %v joins \"%v\" before the tee", branch.Name, branchCfg.Join)
+					}
+					branch.Merge = env.join(branchCfg.Join)
+				}
+
+				tee.Branches = append(tee.Branches, branch)
+			}
+			return tee, nil
+		},
+	)
+	RegisterOp("join", "Merges the output of tee branches into the stream (name)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			var cfg struct {
+				Name string
+			}
+			if err := params.Decode(&cfg); err != nil {
+				return nil, err
+			}
+			if env.placed[cfg.Name] {
+				return nil, fmt.Errorf("join \"%v\" appears twice", cfg.Name)
+			}
+			merge := env.join(cfg.Name)
+			env.placed[cfg.Name] = true
+			return merge, nil
+		},
+	)
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/proio-org/go-proio"
+)
+
+// ChannelResponse accumulates the mean raw value of each HPS channel over the
+// raw frames of a stream
+type ChannelResponse struct {
+	sums   map[uint64][]float64
+	counts map[uint64][]uint64
+	mutex  sync.Mutex
+}
+
+func (r *ChannelResponse) Accumulate(event *proio.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.sums == nil {
+		r.sums = make(map[uint64][]float64)
+		r.counts = make(map[uint64][]uint64)
+	}
+
+	for _, entryId := range event.TaggedEntries("Frame") {
+		frame, ok := event.GetEntry(entryId).(*currentmode.Frame)
+		if !ok {
+			continue
+		}
+
+		for _, sample := range frame.Sample {
+			for hpsId, hpsSample := range sample.Hps {
+				add := func(i int, val float64) {
+					for len(r.sums[hpsId]) <= i {
+						r.sums[hpsId] = append(r.sums[hpsId], 0)
+						r.counts[hpsId] = append(r.counts[hpsId], 0)
+					}
+					r.sums[hpsId][i] += val
+					r.counts[hpsId][i]++
+				}
+
+				for i, val := range hpsSample.Channel {
+					add(i, float64(val))
+				}
+				if len(hpsSample.Channel) == 0 {
+					for i, val := range hpsSample.FixedChannel {
+						add(i, float64(val))
+					}
+				}
+			}
+		}
+	}
+}
+
+// Means returns the mean value of each channel by HPS ID
+func (r *ChannelResponse) Means() map[uint64][]float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	means := make(map[uint64][]float64)
+	for hpsId, sums := range r.sums {
+		means[hpsId] = make([]float64, len(sums))
+		for i, sum := range sums {
+			if count := r.counts[hpsId][i]; count > 0 {
+				means[hpsId][i] = sum / float64(count)
+			}
+		}
+	}
+	return means
+}
+
+// GainCalibration computes per-channel current conversion factors from the
+// response of each channel to a flat field or a known current.  If Current is
+// zero, the run is treated as a flat field and each channel is calibrated to
+// the median response of the channels of its HPS.  Otherwise, each channel is
+// calibrated to read Current.
+//
+// Channels with a response below DeadFrac of the target are reported as dead
+// and keep their nominal conversion.  Channels with a gain correction larger
+// than OutlierFrac are reported as outliers, but are still calibrated.
+type GainCalibration struct {
+	Current     float64
+	DeadFrac    float64
+	OutlierFrac float64
+}
+
+// ChannelGain is the calibration result for one HPS channel.  Gain is the
+// correction relative to the nominal HpsConfig.CurrentConv.
+type ChannelGain struct {
+	Hps         uint64
+	Channel     int
+	Response    float64
+	Gain        float64
+	CurrentConv float32
+	Dead        bool
+	Outlier     bool
+}
+
+// Compute returns the gain of each mapped channel, with signal and dark
+// holding the mean raw values of each channel by HPS ID.  dark may be nil.
+func (c *GainCalibration) Compute(detmap *detmapmodel.Map, signal, dark map[uint64][]float64) ([]ChannelGain, error) {
+	deadFrac := c.DeadFrac
+	if deadFrac == 0 {
+		deadFrac = 0.05
+	}
+	outlierFrac := c.OutlierFrac
+	if outlierFrac == 0 {
+		outlierFrac = 0.2
+	}
+
+	var hpsIds []uint64
+	for hpsId := range signal {
+		hpsIds = append(hpsIds, hpsId)
+	}
+	sort.Slice(hpsIds, func(i, j int) bool { return hpsIds[i] < hpsIds[j] })
+
+	var gains []ChannelGain
+	for _, hpsId := range hpsIds {
+		hpsConfig := detmap.HpsConfig[uint32(hpsId>>32)]
+		if hpsConfig == nil {
+			continue
+		}
+
+		var hpsGains []ChannelGain
+		var nominal []float64
+		for i, mean := range signal[hpsId] {
+			if hpsConfig.Channel[uint32(i)] == nil {
+				continue
+			}
+			if i < len(dark[hpsId]) {
+				mean -= dark[hpsId][i]
+			}
+			hpsGains = append(hpsGains, ChannelGain{
+				Hps:         hpsId,
+				Channel:     i,
+				Response:    mean * float64(hpsConfig.CurrentConv),
+				Gain:        1,
+				CurrentConv: hpsConfig.CurrentConv,
+			})
+			nominal = append(nominal, mean*float64(hpsConfig.CurrentConv))
+		}
+		if len(hpsGains) == 0 {
+			continue
+		}
+
+		target := c.Current
+		if target == 0 {
+			target = median(nominal)
+		}
+		if target == 0 {
+			return nil, errors.New("median channel response is zero")
+		}
+
+		for i := range hpsGains {
+			gain := &hpsGains[i]
+			ratio := gain.Response / target
+			if ratio < deadFrac {
+				gain.Dead = true
+				continue
+			}
+			gain.Gain = 1 / ratio
+			gain.CurrentConv = float32(float64(hpsConfig.CurrentConv) * gain.Gain)
+			gain.Outlier = math.Abs(gain.Gain-1) > outlierFrac
+		}
+
+		gains = append(gains, hpsGains...)
+	}
+
+	if len(gains) == 0 {
+		return nil, errors.New("no mapped channels found")
+	}
+	return gains, nil
+}
+
+// ApplyGains returns a copy of detmap with HpsCalibration entries holding the
+// calibrated current conversion of each channel.  Dead channels and channels
+// without a gain keep their existing calibration, or else the nominal
+// conversion.
+func ApplyGains(detmap *detmapmodel.Map, gains []ChannelGain) *detmapmodel.Map {
+	calibrated := proto.Clone(detmap).(*detmapmodel.Map)
+	if calibrated.HpsCalibration == nil {
+		calibrated.HpsCalibration = make(map[uint32]*detmapmodel.HpsCalibration)
+	}
+
+	for _, gain := range gains {
+		if gain.Dead {
+			continue
+		}
+		hpsConfig := calibrated.HpsConfig[uint32(gain.Hps>>32)]
+		calibId := uint32(gain.Hps)
+		calib := calibrated.HpsCalibration[calibId]
+		if calib == nil {
+			calib = &detmapmodel.HpsCalibration{}
+			calibrated.HpsCalibration[calibId] = calib
+		}
+		for len(calib.CurrentConv) <= gain.Channel {
+			calib.CurrentConv = append(calib.CurrentConv, hpsConfig.CurrentConv)
+		}
+		calib.CurrentConv[gain.Channel] = gain.CurrentConv
+	}
+
+	return calibrated
+}
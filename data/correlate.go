@@ -31,8 +31,14 @@ func CorrelateCmEvent(event *proio.Event) {
 			cov[i] = make([]float64, nAxes)
 		}
 
+		// placeholders for lost samples are not readings
+		nReadings := 0
 		for i := 0; i < nSamples; i++ {
 			sample := frame.Sample[i]
+			if sample.Placeholder {
+				continue
+			}
+			nReadings++
 			for j := 0; j < nAxes; j++ {
 				axisJSum := float64(sample.Axis[j].Sum)
 				sum[j] += axisJSum
@@ -43,9 +49,12 @@ func CorrelateCmEvent(event *proio.Event) {
 			}
 		}
 
+		if nReadings == 0 {
+			continue
+		}
 		for i := 0; i < nAxes; i++ {
 			for j := i; j < nAxes; j++ {
-				cov[i][j] = prodSum[i][j] - sum[i]*sum[j]/float64(nSamples)
+				cov[i][j] = prodSum[i][j] - sum[i]*sum[j]/float64(nReadings)
 			}
 		}
 
@@ -91,8 +100,14 @@ func (c *Correlator) CorrelateCmEvent(input <-chan *proio.Event, output chan<- *
 					cov[i] = make([]float64, nAxes)
 				}
 
+				// placeholders for lost samples are not readings
+				nReadings := 0
 				for i := 0; i < nSamples; i++ {
 					sample := frame.Sample[i]
+					if sample.Placeholder {
+						continue
+					}
+					nReadings++
 					for j := 0; j < nAxes; j++ {
 						axisJSum := float64(sample.Axis[j].Sum)
 						sum[j] += axisJSum
@@ -103,9 +118,12 @@ func (c *Correlator) CorrelateCmEvent(input <-chan *proio.Event, output chan<- *
 					}
 				}
 
+				if nReadings == 0 {
+					continue
+				}
 				for i := 0; i < nAxes; i++ {
 					for j := i; j < nAxes; j++ {
-						cov[i][j] = prodSum[i][j] - sum[i]*sum[j]/float64(nSamples)
+						cov[i][j] = prodSum[i][j] - sum[i]*sum[j]/float64(nReadings)
 					}
 				}
 
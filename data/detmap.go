@@ -0,0 +1,328 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
+
+	"github.com/gobuffalo/packr"
+	"github.com/golang/protobuf/proto"
+)
+
+var DetmapLiteBox = packr.NewBox("../detmap/lite")
+
+// DefaultDetmaps is the registry used by the package-level detector mapping
+// functions
+var DefaultDetmaps = &DetmapRegistry{}
+
+// DetmapRegistry loads detector maps and selects between them.  A map source
+// is either a storage URL (file://, gs://, s3://, ...), a path to a local
+// file, or the name of a map packed into DetmapBox or DetmapLiteBox.
+//
+// Maps are selected for a stream by UID using ByUID, falling back to the
+// value of the stream metadata entry MetadataKey, and finally to Default.
+// Maps that come from files or URLs are reloaded in the background when they
+// change, checking at most once every ReloadPeriod.  Maps that cannot be
+// loaded are tried again the same way, except for names that are neither
+// URLs nor paths of existing files, which are taken to be packed maps.
+type DetmapRegistry struct {
+	Default      string
+	ByUID        map[uint64]string
+	MetadataKey  string
+	Credentials  string
+	ReloadPeriod time.Duration
+
+	maps     map[string]*loadedDetmap
+	assigned map[uint64]string
+	mutex    sync.Mutex
+}
+
+type loadedDetmap struct {
+	detmap    *detmapmodel.Map
+	modTime   time.Time
+	lastCheck time.Time
+	checking  bool
+	// ready is closed once the map is first loaded
+	ready chan struct{}
+}
+
+const defaultDetmapSource = "dev.pb"
+const fallbackDetmapSource = "all_dets.pb"
+
+// Get returns the map from source, loading it if necessary.  If the map cannot
+// be loaded, an empty map is returned along with the error.  Once loaded, the
+// map is returned from the cache, and changes to its source are checked for
+// and reloaded in the background so that slow storage never holds up callers.
+func (r *DetmapRegistry) Get(source string) (*detmapmodel.Map, error) {
+	r.mutex.Lock()
+	if source == "" {
+		source = r.Default
+	}
+	if source == "" {
+		source = defaultDetmapSource
+	}
+	if r.maps == nil {
+		r.maps = make(map[string]*loadedDetmap)
+	}
+
+	loaded := r.maps[source]
+	if loaded == nil {
+		loaded = &loadedDetmap{ready: make(chan struct{})}
+		r.maps[source] = loaded
+		r.mutex.Unlock()
+
+		detmap, modTime, err := LoadDetmap(source, r.Credentials)
+		if err != nil && source == defaultDetmapSource {
+			detmap, modTime, err = LoadDetmap(fallbackDetmapSource, r.Credentials)
+		}
+		if err != nil {
+			// a nonzero modification time makes sure the map is loaded once
+			// the source becomes available
+			detmap = &detmapmodel.Map{}
+			modTime = time.Unix(0, 0)
+		}
+
+		r.mutex.Lock()
+		loaded.detmap = detmap
+		loaded.modTime = modTime
+		loaded.lastCheck = time.Now()
+		close(loaded.ready)
+		r.mutex.Unlock()
+		return detmap, err
+	}
+	r.mutex.Unlock()
+
+	<-loaded.ready
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	period := r.ReloadPeriod
+	if period == 0 {
+		period = time.Second
+	}
+	if !loaded.modTime.IsZero() && !loaded.checking && time.Since(loaded.lastCheck) >= period {
+		loaded.checking = true
+		go r.reload(source, loaded)
+	}
+	return loaded.detmap, nil
+}
+
+// reload loads the map from source again if the source has changed
+func (r *DetmapRegistry) reload(source string, loaded *loadedDetmap) {
+	r.mutex.Lock()
+	lastModTime := loaded.modTime
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		loaded.checking = false
+		loaded.lastCheck = time.Now()
+		r.mutex.Unlock()
+	}()
+
+	modTime, err := detmapModTime(source, r.Credentials)
+	if err != nil || !modTime.After(lastModTime) {
+		return
+	}
+	log.Println("reloading detector map", source)
+	detmap, modTime, err := LoadDetmap(source, r.Credentials)
+	if err != nil {
+		log.Printf("unable to reload detector map \"%v\": %v\n", source, err)
+		return
+	}
+
+	r.mutex.Lock()
+	loaded.detmap = detmap
+	loaded.modTime = modTime
+	r.mutex.Unlock()
+}
+
+// Source returns the map source selected for a stream with the given UID and
+// metadata
+func (r *DetmapRegistry) Source(uid uint64, metadata map[string][]byte) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if source, ok := r.ByUID[uid]; ok {
+		return source
+	}
+	if source, ok := r.ByUID[uid&^0xffffffff]; ok {
+		return source
+	}
+	if source := metadata[r.metadataKey()]; len(source) > 0 {
+		return string(source)
+	}
+	if source, ok := r.assigned[uid]; ok {
+		return source
+	}
+	return r.Default
+}
+
+// Assign records the map selected by the metadata of a stream with the given
+// UID, so that later selections by UID alone return the same map
+func (r *DetmapRegistry) Assign(uid uint64, metadata map[string][]byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	source := metadata[r.metadataKey()]
+	if len(source) == 0 {
+		return
+	}
+	if r.assigned == nil {
+		r.assigned = make(map[uint64]string)
+	}
+	r.assigned[uid] = string(source)
+}
+
+func (r *DetmapRegistry) metadataKey() string {
+	if r.MetadataKey == "" {
+		return "Detmap"
+	}
+	return r.MetadataKey
+}
+
+// Select returns the map selected for a stream with the given UID and
+// metadata
+func (r *DetmapRegistry) Select(uid uint64, metadata map[string][]byte) *detmapmodel.Map {
+	source := r.Source(uid, metadata)
+	detmap, err := r.Get(source)
+	if err != nil {
+		log.Printf("unable to load detector map \"%v\": %v\n", source, err)
+	}
+	return detmap
+}
+
+// Mapper returns a Mapper for a stream with the given UID and metadata that
+// follows reloads of the selected map
+func (r *DetmapRegistry) Mapper(uid uint64, metadata map[string][]byte) *Mapper {
+	source := r.Source(uid, metadata)
+	return &Mapper{
+		UID:      uid,
+		registry: r,
+		source:   source,
+		detmap:   r.Select(uid, metadata),
+	}
+}
+
+// SelectForMetadata returns the map selected for a stream with the given
+// metadata, taking the UID from the metadata
+func (r *DetmapRegistry) SelectForMetadata(metadata map[string][]byte) *detmapmodel.Map {
+	var uid uint64
+	if uidBytes := metadata["UID"]; len(uidBytes) == 8 {
+		uid = binary.BigEndian.Uint64(uidBytes)
+	}
+	return r.Select(uid, metadata)
+}
+
+// ParseByUID parses a comma-separated list of <hex UID>=<source> pairs into
+// ByUID
+func (r *DetmapRegistry) ParseByUID(list string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		fields := strings.SplitN(pair, "=", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("bad detector map selection \"%v\"", pair)
+		}
+		var uid uint64
+		if _, err := fmt.Sscanf(fields[0], "%x", &uid); err != nil {
+			return fmt.Errorf("bad UID \"%v\": %v", fields[0], err)
+		}
+		if r.ByUID == nil {
+			r.ByUID = make(map[uint64]string)
+		}
+		r.ByUID[uid] = fields[1]
+	}
+	return nil
+}
+
+// LoadDetmap reads and unmarshals a map from source.  The returned
+// modification time is zero for packed maps.
+func LoadDetmap(source, credentials string) (*detmapmodel.Map, time.Time, error) {
+	var detmapBytes []byte
+	var modTime time.Time
+	var err error
+
+	if path, ok := detmapFilePath(source); ok {
+		var info os.FileInfo
+		info, err = os.Stat(path)
+		if err == nil {
+			modTime = info.ModTime()
+			detmapBytes, err = ioutil.ReadFile(path)
+		}
+	} else if thisUrl, urlErr := url.Parse(source); urlErr == nil && thisUrl.Scheme != "" {
+		ctx, cancel := context.WithTimeout(ServerContext(context.Background()), time.Minute)
+		defer cancel()
+		modTime, err = detmapModTime(source, credentials)
+		if err == nil {
+			detmapBytes, err = ReadObject(ctx, source, credentials)
+		}
+	} else if DetmapBox.Has(source) {
+		detmapBytes, err = DetmapBox.Find(source)
+	} else {
+		detmapBytes, err = DetmapLiteBox.Find(source)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	detmap := &detmapmodel.Map{}
+	if err := proto.Unmarshal(detmapBytes, detmap); err != nil {
+		return nil, time.Time{}, err
+	}
+	return detmap, modTime, nil
+}
+
+// detmapFilePath returns the local path of a source if it refers to a file
+func detmapFilePath(source string) (string, bool) {
+	if strings.HasPrefix(source, "file://") {
+		thisUrl, err := url.Parse(source)
+		if err != nil {
+			return "", false
+		}
+		return filepath.Clean(fmt.Sprintf("%v/%v", thisUrl.Host, strings.TrimLeft(thisUrl.Path, "/"))), true
+	}
+	if strings.Contains(source, "://") {
+		return "", false
+	}
+	if _, err := os.Stat(source); err == nil {
+		return source, true
+	}
+	return "", false
+}
+
+func detmapModTime(source, credentials string) (time.Time, error) {
+	if path, ok := detmapFilePath(source); ok {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+	if strings.Contains(source, "://") {
+		ctx, cancel := context.WithTimeout(ServerContext(context.Background()), 10*time.Second)
+		defer cancel()
+		return ObjectModTime(ctx, source, credentials)
+	}
+	return time.Time{}, nil
+}
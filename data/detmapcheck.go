@@ -0,0 +1,200 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"fmt"
+	"sort"
+
+	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
+)
+
+// DetmapProblem describes an inconsistency found in a detector map.  Warnings
+// are problems that do not prevent the map from being used.
+type DetmapProblem struct {
+	Warning bool
+	Msg     string
+}
+
+func (p DetmapProblem) String() string {
+	if p.Warning {
+		return "warning: " + p.Msg
+	}
+	return "error: " + p.Msg
+}
+
+// ValidateDetmap checks a detector map for channels that map to the same axis
+// channel, missing calibrations, unresolved detector configs, and image
+// configs with dimensions that do not match the channel count.
+//
+// Calibrations are keyed by the UID of an HPS, the low 32 bits of its id in
+// the data, while HPS configs, and the channel lists of detector configs, are
+// keyed by configuration id, the high 32 bits.  The map does not record which
+// UIDs are installed with which configuration, so a missing calibration of a
+// particular HPS cannot be found from the map alone, and is only reported
+// when the map has no calibrations at all.
+func ValidateDetmap(detmap *detmapmodel.Map) []DetmapProblem {
+	var problems []DetmapProblem
+	errorf := func(format string, a ...interface{}) {
+		problems = append(problems, DetmapProblem{Msg: fmt.Sprintf(format, a...)})
+	}
+	warnf := func(format string, a ...interface{}) {
+		problems = append(problems, DetmapProblem{Warning: true, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	type axisChannel struct {
+		axis, channel uint32
+	}
+	type hpsChannel struct {
+		hps, channel uint32
+	}
+
+	// HPS configs of the same detector config map into the same axes.
+	// Channel counts are taken from the highest mapped channel, since
+	// reconstruction matrices are indexed by HPS channel.
+	mappedBy := make(map[uint32]map[axisChannel]hpsChannel)
+	detChannels := make(map[uint32]uint32)
+	var maxChannels uint32
+
+	for _, hpsId := range sortedKeys(detmap.HpsConfig) {
+		hpsConfig := detmap.HpsConfig[hpsId]
+
+		if _, ok := detmap.DetConfig[hpsConfig.DetConfig]; !ok {
+			errorf("hps_config %v references det_config %v, which does not exist", hpsId, hpsConfig.DetConfig)
+		}
+		if hpsConfig.CurrentConv == 0 {
+			warnf("hps_config %v has zero current_conv, so uncalibrated channels read zero", hpsId)
+		}
+		if hpsConfig.SamplePeriod < 0 {
+			errorf("hps_config %v has negative sample_period %v", hpsId, hpsConfig.SamplePeriod)
+		}
+		if mappedBy[hpsConfig.DetConfig] == nil {
+			mappedBy[hpsConfig.DetConfig] = make(map[axisChannel]hpsChannel)
+		}
+		mapped := mappedBy[hpsConfig.DetConfig]
+
+		for _, chanNum := range sortedKeys(hpsConfig.Channel) {
+			chanConfig := hpsConfig.Channel[chanNum]
+			if chanNum >= maxChannels {
+				maxChannels = chanNum + 1
+			}
+			if chanNum >= detChannels[hpsConfig.DetConfig] {
+				detChannels[hpsConfig.DetConfig] = chanNum + 1
+			}
+			if len(chanConfig.PadX) != len(chanConfig.PadY) {
+				errorf("hps_config %v channel %v has %v pad_x but %v pad_y", hpsId, chanNum, len(chanConfig.PadX), len(chanConfig.PadY))
+			}
+
+			key := axisChannel{chanConfig.Axis, chanConfig.AxisChannel}
+			if other, ok := mapped[key]; ok {
+				errorf(
+					"hps_config %v channel %v and hps_config %v channel %v both map to axis %v channel %v",
+					other.hps, other.channel, hpsId, chanNum, key.axis, key.channel,
+				)
+			} else {
+				mapped[key] = hpsChannel{hpsId, chanNum}
+			}
+		}
+	}
+
+	// calibrations cannot be matched to HPS configs, see above
+	if len(detmap.HpsConfig) > 0 && len(detmap.HpsCalibration) == 0 {
+		warnf("no hps_calibration entries, so all channels use the nominal current_conv")
+	}
+	for _, hpsId := range sortedKeys(detmap.HpsCalibration) {
+		calib := detmap.HpsCalibration[hpsId]
+		if uint32(len(calib.CurrentConv)) < maxChannels {
+			warnf(
+				"hps_calibration %v has %v current_conv values for up to %v channels",
+				hpsId, len(calib.CurrentConv), maxChannels,
+			)
+		}
+		for i, conv := range calib.CurrentConv {
+			if conv == 0 {
+				warnf("hps_calibration %v channel %v has zero current_conv", hpsId, i)
+			}
+		}
+	}
+
+	for _, detId := range sortedKeys(detmap.DetConfig) {
+		detConfig := detmap.DetConfig[detId]
+		nChannels := int(detChannels[detId])
+
+		for i, imageConfig := range detConfig.ImageConfig {
+			where := fmt.Sprintf("det_config %v image_config %v", detId, i)
+
+			if len(imageConfig.XPos) != len(imageConfig.YPos) {
+				errorf("%v has %v x_pos but %v y_pos", where, len(imageConfig.XPos), len(imageConfig.YPos))
+			}
+			if len(imageConfig.LinEstT) > 0 {
+				if nChannels > 0 && len(imageConfig.LinEstT) != nChannels {
+					errorf("%v lin_est_t has %v rows for %v channels", where, len(imageConfig.LinEstT), nChannels)
+				}
+				for j, row := range imageConfig.LinEstT {
+					if len(row.Array) != len(imageConfig.XPos) {
+						errorf("%v lin_est_t row %v has %v columns for %v positions", where, j, len(row.Array), len(imageConfig.XPos))
+						break
+					}
+				}
+			}
+
+			nPix := 0
+			for _, geom := range imageConfig.Geometry {
+				nPix += int(geom.N * geom.M)
+			}
+			if len(imageConfig.G) > 0 {
+				nCols := len(imageConfig.G[0].Array)
+				for j, row := range imageConfig.G {
+					if len(row.Array) != nCols {
+						errorf("%v g row %v has %v columns, but row 0 has %v", where, j, len(row.Array), nCols)
+						break
+					}
+				}
+				if nPix > 0 && nCols != nPix && len(imageConfig.G) != nPix {
+					errorf("%v g is %vx%v for %v pixels", where, len(imageConfig.G), nCols, nPix)
+				}
+			}
+			if len(imageConfig.ChannelList) > 0 && nChannels > 0 && len(imageConfig.ChannelList) != nChannels {
+				warnf("%v channel_list has %v entries for %v channels", where, len(imageConfig.ChannelList), nChannels)
+			}
+			for _, desc := range imageConfig.ChannelList {
+				hpsConfig, ok := detmap.HpsConfig[desc.Hps]
+				if !ok {
+					errorf("%v channel_list references hps_config %v, which does not exist", where, desc.Hps)
+					continue
+				}
+				if _, ok := hpsConfig.Channel[desc.Chan]; !ok {
+					warnf("%v channel_list references hps_config %v channel %v, which is not mapped", where, desc.Hps, desc.Chan)
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+func sortedKeys(m interface{}) []uint32 {
+	var keys []uint32
+	switch m := m.(type) {
+	case map[uint32]*detmapmodel.HpsConfig:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[uint32]*detmapmodel.HpsConfig_ChannelConfig:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[uint32]*detmapmodel.HpsCalibration:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[uint32]*detmapmodel.DetectorConfig:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -5,16 +5,24 @@
 package data
 
 import (
+	"context"
+
 	"github.com/proio-org/go-proio"
 )
 
 type EventProcessor func(*proio.Event)
 
+// CheckedEventProcessor is an EventProcessor that can fail
+type CheckedEventProcessor func(*proio.Event) error
+
+// EventOp runs EventProcessor, or CheckedEventProcessor if it is set, on up
+// to Concurrency events at a time while preserving event order
 type EventOp struct {
-	Description    string
-	EventProcessor EventProcessor
-	Concurrency    int
-	MaxEventBuf    int
+	Description           string
+	EventProcessor        EventProcessor
+	CheckedEventProcessor CheckedEventProcessor
+	Concurrency           int
+	MaxEventBuf           int
 }
 
 func (o EventOp) GetDescription() string {
@@ -22,6 +30,15 @@ func (o EventOp) GetDescription() string {
 }
 
 func (o EventOp) Run(input <-chan *proio.Event) <-chan *proio.Event {
+	return o.RunContext(context.Background(), input, logFail)
+}
+
+type eventResult struct {
+	index uint64
+	err   error
+}
+
+func (o EventOp) RunContext(ctx context.Context, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
 	if o.Concurrency == 0 {
 		o.Concurrency = *concurrency
 	}
@@ -30,6 +47,14 @@ func (o EventOp) Run(input <-chan *proio.Event) <-chan *proio.Event {
 		o.MaxEventBuf = *maxEventBuf
 	}
 
+	process := o.CheckedEventProcessor
+	if process == nil {
+		process = func(event *proio.Event) error {
+			o.EventProcessor(event)
+			return nil
+		}
+	}
+
 	output := make(chan *proio.Event, o.MaxEventBuf)
 
 	go func() {
@@ -37,39 +62,66 @@ func (o EventOp) Run(input <-chan *proio.Event) <-chan *proio.Event {
 
 		procEvents := make(map[uint64]*proio.Event)
 		doneEvents := make(map[uint64]*proio.Event)
-		done := make(chan uint64)
+		done := make(chan eventResult)
 		ackDone := func() {
-			index := <-done
-			doneEvents[index] = procEvents[index]
-			delete(procEvents, index)
+			result := <-done
+			if result.err != nil {
+				fail(result.err)
+				doneEvents[result.index] = nil
+			} else {
+				doneEvents[result.index] = procEvents[result.index]
+			}
+			delete(procEvents, result.index)
 		}
-		defer close(done)
+		// wait for events still being processed before returning
+		defer func() {
+			for len(procEvents) > 0 {
+				ackDone()
+			}
+		}()
 
 		nRead := uint64(0)
 		nWritten := uint64(0)
-		writeOut := func() {
+		writeOut := func() bool {
 			for {
-				if event, ok := doneEvents[nWritten]; ok {
-					output <- event
-					delete(doneEvents, nWritten)
-					nWritten++
-				} else {
-					break
+				event, ok := doneEvents[nWritten]
+				if !ok {
+					return true
+				}
+				if event != nil {
+					select {
+					case output <- event:
+					case <-ctx.Done():
+						return false
+					}
 				}
+				delete(doneEvents, nWritten)
+				nWritten++
 			}
 		}
 
-		for event := range input {
-			go func(event *proio.Event, done chan<- uint64, index uint64) {
-				o.EventProcessor(event)
-				done <- index
+		for {
+			var event *proio.Event
+			select {
+			case event = <-input:
+			case <-ctx.Done():
+				return
+			}
+			if event == nil {
+				break
+			}
+
+			go func(event *proio.Event, done chan<- eventResult, index uint64) {
+				done <- eventResult{index, process(event)}
 			}(event, done, nRead)
 			procEvents[nRead] = event
 			nRead++
 
 			for len(procEvents) >= o.Concurrency || len(doneEvents) >= o.MaxEventBuf {
 				ackDone()
-				writeOut()
+				if !writeOut() {
+					return
+				}
 			}
 		}
 
@@ -6,19 +6,108 @@ package data
 
 import (
 	"encoding/binary"
+	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rditech/rdi-live/model/rdi/currentmode"
 
 	"github.com/proio-org/go-proio"
 )
 
+// DefaultSamplePeriod is the time in seconds between FPGA samples of an HPS
+// whose config does not set a sample period
+const DefaultSamplePeriod = 171799.0 / (1 << 32)
+
+// EpochKey is the metadata key of the epoch anchor stamped by the DAQ, which
+// holds the UTC time of a sample as "<RFC 3339 time> <sample number>"
+const EpochKey = "Epoch"
+
+// FormatEpoch returns an epoch anchor for the metadata of a stream
+func FormatEpoch(t time.Time, sampleNumber uint32) []byte {
+	return []byte(fmt.Sprintf("%v %v", t.UTC().Format(time.RFC3339Nano), sampleNumber))
+}
+
+// ParseEpoch parses an epoch anchor from the metadata of a stream
+func ParseEpoch(epoch []byte) (time.Time, uint32, error) {
+	fields := strings.Fields(string(epoch))
+	if len(fields) != 2 {
+		return time.Time{}, 0, fmt.Errorf("bad epoch \"%v\"", string(epoch))
+	}
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("bad epoch \"%v\": %v", string(epoch), err)
+	}
+	sampleNumber, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("bad epoch \"%v\": %v", string(epoch), err)
+	}
+	return t, uint32(sampleNumber), nil
+}
+
+// SampleClock converts FPGA sample numbers to 32.32 fixed-point timestamps.
+// With an Epoch, timestamps are in seconds since the Unix epoch, counting
+// Period seconds per sample from the EpochSample taken at the Epoch time.
+// Sample numbers are then taken to be within 2^31 samples of EpochSample, so
+// the DAQ stamps a new epoch regularly.  Without an Epoch, timestamps count
+// from sample number zero.
+type SampleClock struct {
+	Period      float64
+	Epoch       time.Time
+	EpochSample uint32
+}
+
+// Timestamp returns the timestamp of a sample number
+func (c *SampleClock) Timestamp(sampleNumber uint32) uint64 {
+	period := c.Period
+	if period <= 0 {
+		period = DefaultSamplePeriod
+	}
+	if c.Epoch.IsZero() {
+		return uint64(math.Round(float64(sampleNumber) * period * (1 << 32)))
+	}
+
+	epoch := uint64(c.Epoch.Unix())<<32 + uint64(c.Epoch.Nanosecond())<<32/1e9
+	offset := float64(int32(sampleNumber-c.EpochSample)) * period
+	return epoch + uint64(int64(math.Round(offset*(1<<32))))
+}
+
+// FrameAssembler assembles the raw samples of an event into a frame, timed by
+// the sample period of the HPS config and the epoch anchor of the stream.  If
+// Mapper is nil, the map is selected by DefaultDetmaps for each event.
+type FrameAssembler struct {
+	Mapper *Mapper
+}
+
+// AssembleFrame assembles frames using the map selected by DefaultDetmaps for
+// the event metadata
 func AssembleFrame(event *proio.Event) {
+	(&FrameAssembler{}).Assemble(event)
+}
+
+// Assemble is an EventProcessor that assembles the frame of an event
+func (a *FrameAssembler) Assemble(event *proio.Event) {
 	if len(event.Metadata["UID"]) < 8 {
 		return
 	}
 	uid := binary.BigEndian.Uint64(event.Metadata["UID"])
 
+	mapper := a.Mapper
+	if mapper == nil {
+		mapper = NewMapper(uid, DefaultDetmaps.SelectForMetadata(event.Metadata))
+	}
+	clock := &SampleClock{Period: mapper.SamplePeriod()}
+	if epoch := event.Metadata[EpochKey]; len(epoch) > 0 {
+		var err error
+		clock.Epoch, clock.EpochSample, err = ParseEpoch(epoch)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
 	frame := &currentmode.Frame{}
 	for i, sampleId := range event.TaggedEntries("Sample") {
 		hpsSample, ok := event.GetEntry(sampleId).(*currentmode.HpsSample)
@@ -30,7 +119,7 @@ func AssembleFrame(event *proio.Event) {
 			continue
 		}
 
-		sampleTs := uint64(hpsSample.SampleNumber) * 171799
+		sampleTs := clock.Timestamp(hpsSample.SampleNumber)
 		if i == 0 {
 			frame.Timestamp = sampleTs
 		}
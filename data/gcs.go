@@ -6,6 +6,11 @@ package data
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/proio-org/go-proio"
@@ -34,6 +39,9 @@ func ListGcsRuns(ctx context.Context, bucket, prefix string, credentials []byte)
 		if err != nil {
 			return nil, err
 		}
+		if strings.HasSuffix(objAttrs.Name, TimeIndexSuffix) {
+			continue
+		}
 		runList = append(runList, &RunObject{Name: objAttrs.Name})
 	}
 
@@ -41,6 +49,30 @@ func ListGcsRuns(ctx context.Context, bucket, prefix string, credentials []byte)
 }
 
 func CreateGcsReader(ctx context.Context, bucket, name string, credentials []byte) (*proio.Reader, error) {
+	object, err := OpenGcsObject(ctx, bucket, name, credentials)
+	if err != nil {
+		return nil, err
+	}
+	proioReader := proio.NewReader(object)
+	proioReader.DeferUntilClose(func() { object.Close() })
+	return proioReader, nil
+}
+
+type gcsObjectReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+func (r gcsObjectReader) Close() error {
+	err := r.Reader.Close()
+	if clientErr := r.client.Close(); err == nil {
+		err = clientErr
+	}
+	return err
+}
+
+// OpenGcsObject opens an object for reading
+func OpenGcsObject(ctx context.Context, bucket, name string, credentials []byte) (io.ReadCloser, error) {
 	client, err := storage.NewClient(
 		ctx,
 		option.WithCredentialsJSON(credentials),
@@ -51,15 +83,56 @@ func CreateGcsReader(ctx context.Context, bucket, name string, credentials []byt
 
 	objectReader, err := client.Bucket(bucket).Object(name).NewReader(ctx)
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
-	proioReader := proio.NewReader(objectReader)
-	proioReader.DeferUntilClose(func() { objectReader.Close() })
-	proioReader.DeferUntilClose(func() { client.Close() })
-	return proioReader, nil
+	return gcsObjectReader{objectReader, client}, nil
+}
+
+// OpenGcsObjectAt opens an object for reading from a byte offset
+func OpenGcsObjectAt(ctx context.Context, bucket, name string, offset int64, credentials []byte) (io.ReadCloser, error) {
+	client, err := storage.NewClient(
+		ctx,
+		option.WithCredentialsJSON(credentials),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	objectReader, err := client.Bucket(bucket).Object(name).NewRangeReader(ctx, offset, -1)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return gcsObjectReader{objectReader, client}, nil
 }
 
 func CreateGcsWriter(ctx context.Context, bucket, name string, credentials []byte) (*proio.Writer, error) {
+	object, err := CreateGcsObject(ctx, bucket, name, credentials)
+	if err != nil {
+		return nil, err
+	}
+	proioWriter := proio.NewWriter(object)
+	proioWriter.DeferUntilClose(object.Close)
+	return proioWriter, nil
+}
+
+type gcsObjectWriter struct {
+	*storage.Writer
+	client *storage.Client
+}
+
+func (w gcsObjectWriter) Close() error {
+	err := w.Writer.Close()
+	if clientErr := w.client.Close(); err == nil {
+		err = clientErr
+	}
+	return err
+}
+
+// CreateGcsObject creates an object for writing.  The object is complete once
+// closed.
+func CreateGcsObject(ctx context.Context, bucket, name string, credentials []byte) (io.WriteCloser, error) {
 	client, err := storage.NewClient(
 		ctx,
 		option.WithCredentialsJSON(credentials),
@@ -69,11 +142,68 @@ func CreateGcsWriter(ctx context.Context, bucket, name string, credentials []byt
 	}
 
 	objectWriter := client.Bucket(bucket).Object(name).NewWriter(ctx)
+	return gcsObjectWriter{objectWriter, client}, nil
+}
+
+func ReadGcsObject(ctx context.Context, bucket, name string, credentials []byte) ([]byte, error) {
+	client, err := storage.NewClient(
+		ctx,
+		option.WithCredentialsJSON(credentials),
+	)
 	if err != nil {
 		return nil, err
 	}
-	proioWriter := proio.NewWriter(objectWriter)
-	proioWriter.DeferUntilClose(objectWriter.Close)
-	proioWriter.DeferUntilClose(client.Close)
-	return proioWriter, nil
+	defer client.Close()
+
+	objectReader, err := client.Bucket(bucket).Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer objectReader.Close()
+
+	return ioutil.ReadAll(objectReader)
+}
+
+func GcsObjectModTime(ctx context.Context, bucket, name string, credentials []byte) (time.Time, error) {
+	client, err := storage.NewClient(
+		ctx,
+		option.WithCredentialsJSON(credentials),
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucket).Object(name).Attrs(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return attrs.Updated, nil
+}
+
+// gcsStorage is the storage backend for gs://bucket/name URLs
+type gcsStorage struct{}
+
+func (gcsStorage) List(ctx context.Context, u *url.URL, credentials string) ([]*RunObject, error) {
+	return ListGcsRuns(ctx, u.Host, strings.TrimLeft(u.Path, "/"), []byte(credentials))
+}
+
+func (gcsStorage) Open(ctx context.Context, u *url.URL, credentials string) (io.ReadCloser, error) {
+	return OpenGcsObject(ctx, u.Host, strings.TrimLeft(u.Path, "/"), []byte(credentials))
+}
+
+func (gcsStorage) OpenAt(ctx context.Context, u *url.URL, credentials string, offset int64) (io.ReadCloser, error) {
+	return OpenGcsObjectAt(ctx, u.Host, strings.TrimLeft(u.Path, "/"), offset, []byte(credentials))
+}
+
+func (gcsStorage) Create(ctx context.Context, u *url.URL, credentials string) (io.WriteCloser, error) {
+	return CreateGcsObject(ctx, u.Host, strings.TrimLeft(u.Path, "/"), []byte(credentials))
+}
+
+func (gcsStorage) ModTime(ctx context.Context, u *url.URL, credentials string) (time.Time, error) {
+	return GcsObjectModTime(ctx, u.Host, strings.TrimLeft(u.Path, "/"), []byte(credentials))
+}
+
+func init() {
+	RegisterStorage("gs", gcsStorage{})
 }
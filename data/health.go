@@ -0,0 +1,268 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+
+	"github.com/proio-org/go-proio"
+)
+
+type ChannelFlag int32
+
+const (
+	ChannelOk ChannelFlag = iota
+	ChannelDead
+	ChannelStuck
+	ChannelNoisy
+)
+
+func (f ChannelFlag) String() string {
+	switch f {
+	case ChannelDead:
+		return "dead"
+	case ChannelStuck:
+		return "stuck"
+	case ChannelNoisy:
+		return "noisy"
+	}
+	return "ok"
+}
+
+// ChannelHealth tracks the mean and RMS of each mapped channel over windows
+// of Window samples, and flags channels by comparing them to the median of the
+// other channels on the same axis.  Only the samples of frames with low
+// correlation between axes are counted, as for Pedestals with the same
+// CovFrac, so that the channels carrying a beam are not taken for noisy ones.
+// A channel is stuck if its RMS is below
+// StuckFrac of the median RMS, noisy if its RMS is above NoisyFactor times the
+// median RMS, and dead if there is signal on the axis but the magnitude of its
+// mean is below DeadFrac of the median.
+//
+// At the end of each window a "Health" frame is added to the event with a
+// single sample, with the flag of each channel stored in Channel and its RMS
+// in FloatChannel.  If Mask is set, flagged channels are also masked in the
+// mapped frames, either by zeroing them or, if Interpolate is set, by
+// replacing them with the average of their healthy neighbours, and axis sums
+// are recomputed.
+type ChannelHealth struct {
+	Window      int
+	StuckFrac   float64
+	NoisyFactor float64
+	DeadFrac    float64
+	CovFrac     float64
+	Mask        bool
+	Interpolate bool
+
+	sums, sumSqs [][]float64
+	nSamples     int
+	flags        [][]ChannelFlag
+	mutex        sync.Mutex
+}
+
+func (h *ChannelHealth) Check(input <-chan *proio.Event, output chan<- *proio.Event) {
+	if h.Window == 0 {
+		h.Window = 5000
+	}
+	if h.StuckFrac == 0 {
+		h.StuckFrac = 0.01
+	}
+	if h.NoisyFactor == 0 {
+		h.NoisyFactor = 5
+	}
+	if h.DeadFrac == 0 {
+		h.DeadFrac = 0.1
+	}
+	if h.CovFrac == 0 {
+		h.CovFrac = 0.1
+	}
+	covFrac2 := h.CovFrac * h.CovFrac
+
+	for event := range input {
+		for _, entryId := range event.TaggedEntries("Health") {
+			event.RemoveEntry(entryId)
+		}
+
+		for _, entryId := range event.TaggedEntries("Mapped") {
+			frame, ok := event.GetEntry(entryId).(*currentmode.Frame)
+			if !ok {
+				continue
+			}
+
+			if len(frame.Sample) == 0 {
+				continue
+			}
+
+			nAxes := len(frame.Sample[0].Axis)
+			thres := float32(math.Pow(covFrac2, float64(nAxes*(nAxes-1)/2)))
+			quiet := frame.Correlation < thres
+			for _, sample := range frame.Sample {
+				if quiet && !sample.Placeholder {
+					h.accumulate(sample)
+				}
+				if h.Mask {
+					h.mask(sample)
+				}
+			}
+
+			if h.nSamples >= h.Window {
+				event.AddEntry("Health", h.evaluate(frame.Timestamp))
+			}
+		}
+
+		output <- event
+	}
+}
+
+func (h *ChannelHealth) accumulate(sample *currentmode.Sample) {
+	for i, axis := range sample.Axis {
+		for len(h.sums) <= i {
+			h.sums = append(h.sums, nil)
+			h.sumSqs = append(h.sumSqs, nil)
+		}
+		if len(h.sums[i]) < len(axis.FloatChannel) {
+			n := len(axis.FloatChannel) - len(h.sums[i])
+			h.sums[i] = append(h.sums[i], make([]float64, n)...)
+			h.sumSqs[i] = append(h.sumSqs[i], make([]float64, n)...)
+		}
+
+		for j, val := range axis.FloatChannel {
+			h.sums[i][j] += float64(val)
+			h.sumSqs[i][j] += float64(val) * float64(val)
+		}
+	}
+	h.nSamples++
+}
+
+func (h *ChannelHealth) mask(sample *currentmode.Sample) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, axis := range sample.Axis {
+		if i >= len(h.flags) {
+			break
+		}
+		flags := h.flags[i]
+
+		masked := false
+		for j := range axis.FloatChannel {
+			if j >= len(flags) || flags[j] == ChannelOk {
+				continue
+			}
+			masked = true
+
+			var val, n float32
+			if h.Interpolate {
+				if j > 0 && flags[j-1] == ChannelOk {
+					val += axis.FloatChannel[j-1]
+					n++
+				}
+				if j+1 < len(axis.FloatChannel) && (j+1 >= len(flags) || flags[j+1] == ChannelOk) {
+					val += axis.FloatChannel[j+1]
+					n++
+				}
+			}
+			if n > 0 {
+				val /= n
+			}
+			axis.FloatChannel[j] = val
+		}
+
+		if masked {
+			axis.Sum = 0
+			for _, val := range axis.FloatChannel {
+				axis.Sum += val
+			}
+		}
+	}
+}
+
+// evaluate flags the channels using the current window, starts a new window,
+// and returns the health frame
+func (h *ChannelHealth) evaluate(timestamp uint64) *currentmode.Frame {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	healthSample := &currentmode.Sample{}
+	h.flags = make([][]ChannelFlag, len(h.sums))
+	n := float64(h.nSamples)
+
+	for i := range h.sums {
+		nChans := len(h.sums[i])
+		rms := make([]float64, nChans)
+		absMean := make([]float64, nChans)
+		for j := 0; j < nChans; j++ {
+			mean := h.sums[i][j] / n
+			absMean[j] = math.Abs(mean)
+			if variance := h.sumSqs[i][j]/n - mean*mean; variance > 0 {
+				rms[j] = math.Sqrt(variance)
+			}
+		}
+		medRms := median(rms)
+		medAbsMean := median(absMean)
+
+		axis := &currentmode.AxisSample{
+			Channel:      make([]int32, nChans),
+			FloatChannel: make([]float32, nChans),
+		}
+		h.flags[i] = make([]ChannelFlag, nChans)
+		for j := 0; j < nChans; j++ {
+			flag := ChannelOk
+			switch {
+			case medRms > 0 && rms[j] < h.StuckFrac*medRms:
+				flag = ChannelStuck
+			case medRms > 0 && rms[j] > h.NoisyFactor*medRms:
+				flag = ChannelNoisy
+			case medAbsMean > medRms && absMean[j] < h.DeadFrac*medAbsMean:
+				flag = ChannelDead
+			}
+			h.flags[i][j] = flag
+			axis.Channel[j] = int32(flag)
+			axis.FloatChannel[j] = float32(rms[j])
+		}
+		healthSample.Axis = append(healthSample.Axis, axis)
+
+		for j := range h.sums[i] {
+			h.sums[i][j] = 0
+			h.sumSqs[i][j] = 0
+		}
+	}
+	h.nSamples = 0
+
+	return &currentmode.Frame{
+		Timestamp: timestamp,
+		Sample:    []*currentmode.Sample{healthSample},
+	}
+}
+
+// Flags returns the flag of each channel by axis from the last complete
+// window
+func (h *ChannelHealth) Flags() [][]ChannelFlag {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	flags := make([][]ChannelFlag, len(h.flags))
+	for i, axis := range h.flags {
+		flags[i] = append([]ChannelFlag(nil), axis...)
+	}
+	return flags
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
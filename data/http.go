@@ -0,0 +1,136 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// httpStorage is the read-only storage backend for http(s) URLs.  Runs are
+// listed from the links to .proio files in the index page of a URL, such as
+// the directory listings of most web servers.  Credentials, if given, are
+// sent as the Authorization header.
+type httpStorage struct{}
+
+var hrefPattern = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+func (httpStorage) get(ctx context.Context, method string, u *url.URL, credentials string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != "" {
+		req.Header.Set("Authorization", credentials)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v %v: %v", method, u, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s httpStorage) List(ctx context.Context, u *url.URL, credentials string) ([]*RunObject, error) {
+	dir := *u
+	if !strings.HasSuffix(dir.Path, "/") {
+		dir.Path += "/"
+		dir.RawPath = ""
+	}
+
+	resp, err := s.get(ctx, http.MethodGet, &dir, credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	page, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*RunObject
+	seen := make(map[string]bool)
+	for _, match := range hrefPattern.FindAllSubmatch(page, -1) {
+		link, err := dir.Parse(string(match[1]))
+		if err != nil || link.Host != dir.Host || path.Ext(link.Path) != ".proio" {
+			continue
+		}
+		name := strings.TrimPrefix(link.Path, dir.Path)
+		if name == link.Path || seen[name] {
+			continue
+		}
+		seen[name] = true
+		runs = append(runs, &RunObject{Name: name})
+	}
+	return runs, nil
+}
+
+func (s httpStorage) Open(ctx context.Context, u *url.URL, credentials string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, http.MethodGet, u, credentials)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OpenAt requests the object from offset on, reading up to the offset if the
+// server does not support ranges
+func (s httpStorage) OpenAt(ctx context.Context, u *url.URL, credentials string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != "" {
+		req.Header.Set("Authorization", credentials)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%v %v: %v", http.MethodGet, u, resp.Status)
+}
+
+func (httpStorage) Create(ctx context.Context, u *url.URL, credentials string) (io.WriteCloser, error) {
+	return nil, ErrReadOnly
+}
+
+func (s httpStorage) ModTime(ctx context.Context, u *url.URL, credentials string) (time.Time, error) {
+	resp, err := s.get(ctx, http.MethodHead, u, credentials)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	return http.ParseTime(resp.Header.Get("Last-Modified"))
+}
+
+func init() {
+	RegisterStorage("http", httpStorage{})
+	RegisterStorage("https", httpStorage{})
+}
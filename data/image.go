@@ -0,0 +1,293 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"log"
+	"math"
+
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
+
+	"github.com/proio-org/go-proio"
+	"gonum.org/v1/gonum/mat"
+)
+
+// ImageReconstruction reconstructs a beam image for each mapped frame using
+// the system matrix G of the first image config of the detector.  If the
+// image config has a nonzero sigma_l2, the image is the L2-regularized least
+// squares solution with a Gaussian prior of standard deviation sigma_l2 on
+// the pixel values.  Otherwise, Iterations iterations of MLEM are used.  If
+// the image config specifies a diffusion convolution, G is convolved with a
+// Gaussian kernel of width max_diff_sigma before reconstruction.
+//
+// The image is added to the event as an "Image" frame with a single sample.
+// Each axis of the sample holds the pixels of one image geometry, with the
+// x index running fastest.
+type ImageReconstruction struct {
+	Iterations int
+
+	hpsConfig *detmapmodel.HpsConfig
+	channels  []uint32
+	geometry  []*detmapmodel.DetectorConfig_ImageConfig_Geometry
+
+	g           *mat.Dense
+	sensitivity *mat.VecDense
+	l2Est       *mat.Dense
+}
+
+func NewImageReconstruction(mapper *Mapper) *ImageReconstruction {
+	r := &ImageReconstruction{
+		Iterations: 20,
+		hpsConfig:  mapper.HpsConfig(),
+	}
+
+	imageConfs := mapper.ImageConfigs()
+	if len(imageConfs) == 0 || r.hpsConfig == nil {
+		return r
+	}
+	imageConf := imageConfs[0]
+	if len(imageConf.G) == 0 || len(imageConf.G[0].Array) == 0 {
+		return r
+	}
+
+	nPix := 0
+	for _, geom := range imageConf.Geometry {
+		nPix += int(geom.N * geom.M)
+	}
+
+	g := mat.NewDense(len(imageConf.G), len(imageConf.G[0].Array), nil)
+	for i, row := range imageConf.G {
+		if len(row.Array) != len(imageConf.G[0].Array) {
+			log.Println("ragged image config system matrix")
+			return r
+		}
+		for j, val := range row.Array {
+			g.Set(i, j, float64(val))
+		}
+	}
+
+	// G maps pixels to channels, but accept it stored either way around
+	nRows, nCols := g.Dims()
+	if nPix == 0 {
+		nPix = nCols
+		r.geometry = []*detmapmodel.DetectorConfig_ImageConfig_Geometry{
+			{N: uint32(nPix), M: 1, Pitch: 1},
+		}
+	} else {
+		r.geometry = imageConf.Geometry
+	}
+	if nCols != nPix {
+		if nRows != nPix {
+			log.Printf("system matrix is %vx%v, but image has %v pixels\n", nRows, nCols, nPix)
+			return r
+		}
+		g = mat.DenseCopyOf(g.T())
+	}
+	nChans, _ := g.Dims()
+
+	r.channels = make([]uint32, nChans)
+	for i := range r.channels {
+		if i < len(imageConf.ChannelList) {
+			r.channels[i] = imageConf.ChannelList[i].Chan
+		} else {
+			r.channels[i] = uint32(i)
+		}
+	}
+
+	if imageConf.Conv == detmapmodel.DetectorConfig_ImageConfig_DIFFUSION && imageConf.MaxDiffSigma > 0 {
+		g = r.diffuse(g, float64(imageConf.MaxDiffSigma))
+	}
+	r.g = g
+
+	r.sensitivity = mat.NewVecDense(nPix, nil)
+	for j := 0; j < nPix; j++ {
+		r.sensitivity.SetVec(j, mat.Sum(g.ColView(j)))
+	}
+
+	if imageConf.SigmaL2 > 0 {
+		// (G^T G + lambda I)^-1 G^T = G^T (G G^T + lambda I)^-1, and the
+		// latter only needs the inverse of a channel-sized matrix
+		lambda := 1 / float64(imageConf.SigmaL2*imageConf.SigmaL2)
+		var ggt mat.Dense
+		ggt.Mul(g, g.T())
+		for i := 0; i < nChans; i++ {
+			ggt.Set(i, i, ggt.At(i, i)+lambda)
+		}
+		var inv mat.Dense
+		if err := inv.Inverse(&ggt); err != nil {
+			log.Println("unable to invert regularized system matrix:", err)
+			return r
+		}
+		r.l2Est = &mat.Dense{}
+		r.l2Est.Mul(g.T(), &inv)
+	}
+
+	return r
+}
+
+// diffuse returns G convolved with a Gaussian kernel of width sigma over the
+// pixels of each image geometry
+func (r *ImageReconstruction) diffuse(g *mat.Dense, sigma float64) *mat.Dense {
+	nChans, nPix := g.Dims()
+	diffused := mat.NewDense(nChans, nPix, nil)
+
+	offset := 0
+	for _, geom := range r.geometry {
+		n, m := int(geom.N), int(geom.M)
+		pitch := float64(geom.Pitch)
+		if pitch == 0 {
+			pitch = 1
+		}
+		reach := int(math.Ceil(3 * sigma / pitch))
+
+		for j0 := 0; j0 < m; j0++ {
+			for i0 := 0; i0 < n; i0++ {
+				p0 := offset + j0*n + i0
+
+				// the kernel is normalized so that each source pixel keeps
+				// its total response
+				var norm float64
+				weights := make(map[int]float64)
+				for j := j0 - reach; j <= j0+reach; j++ {
+					for i := i0 - reach; i <= i0+reach; i++ {
+						if i < 0 || i >= n || j < 0 || j >= m {
+							continue
+						}
+						dx := float64(i-i0) * pitch
+						dy := float64(j-j0) * pitch
+						w := math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+						weights[offset+j*n+i] = w
+						norm += w
+					}
+				}
+
+				for p, w := range weights {
+					w /= norm
+					for c := 0; c < nChans; c++ {
+						diffused.Set(c, p0, diffused.At(c, p0)+w*g.At(c, p))
+					}
+				}
+			}
+		}
+
+		offset += n * m
+	}
+
+	return diffused
+}
+
+func (r *ImageReconstruction) FillImage(event *proio.Event) {
+	if r.g == nil {
+		return
+	}
+
+	for _, entryId := range event.TaggedEntries("Image") {
+		event.RemoveEntry(entryId)
+	}
+
+	nChans, nPix := r.g.Dims()
+
+	for _, entryId := range event.TaggedEntries("Mapped") {
+		frame, ok := event.GetEntry(entryId).(*currentmode.Frame)
+		if !ok || len(frame.Sample) == 0 {
+			continue
+		}
+
+		qVec := mat.NewVecDense(nChans, nil)
+		nReadings := 0
+		for _, sample := range frame.Sample {
+			if sample.Placeholder {
+				continue
+			}
+			nReadings++
+			for i, hpsChan := range r.channels {
+				chanConfig := r.hpsConfig.Channel[hpsChan]
+				if chanConfig == nil || int(chanConfig.Axis) >= len(sample.Axis) {
+					continue
+				}
+				axis := sample.Axis[chanConfig.Axis]
+				if int(chanConfig.AxisChannel) >= len(axis.FloatChannel) {
+					continue
+				}
+				qVec.SetVec(i, qVec.AtVec(i)+float64(axis.FloatChannel[chanConfig.AxisChannel]))
+			}
+		}
+		if nReadings == 0 {
+			continue
+		}
+		qVec.ScaleVec(1/float64(nReadings), qVec)
+
+		var image *mat.VecDense
+		if r.l2Est != nil {
+			image = mat.NewVecDense(nPix, nil)
+			image.MulVec(r.l2Est, qVec)
+		} else {
+			image = r.mlem(qVec)
+		}
+
+		imageSample := &currentmode.Sample{}
+		offset := 0
+		for _, geom := range r.geometry {
+			size := int(geom.N * geom.M)
+			axis := &currentmode.AxisSample{
+				FloatChannel: make([]float32, size),
+			}
+			for i := range axis.FloatChannel {
+				axis.FloatChannel[i] = float32(image.AtVec(offset + i))
+				axis.Sum += axis.FloatChannel[i]
+			}
+			imageSample.Axis = append(imageSample.Axis, axis)
+			offset += size
+		}
+
+		event.AddEntry("Image", &currentmode.Frame{
+			Timestamp: frame.Timestamp,
+			Sample:    []*currentmode.Sample{imageSample},
+		})
+	}
+}
+
+func (r *ImageReconstruction) mlem(qVec *mat.VecDense) *mat.VecDense {
+	nChans, nPix := r.g.Dims()
+
+	var qSum float64
+	for i := 0; i < nChans; i++ {
+		if qVec.AtVec(i) < 0 {
+			qVec.SetVec(i, 0)
+		}
+		qSum += qVec.AtVec(i)
+	}
+
+	image := mat.NewVecDense(nPix, nil)
+	sensSum := mat.Sum(r.sensitivity)
+	if qSum == 0 || sensSum == 0 {
+		return image
+	}
+	for j := 0; j < nPix; j++ {
+		image.SetVec(j, qSum/sensSum)
+	}
+
+	proj := mat.NewVecDense(nChans, nil)
+	back := mat.NewVecDense(nPix, nil)
+	for iter := 0; iter < r.Iterations; iter++ {
+		proj.MulVec(r.g, image)
+		for i := 0; i < nChans; i++ {
+			if p := proj.AtVec(i); p > 0 {
+				proj.SetVec(i, qVec.AtVec(i)/p)
+			} else {
+				proj.SetVec(i, 0)
+			}
+		}
+		back.MulVec(r.g.T(), proj)
+		for j := 0; j < nPix; j++ {
+			if s := r.sensitivity.AtVec(j); s > 0 {
+				image.SetVec(j, image.AtVec(j)*back.AtVec(j)/s)
+			}
+		}
+	}
+
+	return image
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// localStorage is the storage backend for file://host/path URLs, where the
+// host and path together name a local file or directory.  If Recursive is
+// set, runs are listed from the whole directory tree by their paths relative
+// to the listed directory, and missing directories are created for new
+// objects.
+type localStorage struct {
+	Recursive bool
+}
+
+func localPath(u *url.URL) string {
+	return filepath.Clean(fmt.Sprintf("%v/%v", u.Host, strings.TrimLeft(u.Path, "/")))
+}
+
+func (s localStorage) List(ctx context.Context, u *url.URL, credentials string) ([]*RunObject, error) {
+	root := localPath(u)
+	if !s.Recursive {
+		files, err := filepath.Glob(root + "/*.proio")
+		if err != nil {
+			return nil, err
+		}
+		var runs []*RunObject
+		for _, file := range files {
+			runs = append(runs, &RunObject{Name: path.Base(file)})
+		}
+		return runs, nil
+	}
+
+	var runs []*RunObject
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(file) != ".proio" {
+			return nil
+		}
+		name, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, &RunObject{Name: filepath.ToSlash(name)})
+		return nil
+	})
+	return runs, err
+}
+
+func (s localStorage) Open(ctx context.Context, u *url.URL, credentials string) (io.ReadCloser, error) {
+	return os.Open(localPath(u))
+}
+
+func (s localStorage) OpenAt(ctx context.Context, u *url.URL, credentials string, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(localPath(u))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s localStorage) Create(ctx context.Context, u *url.URL, credentials string) (io.WriteCloser, error) {
+	filename := localPath(u)
+	if s.Recursive {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return os.Create(filename)
+}
+
+func (s localStorage) ModTime(ctx context.Context, u *url.URL, credentials string) (time.Time, error) {
+	info, err := os.Stat(localPath(u))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func init() {
+	RegisterStorage("file", localStorage{})
+	RegisterStorage("dir", localStorage{Recursive: true})
+}
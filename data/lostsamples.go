@@ -0,0 +1,205 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+
+	"github.com/proio-org/go-proio"
+)
+
+// LostSamplesKey is the metadata key of events that holds the number of
+// samples lost by each HPS so far, as "<hex UID>: <count>" pairs
+const LostSamplesKey = "Lost Samples"
+
+// DefaultMaxFill is the MaxFill of a LostSampleCounter that does not set one
+const DefaultMaxFill = 1000
+
+// LostSampleCounter finds the samples lost by each HPS from gaps in the FPGA
+// sample numbers of raw frames, allowing for the sample numbers to wrap
+// around.  Sample numbers that repeat or go back are logged rather than
+// counted, and the HPS is followed from there.
+//
+// A "Lost" frame is added to each event with lost samples, with a sample for
+// each gap at the time of the first lost sample.  Its HPS sample holds the
+// first lost sample number, and the number of samples lost in Sum.  The total
+// lost by each HPS is kept in the LostSamplesKey metadata of the events.
+//
+// If Fill is "zero" or "hold", gaps of up to MaxFill samples are filled in the
+// raw frames with placeholder samples, either zeroed or holding the channels
+// of the sample before the gap, so that the frames keep a steady rate.
+// Placeholders are flagged with Placeholder, which follows them through
+// merging and mapping, and are not taken as readings by the pedestals,
+// health and beam ops.  The placeholders of a gap are the samples in the
+// range given by its sample in the "Lost" frame.
+type LostSampleCounter struct {
+	Fill    string
+	MaxFill int
+
+	last   map[uint64]*currentmode.HpsSample
+	lastTs map[uint64]uint64
+	lost   map[uint64]uint64
+	wraps  map[uint64]uint64
+	report []byte
+}
+
+// Count is a StreamProcessor that counts the lost samples of the input
+func (c *LostSampleCounter) Count(input <-chan *proio.Event, output chan<- *proio.Event) {
+	c.last = make(map[uint64]*currentmode.HpsSample)
+	c.lastTs = make(map[uint64]uint64)
+	c.lost = make(map[uint64]uint64)
+	c.wraps = make(map[uint64]uint64)
+	if c.MaxFill == 0 {
+		c.MaxFill = DefaultMaxFill
+	}
+
+	for event := range input {
+		lostFrame := &currentmode.Frame{}
+		for _, frameId := range event.TaggedEntries("Frame") {
+			frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+			if !ok {
+				continue
+			}
+			c.countFrame(frame, lostFrame)
+		}
+
+		if len(lostFrame.Sample) > 0 {
+			lostFrame.Timestamp = lostFrame.Sample[0].Timestamp
+			for _, sample := range lostFrame.Sample {
+				sample.Timestamp -= lostFrame.Timestamp
+			}
+			event.AddEntry("Lost", lostFrame)
+			c.updateReport()
+		}
+		if c.report != nil {
+			event.Metadata[LostSamplesKey] = c.report
+		}
+
+		output <- event
+	}
+
+	for uid, wraps := range c.wraps {
+		log.Printf("sample numbers of HPS %016x wrapped around %v times\n", uid, wraps)
+	}
+	if len(c.lost) > 0 {
+		log.Println("lost samples by HPS:", string(c.report))
+	}
+}
+
+// countFrame checks the samples of a raw frame for gaps, adding a sample to
+// lostFrame for each gap found, with an absolute timestamp
+func (c *LostSampleCounter) countFrame(frame, lostFrame *currentmode.Frame) {
+	// samples is set once placeholders are inserted, and holds absolute
+	// timestamps until the frame is rebuilt from it
+	var samples []*currentmode.Sample
+	for i, sample := range frame.Sample {
+		ts := frame.Timestamp + sample.Timestamp
+		for uid, hpsSample := range sample.Hps {
+			last, lastTs := c.last[uid], c.lastTs[uid]
+			c.last[uid], c.lastTs[uid] = hpsSample, ts
+			if last == nil {
+				continue
+			}
+
+			step := hpsSample.SampleNumber - last.SampleNumber
+			if step == 0 || step >= 1<<31 {
+				log.Printf("sample numbers of HPS %016x went back from %v to %v\n",
+					uid, last.SampleNumber, hpsSample.SampleNumber)
+				continue
+			}
+			if hpsSample.SampleNumber < last.SampleNumber {
+				c.wraps[uid]++
+			}
+			if step == 1 {
+				continue
+			}
+
+			nLost := step - 1
+			first := last.SampleNumber + 1
+			c.lost[uid] += uint64(nLost)
+			// lost samples are timed evenly between the samples around the gap
+			lostTs := func(j uint32) uint64 {
+				if ts <= lastTs {
+					return lastTs
+				}
+				return lastTs + uint64(float64(ts-lastTs)*float64(j+1)/float64(step))
+			}
+			lostFrame.Sample = append(lostFrame.Sample, &currentmode.Sample{
+				Timestamp: lostTs(0),
+				Hps: map[uint64]*currentmode.HpsSample{
+					uid: {SampleNumber: first, Sum: int64(nLost)},
+				},
+			})
+
+			if c.Fill == "" || int(nLost) > c.MaxFill || len(sample.Hps) > 1 {
+				continue
+			}
+			if samples == nil {
+				samples = make([]*currentmode.Sample, 0, len(frame.Sample)+int(nLost))
+				for _, earlier := range frame.Sample[:i] {
+					earlier.Timestamp += frame.Timestamp
+					samples = append(samples, earlier)
+				}
+			}
+			for j := uint32(0); j < nLost; j++ {
+				samples = append(samples, &currentmode.Sample{
+					Timestamp: lostTs(j),
+					Hps: map[uint64]*currentmode.HpsSample{
+						uid: c.placeholder(last, first+j),
+					},
+					Placeholder: true,
+				})
+			}
+		}
+		if samples != nil {
+			sample.Timestamp += frame.Timestamp
+			samples = append(samples, sample)
+		}
+	}
+
+	if samples != nil {
+		frame.Timestamp = samples[0].Timestamp
+		for _, sample := range samples {
+			sample.Timestamp -= frame.Timestamp
+		}
+		frame.Sample = samples
+	}
+}
+
+// placeholder returns a sample standing in for a lost sample after last
+func (c *LostSampleCounter) placeholder(last *currentmode.HpsSample, sampleNumber uint32) *currentmode.HpsSample {
+	placeholder := &currentmode.HpsSample{SampleNumber: sampleNumber}
+	if c.Fill == "hold" {
+		placeholder.Channel = append([]int32(nil), last.Channel...)
+		placeholder.FixedChannel = append([]int32(nil), last.FixedChannel...)
+		placeholder.FloatChannel = append([]float32(nil), last.FloatChannel...)
+		placeholder.Sum = last.Sum
+	} else {
+		placeholder.Channel = make([]int32, len(last.Channel))
+		placeholder.FixedChannel = make([]int32, len(last.FixedChannel))
+		placeholder.FloatChannel = make([]float32, len(last.FloatChannel))
+	}
+	return placeholder
+}
+
+// updateReport formats the lost sample counts
+func (c *LostSampleCounter) updateReport() {
+	var uids []uint64
+	for uid := range c.lost {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	var counts []string
+	for _, uid := range uids {
+		counts = append(counts, fmt.Sprintf("%016x: %v", uid, c.lost[uid]))
+	}
+	c.report = []byte(strings.Join(counts, ", "))
+}
@@ -12,35 +12,63 @@ import (
 	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
 
 	"github.com/gobuffalo/packr"
-	"github.com/golang/protobuf/proto"
 	"github.com/proio-org/go-proio"
 )
 
 var DetmapBox = packr.NewBox("../detmap/full")
 
-var detmapMutex sync.RWMutex
-var detmapBytes []byte
-var detmap *detmapmodel.Map
+// Mapper maps the HPS channels of a stream to detector axes.  Each stream
+// has its own Mapper, so that streams from different detectors can use
+// different maps and calibrations in the same process.
+type Mapper struct {
+	UID uint64
 
-func unmarshalDetmap() {
-	detmapMutex.Lock()
-	var err error
-	detmapBytes, err = DetmapBox.Find("dev.pb")
-	if err != nil {
-		log.Println("cannot find dev.pb:", err)
-	}
-	detmap = &detmapmodel.Map{}
-	proto.Unmarshal(detmapBytes, detmap)
-	detmapMutex.Unlock()
+	registry *DetmapRegistry
+	source   string
+	detmap   *detmapmodel.Map
+	mutex    sync.Mutex
 }
 
-func MapEvent(event *proio.Event) {
-	detmapMutex.RLock()
+// NewMapper returns a Mapper for the stream with the given UID that uses
+// detmap
+func NewMapper(uid uint64, detmap *detmapmodel.Map) *Mapper {
 	if detmap == nil {
-		detmapMutex.RUnlock()
-		unmarshalDetmap()
-		detmapMutex.RLock()
+		detmap = &detmapmodel.Map{}
+	}
+	return &Mapper{
+		UID:    uid,
+		detmap: detmap,
 	}
+}
+
+// Detmap returns the map in use.  Mappers made by a DetmapRegistry follow
+// reloads of their map source.
+func (m *Mapper) Detmap() *detmapmodel.Map {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.registry != nil {
+		if detmap, err := m.registry.Get(m.source); err == nil {
+			m.detmap = detmap
+		}
+	}
+	return m.detmap
+}
+
+// SetDetmap replaces the map in use, which will no longer follow reloads of
+// its source
+func (m *Mapper) SetDetmap(detmap *detmapmodel.Map) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.registry = nil
+	m.detmap = detmap
+}
+
+// MapEvent is an EventProcessor that adds a "Mapped" frame for each raw
+// frame of the event
+func (m *Mapper) MapEvent(event *proio.Event) {
+	detmap := m.Detmap()
 
 	for _, entryId := range event.TaggedEntries("Mapped") {
 		event.RemoveEntry(entryId)
@@ -58,8 +86,9 @@ func MapEvent(event *proio.Event) {
 
 		for _, sample := range frame.Sample {
 			mappedSample := &currentmode.Sample{
-				Timestamp: sample.Timestamp,
-				Hps:       make(map[uint64]*currentmode.HpsSample),
+				Timestamp:   sample.Timestamp,
+				Hps:         make(map[uint64]*currentmode.HpsSample),
+				Placeholder: sample.Placeholder,
 			}
 			mappedFrame.Sample = append(mappedFrame.Sample, mappedSample)
 
@@ -121,20 +150,13 @@ func MapEvent(event *proio.Event) {
 
 		event.AddEntry("Mapped", mappedFrame)
 	}
-
-	detmapMutex.RUnlock()
 }
 
-func GetHpsConfig(uid uint64) *detmapmodel.HpsConfig {
-	detmapMutex.RLock()
-	defer detmapMutex.RUnlock()
-	if detmap == nil {
-		detmapMutex.RUnlock()
-		unmarshalDetmap()
-		detmapMutex.RLock()
-	}
+// HpsConfig returns the HPS config of the stream, falling back to config 1
+func (m *Mapper) HpsConfig() *detmapmodel.HpsConfig {
+	detmap := m.Detmap()
 
-	configId := uint32(uid >> 32)
+	configId := uint32(m.UID >> 32)
 	hpsConfig, ok := detmap.HpsConfig[configId]
 	if !ok {
 		return detmap.HpsConfig[1]
@@ -142,16 +164,19 @@ func GetHpsConfig(uid uint64) *detmapmodel.HpsConfig {
 	return hpsConfig
 }
 
-func GetMode(uid uint64) detmapmodel.HpsConfig_Mode {
-	detmapMutex.RLock()
-	defer detmapMutex.RUnlock()
-	if detmap == nil {
-		detmapMutex.RUnlock()
-		unmarshalDetmap()
-		detmapMutex.RLock()
+// SamplePeriod returns the time in seconds between samples of the stream,
+// falling back to config 1 and then to DefaultSamplePeriod
+func (m *Mapper) SamplePeriod() float64 {
+	if hpsConfig := m.HpsConfig(); hpsConfig != nil && hpsConfig.SamplePeriod > 0 {
+		return hpsConfig.SamplePeriod
 	}
+	return DefaultSamplePeriod
+}
+
+func (m *Mapper) Mode() detmapmodel.HpsConfig_Mode {
+	detmap := m.Detmap()
 
-	configId := uint32(uid >> 32)
+	configId := uint32(m.UID >> 32)
 	hpsConfig, ok := detmap.HpsConfig[configId]
 	if !ok {
 		return detmapmodel.HpsConfig_CURRENT
@@ -159,16 +184,10 @@ func GetMode(uid uint64) detmapmodel.HpsConfig_Mode {
 	return hpsConfig.Mode
 }
 
-func GetDetName(uid uint64) string {
-	detmapMutex.RLock()
-	defer detmapMutex.RUnlock()
-	if detmap == nil {
-		detmapMutex.RUnlock()
-		unmarshalDetmap()
-		detmapMutex.RLock()
-	}
+func (m *Mapper) DetName() string {
+	detmap := m.Detmap()
 
-	configId := uint32(uid >> 32)
+	configId := uint32(m.UID >> 32)
 	hpsConfig, ok := detmap.HpsConfig[configId]
 	if !ok {
 		log.Println("didn't find HpsConfig:", configId)
@@ -182,16 +201,10 @@ func GetDetName(uid uint64) string {
 	return detConfig.Name
 }
 
-func GetImageConfigs(uid uint64) []*detmapmodel.DetectorConfig_ImageConfig {
-	detmapMutex.RLock()
-	defer detmapMutex.RUnlock()
-	if detmap == nil {
-		detmapMutex.RUnlock()
-		unmarshalDetmap()
-		detmapMutex.RLock()
-	}
+func (m *Mapper) ImageConfigs() []*detmapmodel.DetectorConfig_ImageConfig {
+	detmap := m.Detmap()
 
-	configId := uint32(uid >> 32)
+	configId := uint32(m.UID >> 32)
 	hpsConfig, ok := detmap.HpsConfig[configId]
 	if !ok {
 		hpsConfig, ok = detmap.HpsConfig[1]
@@ -207,3 +220,25 @@ func GetImageConfigs(uid uint64) []*detmapmodel.DetectorConfig_ImageConfig {
 	}
 	return detConfig.ImageConfig
 }
+
+// MapEvent maps an event using the map selected by DefaultDetmaps for the
+// event metadata
+func MapEvent(event *proio.Event) {
+	NewMapper(0, DefaultDetmaps.SelectForMetadata(event.Metadata)).MapEvent(event)
+}
+
+func GetHpsConfig(uid uint64) *detmapmodel.HpsConfig {
+	return DefaultDetmaps.Mapper(uid, nil).HpsConfig()
+}
+
+func GetMode(uid uint64) detmapmodel.HpsConfig_Mode {
+	return DefaultDetmaps.Mapper(uid, nil).Mode()
+}
+
+func GetDetName(uid uint64) string {
+	return DefaultDetmaps.Mapper(uid, nil).DetName()
+}
+
+func GetImageConfigs(uid uint64) []*detmapmodel.DetectorConfig_ImageConfig {
+	return DefaultDetmaps.Mapper(uid, nil).ImageConfigs()
+}
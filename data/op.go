@@ -6,6 +6,7 @@ package data
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/proio-org/go-proio"
@@ -26,6 +28,90 @@ type Op interface {
 	Run(input <-chan *proio.Event) <-chan *proio.Event
 }
 
+// ContextOp is an Op that can be cancelled and can fail.  RunContext must
+// close its output once its input is closed or ctx is done, must not block
+// sending output once ctx is done, and reports errors by calling fail, which
+// cancels ctx.
+type ContextOp interface {
+	Op
+	RunContext(ctx context.Context, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event
+}
+
+// OpError is the error returned when an op of an OpArray fails
+type OpError struct {
+	Op   int
+	Name string
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("op %d (%v): %v", e.Op, e.Name, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// logFail is the failure handler of ops run without a context
+func logFail(err error) {
+	log.Println(err)
+}
+
+// RunOpContext runs any op with a context.  Ops that are not ContextOps see
+// their input closed once ctx is done, and their output is drained.
+func RunOpContext(ctx context.Context, o Op, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
+	if o, ok := o.(ContextOp); ok {
+		return o.RunContext(ctx, input, fail)
+	}
+
+	output := o.Run(relayContext(ctx, input))
+	drainOnDone(ctx, output)
+	return output
+}
+
+// relayContext forwards input until it is closed or ctx is done
+func relayContext(ctx context.Context, input <-chan *proio.Event) <-chan *proio.Event {
+	if ctx.Done() == nil {
+		return input
+	}
+
+	output := make(chan *proio.Event)
+	go func() {
+		defer close(output)
+
+		for {
+			select {
+			case event, ok := <-input:
+				if !ok {
+					return
+				}
+				select {
+				case output <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output
+}
+
+// drainOnDone discards whatever is left in output once ctx is done, so that
+// the goroutines writing to it can finish
+func drainOnDone(ctx context.Context, output <-chan *proio.Event) {
+	if ctx.Done() == nil {
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		for range output {
+		}
+	}()
+}
+
 type OpArray []Op
 
 func (ops OpArray) Run(stream <-chan *proio.Event) <-chan *proio.Event {
@@ -35,11 +121,65 @@ func (ops OpArray) Run(stream <-chan *proio.Event) <-chan *proio.Event {
 	return stream
 }
 
+// RunContext runs the ops until the input is closed, ctx is done, or an op
+// fails, in which case all ops are cancelled.  The returned wait function
+// must be called once the output is closed, and returns the *OpError of the
+// first op that failed, or else the error of ctx.
+func (ops OpArray) RunContext(ctx context.Context, stream <-chan *proio.Event) (<-chan *proio.Event, func() error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var err error
+	var errMutex sync.Mutex
+	stream = ops.runContext(ctx, relayContext(ctx, stream), func(e error) {
+		errMutex.Lock()
+		if err == nil {
+			err = e
+		}
+		errMutex.Unlock()
+		cancel()
+	})
+
+	var waitErr error
+	var waitOnce sync.Once
+	wait := func() error {
+		waitOnce.Do(func() {
+			errMutex.Lock()
+			waitErr = err
+			errMutex.Unlock()
+			if waitErr == nil {
+				waitErr = ctx.Err()
+			}
+			cancel()
+		})
+		return waitErr
+	}
+	return stream, wait
+}
+
+// runContext runs the ops with a context, wrapping their errors in *OpError
+func (ops OpArray) runContext(ctx context.Context, stream <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
+	for i, o := range ops {
+		i, name := i, OpName(o)
+		stream = RunOpContext(ctx, o, stream, func(err error) {
+			fail(&OpError{Op: i, Name: name, Err: err})
+		})
+	}
+	return stream
+}
+
 func (ops OpArray) Sink(stream <-chan *proio.Event) {
 	for range ops.Run(stream) {
 	}
 }
 
+// SinkContext runs the ops as a data sink, returning the error of RunContext
+func (ops OpArray) SinkContext(ctx context.Context, stream <-chan *proio.Event) error {
+	output, wait := ops.RunContext(ctx, stream)
+	for range output {
+	}
+	return wait()
+}
+
 var FlagSet = flag.NewFlagSet("", flag.ExitOnError)
 
 var (
@@ -50,8 +190,11 @@ var (
 	maxEventBuf = FlagSet.Int("e", 200, "max event buffer for maintaining event order")
 	bucketThres = FlagSet.Int("d", 0x10000, "bucket dump threshold in bytes")
 	loop        = FlagSet.Bool("l", false, "infinite loop over data")
+	startAt     = FlagSet.Float64("at", 0, "start reading the input this many seconds after its first frame, using the time index of the input")
+	detmapUrl   = FlagSet.String("m", "", "detector map file, URL or packed name to use instead of the default")
 	cpuProfile  = FlagSet.String("cpuprofile", "", "output file for cpu profiling")
 	memProfile  = FlagSet.String("memprofile", "", "output file for memory profiling")
+	printStats  = FlagSet.Bool("stats", false, "print per-op pipeline statistics at exit")
 )
 
 func (ops OpArray) RunCmdFlagParse() {
@@ -81,6 +224,10 @@ options:
 		FlagSet.Usage()
 		log.Fatal("Invalid arguments")
 	}
+
+	if *detmapUrl != "" {
+		DefaultDetmaps.Default = *detmapUrl
+	}
 }
 
 func (ops OpArray) GetReader() *proio.Reader {
@@ -92,6 +239,11 @@ func (ops OpArray) GetReader() *proio.Reader {
 	if filename == "-" {
 		stdin := bufio.NewReader(os.Stdin)
 		reader = proio.NewReader(stdin)
+	} else if *startAt > 0 {
+		var urlString string
+		if urlString, err = ObjectURL(filename); err == nil {
+			reader, err = OpenRunAt(ServerContext(context.Background()), urlString, "", *startAt)
+		}
 	} else {
 		reader, err = proio.Open(filename)
 	}
@@ -103,6 +255,14 @@ func (ops OpArray) GetReader() *proio.Reader {
 }
 
 func (ops OpArray) RunCmd() {
+	// exit with an error status after deferred cleanup if processing failed
+	var err error
+	defer func() {
+		if err != nil {
+			os.Exit(1)
+		}
+	}()
+
 	ops.RunCmdFlagParse()
 
 	reader := ops.GetReader()
@@ -110,7 +270,6 @@ func (ops OpArray) RunCmd() {
 
 	var writer *proio.Writer
 	var conn *websocket.Conn
-	var err error
 	if strings.HasPrefix(*outFile, "ws") && strings.Contains(*outFile, "://") {
 		conn, err = websocket.Dial(*outFile, "", "http://localhost/")
 		if err != nil {
@@ -148,16 +307,34 @@ func (ops OpArray) RunCmd() {
 		defer pprof.StopCPUProfile()
 	}
 
+	var stats *PipelineStats
+	if *printStats {
+		ops, stats = ops.Instrument()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	for {
-		stream := ops.Run(reader.ScanEvents(*readBufSize))
+		stream, wait := ops.RunContext(ctx, reader.ScanEvents(*readBufSize))
 		for event := range stream {
 			if conn != nil {
 				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			}
-			if err := writer.Push(event); err != nil {
-				goto wrapup
+			if err = writer.Push(event); err != nil {
+				err = fmt.Errorf("unable to write event: %v", err)
+				cancel()
+				for range stream {
+				}
 			}
 		}
+		if opErr := wait(); err == nil {
+			err = opErr
+		}
+		if err != nil {
+			log.Println(err)
+			break
+		}
 
 		if reader.Err == io.EOF {
 			if !*loop {
@@ -169,7 +346,10 @@ func (ops OpArray) RunCmd() {
 		}
 	}
 
-wrapup:
+	if stats != nil {
+		fmt.Fprint(os.Stderr, stats)
+	}
+
 	if *memProfile != "" {
 		f, err := os.Create(*memProfile)
 		if err != nil {
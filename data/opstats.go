@@ -0,0 +1,307 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/proio-org/go-proio"
+)
+
+// OpName returns the description of an op, or else the name of its
+// processor or type
+func OpName(o Op) string {
+	if desc := o.GetDescription(); desc != "" {
+		return desc
+	}
+
+	var fn interface{}
+	switch o := o.(type) {
+	case InstrumentedOp:
+		return OpName(o.Op)
+	case EventOp:
+		fn = o.EventProcessor
+	case StreamOp:
+		fn = o.StreamProcessor
+	default:
+		return fmt.Sprintf("%T", o)
+	}
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return fmt.Sprintf("%T", o)
+	}
+	name := f.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+const (
+	nDurationBuckets = 40
+	maxPending       = 100000
+)
+
+// OpStats records the events going in and out of an op, the time each event
+// spends in the op, the time spent waiting for the op to accept events, and
+// the fill level of the output channel of the op.  Residence times are only
+// measured for events that come out of the op that they went into.
+type OpStats struct {
+	Name string
+
+	// OnDuration, if set, is called with each residence time measured
+	OnDuration func(time.Duration)
+
+	in, out     uint64
+	blocked     time.Duration
+	buckets     [nDurationBuckets]uint64
+	nDurations  uint64
+	sumDuration time.Duration
+	maxDuration time.Duration
+	sumFill     float64
+	maxFill     float64
+	pending     map[*proio.Event]time.Time
+	mutex       sync.Mutex
+}
+
+func (s *OpStats) enter(event *proio.Event, t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending == nil || len(s.pending) >= maxPending {
+		s.pending = make(map[*proio.Event]time.Time)
+	}
+	s.pending[event] = t
+	s.in++
+}
+
+func (s *OpStats) accepted(event *proio.Event, t time.Time, wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.pending[event]; ok {
+		s.pending[event] = t
+	}
+	s.blocked += wait
+}
+
+func (s *OpStats) leave(event *proio.Event, t time.Time, fill float64) {
+	s.mutex.Lock()
+
+	s.out++
+	s.sumFill += fill
+	if fill > s.maxFill {
+		s.maxFill = fill
+	}
+
+	t0, ok := s.pending[event]
+	if !ok {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.pending, event)
+
+	d := t.Sub(t0)
+	s.nDurations++
+	s.sumDuration += d
+	if d > s.maxDuration {
+		s.maxDuration = d
+	}
+	s.buckets[durationBucket(d)]++
+	onDuration := s.OnDuration
+	s.mutex.Unlock()
+
+	if onDuration != nil {
+		onDuration(d)
+	}
+}
+
+// durationBucket returns the bucket of d, with bucket i > 0 holding durations
+// from 2^(i-1) to 2^i microseconds
+func durationBucket(d time.Duration) int {
+	i := 0
+	for limit := time.Microsecond; d >= limit && i < nDurationBuckets-1; limit *= 2 {
+		i++
+	}
+	return i
+}
+
+// OpSummary is a snapshot of the statistics of an op.  Quantiles are upper
+// bounds from a histogram with power-of-two buckets.  Fill is the mean fill
+// level of the output channel as a fraction of its capacity.
+type OpSummary struct {
+	Name          string
+	In, Out       uint64
+	Blocked       time.Duration
+	Mean          time.Duration
+	P50, P90, P99 time.Duration
+	Max           time.Duration
+	Fill, MaxFill float64
+}
+
+func (s *OpStats) Summary() OpSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	summary := OpSummary{
+		Name:    s.Name,
+		In:      s.in,
+		Out:     s.out,
+		Blocked: s.blocked,
+		Max:     s.maxDuration,
+		MaxFill: s.maxFill,
+	}
+	if s.out > 0 {
+		summary.Fill = s.sumFill / float64(s.out)
+	}
+	if s.nDurations > 0 {
+		summary.Mean = s.sumDuration / time.Duration(s.nDurations)
+		summary.P50 = s.quantile(0.5)
+		summary.P90 = s.quantile(0.9)
+		summary.P99 = s.quantile(0.99)
+	}
+	return summary
+}
+
+func (s *OpStats) quantile(q float64) time.Duration {
+	target := uint64(q * float64(s.nDurations))
+	var n uint64
+	limit := time.Microsecond
+	for i, count := range s.buckets {
+		n += count
+		if n > target || i == nDurationBuckets-1 {
+			break
+		}
+		limit *= 2
+	}
+	if limit > s.maxDuration {
+		return s.maxDuration
+	}
+	return limit
+}
+
+// PipelineStats holds the statistics of each op of an instrumented OpArray
+type PipelineStats struct {
+	Ops []*OpStats
+}
+
+// Summary returns a snapshot of the statistics of each op
+func (p *PipelineStats) Summary() []OpSummary {
+	var summaries []OpSummary
+	for _, s := range p.Ops {
+		summaries = append(summaries, s.Summary())
+	}
+	return summaries
+}
+
+// String formats the statistics as a table
+func (p *PipelineStats) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "op\tin\tout\tmean\tp50\tp90\tp99\tmax\tblocked\tfill\tmax fill")
+	for i, s := range p.Summary() {
+		fmt.Fprintf(w, "%d) %v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%.0f%%\t%.0f%%\n",
+			i, s.Name, s.In, s.Out,
+			roundDuration(s.Mean), roundDuration(s.P50), roundDuration(s.P90),
+			roundDuration(s.P99), roundDuration(s.Max), roundDuration(s.Blocked),
+			100*s.Fill, 100*s.MaxFill,
+		)
+	}
+	w.Flush()
+	return b.String()
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(time.Microsecond)
+	}
+	return d
+}
+
+// InstrumentedOp wraps an op to record its statistics in Stats
+type InstrumentedOp struct {
+	Op    Op
+	Stats *OpStats
+}
+
+func (o InstrumentedOp) GetDescription() string {
+	return o.Op.GetDescription()
+}
+
+func (o InstrumentedOp) Run(input <-chan *proio.Event) <-chan *proio.Event {
+	return o.RunContext(context.Background(), input, logFail)
+}
+
+func (o InstrumentedOp) RunContext(ctx context.Context, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
+	opInput := make(chan *proio.Event)
+	go func() {
+		defer close(opInput)
+
+		for {
+			var event *proio.Event
+			select {
+			case event = <-input:
+			case <-ctx.Done():
+				return
+			}
+			if event == nil {
+				return
+			}
+
+			t0 := time.Now()
+			o.Stats.enter(event, t0)
+			select {
+			case opInput <- event:
+			case <-ctx.Done():
+				return
+			}
+			t1 := time.Now()
+			o.Stats.accepted(event, t1, t1.Sub(t0))
+		}
+	}()
+
+	opOutput := RunOpContext(ctx, o.Op, opInput, fail)
+	output := make(chan *proio.Event)
+	go func() {
+		defer close(output)
+		defer drainOnDone(ctx, opOutput)
+
+		for event := range opOutput {
+			var fill float64
+			if cap(opOutput) > 0 {
+				fill = float64(len(opOutput)) / float64(cap(opOutput))
+			}
+			o.Stats.leave(event, time.Now(), fill)
+			select {
+			case output <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return output
+}
+
+// Instrument returns a copy of ops with each op wrapped to record its
+// statistics, and the statistics of the ops
+func (ops OpArray) Instrument() (OpArray, *PipelineStats) {
+	stats := &PipelineStats{}
+	instrumented := make(OpArray, len(ops))
+	for i, o := range ops {
+		opStats := &OpStats{Name: OpName(o)}
+		stats.Ops = append(stats.Ops, opStats)
+		instrumented[i] = InstrumentedOp{Op: o, Stats: opStats}
+	}
+	return instrumented, stats
+}
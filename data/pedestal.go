@@ -5,17 +5,47 @@
 package data
 
 import (
+	"fmt"
+	"log"
 	"math"
+	"sync"
+	"time"
 
 	"github.com/rditech/rdi-live/model/rdi/currentmode"
 
 	"github.com/proio-org/go-proio"
 )
 
+// Pedestals tracks and subtracts the pedestal of each mapped channel using an
+// exponential running average over frames with low correlation between axes.
+//
+// If Store is set, the pedestals for UID are loaded from Store when the first
+// frame arrives, and saved to Store every SavePeriod while they change.
 type Pedestals struct {
-	Alpha   float64
-	CovFrac float64
-	values  [][]float64
+	Alpha      float64
+	CovFrac    float64
+	UID        uint64
+	Store      PedestalStore
+	SavePeriod time.Duration
+
+	values   [][]float64
+	frozen   bool
+	loaded   bool
+	dirty    bool
+	lastSave time.Time
+
+	// saving is set while pedestals are being saved, and unsaved holds the
+	// latest pedestals to save after that
+	saving  bool
+	unsaved [][]float64
+
+	// taking holds the sums of a dark run being averaged
+	taking      [][]float64
+	takeCount   int
+	takeSamples int
+	takeFrames  int
+
+	mutex sync.Mutex
 }
 
 func (p *Pedestals) Subtract(input <-chan *proio.Event, output chan<- *proio.Event) {
@@ -29,6 +59,10 @@ func (p *Pedestals) Subtract(input <-chan *proio.Event, output chan<- *proio.Eve
 	}
 	covFrac2 := p.CovFrac * p.CovFrac
 
+	if p.SavePeriod == 0 {
+		p.SavePeriod = time.Minute
+	}
+
 	for event := range input {
 		rawFrameIds := event.TaggedEntries("Frame")
 		mappedFrameIds := event.TaggedEntries("Mapped")
@@ -36,6 +70,14 @@ func (p *Pedestals) Subtract(input <-chan *proio.Event, output chan<- *proio.Eve
 			continue
 		}
 
+		p.mutex.Lock()
+		if !p.loaded {
+			p.loaded = true
+			if err := p.load(); err != nil {
+				log.Printf("unable to load pedestals for %016x: %v\n", p.UID, err)
+			}
+		}
+
 		for i, entryId := range mappedFrameIds {
 			frame, ok := event.GetEntry(entryId).(*currentmode.Frame)
 			if !ok {
@@ -56,23 +98,41 @@ func (p *Pedestals) Subtract(input <-chan *proio.Event, output chan<- *proio.Eve
 
 			nAxes := len(frame.Sample[0].Axis)
 			thres := float32(math.Pow(covFrac2, float64(nAxes*(nAxes-1)/2)))
+			update := !p.frozen && p.taking == nil && frame.Correlation < thres
+			takeSamples := 0
 
 			for sampleNum, sample := range frame.Sample {
+				// placeholders for lost samples are subtracted, but are
+				// not readings to update or take pedestals from
+				reading := !sample.Placeholder
+				if reading && p.taking != nil {
+					takeSamples++
+				}
 				for i, axis := range sample.Axis {
 					axis.Sum = 0
 
 					if len(p.values) <= i {
 						p.values = append(p.values, make([]float64, 0))
 					}
+					if p.taking != nil && len(p.taking) <= i {
+						p.taking = append(p.taking, make([]float64, 0))
+					}
 
 					for j, val := range axis.FloatChannel {
 						if len(p.values[i]) <= j {
 							p.values[i] = append(p.values[i], 0)
 						}
 
-						if frame.Correlation < thres {
+						if update && reading {
 							p.values[i][j] *= inv_alpha
 							p.values[i][j] += p.Alpha * float64(val)
+							p.dirty = true
+						}
+						if p.taking != nil && reading {
+							if len(p.taking[i]) <= j {
+								p.taking[i] = append(p.taking[i], 0)
+							}
+							p.taking[i][j] += float64(val)
 						}
 
 						axis.FloatChannel[j] -= float32(p.values[i][j])
@@ -93,8 +153,174 @@ func (p *Pedestals) Subtract(input <-chan *proio.Event, output chan<- *proio.Eve
 				}
 
 			}
+
+			if p.taking != nil {
+				p.takeSamples += takeSamples
+				p.takeCount++
+				if p.takeCount >= p.takeFrames {
+					p.finishTake()
+				}
+			}
+		}
+
+		if p.dirty && p.Store != nil && time.Since(p.lastSave) > p.SavePeriod {
+			p.save()
 		}
+		p.mutex.Unlock()
 
 		output <- event
 	}
 }
+
+// Take starts averaging the next nFrames mapped frames, which should be taken
+// with the beam off, and replaces the pedestals with the average
+func (p *Pedestals) Take(nFrames int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if nFrames < 1 {
+		nFrames = 1
+	}
+	p.taking = make([][]float64, 0)
+	p.takeCount = 0
+	p.takeSamples = 0
+	p.takeFrames = nFrames
+}
+
+func (p *Pedestals) finishTake() {
+	if p.takeSamples > 0 {
+		for i, sums := range p.taking {
+			for len(p.values) <= i {
+				p.values = append(p.values, make([]float64, 0))
+			}
+			for j, sum := range sums {
+				for len(p.values[i]) <= j {
+					p.values[i] = append(p.values[i], 0)
+				}
+				p.values[i][j] = sum / float64(p.takeSamples)
+			}
+		}
+		p.dirty = true
+		if p.Store != nil {
+			p.save()
+		}
+	}
+	p.taking = nil
+}
+
+// Freeze stops or resumes updates of the running average
+func (p *Pedestals) Freeze(frozen bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.frozen = frozen
+}
+
+// Status describes the state of the pedestals
+func (p *Pedestals) Status() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch {
+	case p.taking != nil:
+		return fmt.Sprintf("taking (%v/%v frames)", p.takeCount, p.takeFrames)
+	case p.frozen:
+		return "frozen"
+	}
+	return "running"
+}
+
+// Values returns a copy of the pedestal of each channel by axis
+func (p *Pedestals) Values() [][]float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return copyPedestals(p.values)
+}
+
+// SetValues replaces the pedestal of each channel by axis
+func (p *Pedestals) SetValues(values [][]float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.values = copyPedestals(values)
+	p.loaded = true
+	p.dirty = true
+}
+
+// Save writes the pedestals to Store
+func (p *Pedestals) Save() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.Store == nil {
+		return fmt.Errorf("no pedestal store")
+	}
+	p.dirty = false
+	p.lastSave = time.Now()
+	return p.Store.SavePedestals(p.UID, p.values)
+}
+
+// Load reads the pedestals from Store
+func (p *Pedestals) Load() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.loaded = true
+	return p.load()
+}
+
+func (p *Pedestals) load() error {
+	if p.Store == nil {
+		return nil
+	}
+	values, err := p.Store.LoadPedestals(p.UID)
+	if err != nil || values == nil {
+		return err
+	}
+	p.values = values
+	p.dirty = false
+	p.lastSave = time.Now()
+	return nil
+}
+
+// save writes a copy of the pedestals in the background, so that a slow store
+// does not hold up the stream.  Saves are made one at a time, and a save
+// requested while another is running only writes the latest pedestals.  It
+// must be called with the mutex held.
+func (p *Pedestals) save() {
+	p.dirty = false
+	p.lastSave = time.Now()
+	p.unsaved = copyPedestals(p.values)
+	if p.saving {
+		return
+	}
+	p.saving = true
+	go p.saveUnsaved()
+}
+
+func (p *Pedestals) saveUnsaved() {
+	for {
+		p.mutex.Lock()
+		uid, values := p.UID, p.unsaved
+		p.unsaved = nil
+		if values == nil {
+			p.saving = false
+			p.mutex.Unlock()
+			return
+		}
+		p.mutex.Unlock()
+
+		if err := p.Store.SavePedestals(uid, values); err != nil {
+			log.Printf("unable to save pedestals for %016x: %v\n", uid, err)
+		}
+	}
+}
+
+func copyPedestals(values [][]float64) [][]float64 {
+	copied := make([][]float64, len(values))
+	for i, axis := range values {
+		copied[i] = append([]float64(nil), axis...)
+	}
+	return copied
+}
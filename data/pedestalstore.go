@@ -0,0 +1,71 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// PedestalStore persists pedestals by stream UID.  LoadPedestals returns nil
+// values without an error if no pedestals have been saved for the UID.
+type PedestalStore interface {
+	SavePedestals(uid uint64, values [][]float64) error
+	LoadPedestals(uid uint64) ([][]float64, error)
+}
+
+// PedestalDir is a PedestalStore that keeps pedestals as JSON files in a
+// directory
+type PedestalDir string
+
+func (d PedestalDir) path(uid uint64) string {
+	return filepath.Join(string(d), fmt.Sprintf("%016x.json", uid))
+}
+
+func (d PedestalDir) SavePedestals(uid uint64, values [][]float64) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	valueBytes, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	// write and rename so that a crash never leaves a partial file, using a
+	// temporary file of its own so that concurrent saves cannot mix
+	tmpFile, err := ioutil.TempFile(string(d), fmt.Sprintf("%016x.json.*.tmp", uid))
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(valueBytes)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), d.path(uid))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return err
+}
+
+func (d PedestalDir) LoadPedestals(uid uint64) ([][]float64, error) {
+	valueBytes, err := ioutil.ReadFile(d.path(uid))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values [][]float64
+	err = json.Unmarshal(valueBytes, &values)
+	return values, err
+}
@@ -0,0 +1,468 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PipelineEnv holds what registered ops need to be built for a stream, and
+// collects the components built that callers may need to control
+type PipelineEnv struct {
+	UID           uint64
+	Mapper        *Mapper
+	PedestalStore PedestalStore
+
+	// Concurrency and MaxEventBuf are the defaults for ops that do not set
+	// them.  Zero values leave the command line defaults.
+	Concurrency int
+	MaxEventBuf int
+
+	// Pedestals is set by the "pedestals" op
+	Pedestals *Pedestals
+
+	// joins holds the merges of "join" ops and "tee" branches by name, and
+	// whether each has been placed by a "join" op
+	joins  map[string]*Merge
+	placed map[string]bool
+}
+
+// join returns the merge with the given name
+func (env *PipelineEnv) join(name string) *Merge {
+	if env.joins == nil {
+		env.joins = make(map[string]*Merge)
+		env.placed = make(map[string]bool)
+	}
+	if env.joins[name] == nil {
+		env.joins[name] = &Merge{Description: "Joins branch " + name}
+	}
+	return env.joins[name]
+}
+
+// OpParams holds the parameters of a pipeline step
+type OpParams map[string]interface{}
+
+// Decode sets the fields of v from the parameters, using the lower-cased field
+// names as keys.  Unknown parameters are an error.
+func (p OpParams) Decode(v interface{}) error {
+	if len(p) == 0 {
+		return nil
+	}
+	buf, err := yaml.Marshal(map[string]interface{}(p))
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(buf, v)
+}
+
+// OpFactory builds an op from its parameters
+type OpFactory func(env *PipelineEnv, params OpParams) (Op, error)
+
+type registeredOp struct {
+	description string
+	factory     OpFactory
+}
+
+var opRegistry = make(map[string]registeredOp)
+
+// RegisterOp makes an op available to pipeline specs under name
+func RegisterOp(name, description string, factory OpFactory) {
+	opRegistry[name] = registeredOp{description, factory}
+}
+
+// RegisteredOps lists the names and descriptions of the registered ops
+func RegisteredOps() string {
+	var names []string
+	for name := range opRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%v: %v", name, opRegistry[name].description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PipelineStep is one op of a pipeline spec.  Concurrency and MaxEventBuf are
+// given in the spec as the "concurrency" and "buffer" parameters.
+type PipelineStep struct {
+	Name        string
+	Params      OpParams
+	Concurrency int
+	MaxEventBuf int
+}
+
+// Pipeline is an ordered list of registered ops with their parameters
+type Pipeline []PipelineStep
+
+// ParsePipeline parses a YAML or JSON pipeline spec.  The spec is a list of
+// steps, each of which is either the name of a registered op or a map from
+// the name to the parameters of the op, for example
+//
+//   - map
+//   - correlate
+//   - pedestals: {alpha: 0.001, covfrac: 0.2}
+//   - beam: {concurrency: 4}
+//
+// The "tee" op takes the steps of each of its branches as a nested spec.
+func ParsePipeline(spec []byte) (Pipeline, error) {
+	var steps []interface{}
+	if err := yaml.Unmarshal(spec, &steps); err != nil {
+		return nil, err
+	}
+	return parseSteps(steps)
+}
+
+// parseSteps parses the steps of a pipeline spec as decoded from YAML
+func parseSteps(steps []interface{}) (Pipeline, error) {
+	var pipeline Pipeline
+	for i, step := range steps {
+		var pipelineStep PipelineStep
+		switch step := step.(type) {
+		case string:
+			pipelineStep.Name = step
+		case map[interface{}]interface{}:
+			if len(step) != 1 {
+				return nil, fmt.Errorf("pipeline step %d must name exactly one op", i)
+			}
+			for name, params := range step {
+				pipelineStep.Name = fmt.Sprint(name)
+				if params == nil {
+					break
+				}
+				paramMap, ok := params.(map[interface{}]interface{})
+				if !ok {
+					return nil, fmt.Errorf("parameters of pipeline step %d (%v) must be a map", i, name)
+				}
+				pipelineStep.Params = make(OpParams)
+				for key, val := range paramMap {
+					pipelineStep.Params[fmt.Sprint(key)] = val
+				}
+			}
+		default:
+			return nil, fmt.Errorf("bad pipeline step %d: %v", i, step)
+		}
+
+		if _, ok := opRegistry[pipelineStep.Name]; !ok {
+			return nil, fmt.Errorf("unknown op \"%v\" in pipeline step %d", pipelineStep.Name, i)
+		}
+
+		for key, dest := range map[string]*int{
+			"concurrency": &pipelineStep.Concurrency,
+			"buffer":      &pipelineStep.MaxEventBuf,
+		} {
+			if val, ok := pipelineStep.Params[key]; ok {
+				n, ok := val.(int)
+				if !ok || n < 0 {
+					return nil, fmt.Errorf("%v of pipeline step %d must be a non-negative integer", key, i)
+				}
+				*dest = n
+				delete(pipelineStep.Params, key)
+			}
+		}
+
+		pipeline = append(pipeline, pipelineStep)
+	}
+
+	return pipeline, nil
+}
+
+// LoadPipeline reads and parses a pipeline spec file
+func LoadPipeline(filename string) (Pipeline, error) {
+	spec, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := ParsePipeline(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return pipeline, nil
+}
+
+// Build builds the ops of the pipeline
+func (p Pipeline) Build(env *PipelineEnv) (OpArray, error) {
+	ops, err := p.build(env)
+	if err != nil {
+		return nil, err
+	}
+	for name := range env.joins {
+		if !env.placed[name] {
+			return nil, fmt.Errorf("branch joins \"%v\" but there is no join op with that name", name)
+		}
+	}
+	return ops, nil
+}
+
+func (p Pipeline) build(env *PipelineEnv) (OpArray, error) {
+	var ops OpArray
+	for i, step := range p {
+		registered, ok := opRegistry[step.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown op \"%v\" in pipeline step %d", step.Name, i)
+		}
+
+		o, err := registered.factory(env, step.Params)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline step %d (%v): %v", i, step.Name, err)
+		}
+
+		concurrency := step.Concurrency
+		if concurrency == 0 {
+			concurrency = env.Concurrency
+		}
+		maxEventBuf := step.MaxEventBuf
+		if maxEventBuf == 0 {
+			maxEventBuf = env.MaxEventBuf
+		}
+		switch op := o.(type) {
+		case EventOp:
+			if op.Concurrency == 0 {
+				op.Concurrency = concurrency
+			}
+			if op.MaxEventBuf == 0 {
+				op.MaxEventBuf = maxEventBuf
+			}
+			o = op
+		case StreamOp:
+			if op.MaxEventBuf == 0 {
+				op.MaxEventBuf = maxEventBuf
+			}
+			o = op
+		case Tee:
+			if op.MaxEventBuf == 0 {
+				op.MaxEventBuf = maxEventBuf
+			}
+			o = op
+		case *Merge:
+			if op.MaxEventBuf == 0 {
+				op.MaxEventBuf = maxEventBuf
+			}
+		}
+
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+// Check builds the pipeline for a stream without a detector map in order to
+// catch bad parameters early
+func (p Pipeline) Check() error {
+	_, err := p.Build(&PipelineEnv{Mapper: NewMapper(0, nil)})
+	return err
+}
+
+// MustParsePipeline is like ParsePipeline but panics on errors.  It is meant
+// for pipelines built into programs.
+func MustParsePipeline(spec string) Pipeline {
+	pipeline, err := ParsePipeline([]byte(spec))
+	if err != nil {
+		panic(err)
+	}
+	return pipeline
+}
+
+func init() {
+	RegisterOp("assemble", "Assembles frames from samples",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			return EventOp{
+				Description:    "Assembles frames from samples",
+				EventProcessor: (&FrameAssembler{Mapper: env.Mapper}).Assemble,
+			}, params.Decode(&struct{}{})
+		},
+	)
+	RegisterOp("lostsamples", "Counts samples lost by each HPS, optionally filling gaps (fill: zero or hold, maxfill)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			counter := &LostSampleCounter{}
+			if err := params.Decode(counter); err != nil {
+				return nil, err
+			}
+			switch counter.Fill {
+			case "", "zero", "hold":
+			default:
+				return nil, fmt.Errorf("unknown fill \"%v\"", counter.Fill)
+			}
+			return StreamOp{
+				Description:     "Counts samples lost by each HPS",
+				StreamProcessor: counter.Count,
+			}, nil
+		},
+	)
+	RegisterOp("merge", "Merges the frames of the HPSs of a detector (uids, window, maxlag, partial)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			var cfg struct {
+				UIDs    []string
+				Window  uint64
+				MaxLag  uint64
+				Partial bool
+			}
+			if err := params.Decode(&cfg); err != nil {
+				return nil, err
+			}
+
+			merger := &CmMerger{
+				Window:  cfg.Window,
+				MaxLag:  cfg.MaxLag,
+				Partial: cfg.Partial,
+			}
+			for _, text := range cfg.UIDs {
+				uid, err := strconv.ParseUint(strings.TrimPrefix(text, "0x"), 16, 64)
+				if err != nil {
+					return nil, fmt.Errorf("bad UID \"%v\"", text)
+				}
+				merger.UIDs = append(merger.UIDs, uid)
+			}
+			return StreamOp{
+				Description:     "Merges the frames of the HPSs of a detector",
+				StreamProcessor: merger.Merge,
+			}, nil
+		},
+	)
+	RegisterOp("map", "Maps event axes",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			if env.Mapper == nil {
+				return nil, fmt.Errorf("no detector mapper")
+			}
+			return EventOp{
+				Description:    "Maps event axes",
+				EventProcessor: env.Mapper.MapEvent,
+			}, params.Decode(&struct{}{})
+		},
+	)
+	RegisterOp("correlate", "Calculates and adds event correlation (nframes, default)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			corr := &Correlator{}
+			if err := params.Decode(corr); err != nil {
+				return nil, err
+			}
+			if corr.NFrames == 0 {
+				return EventOp{
+					Description:    "Calculates and adds event correlation",
+					EventProcessor: CorrelateCmEvent,
+				}, nil
+			}
+			return StreamOp{
+				Description:     "Calculates and adds event correlation",
+				StreamProcessor: corr.CorrelateCmEvent,
+			}, nil
+		},
+	)
+	RegisterOp("pedestals", "Calculates and subtracts pedestals from stream (alpha, covfrac, saveperiod)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			var cfg struct {
+				Alpha      float64
+				CovFrac    float64
+				SavePeriod time.Duration
+			}
+			if err := params.Decode(&cfg); err != nil {
+				return nil, err
+			}
+			env.Pedestals = &Pedestals{
+				Alpha:      cfg.Alpha,
+				CovFrac:    cfg.CovFrac,
+				SavePeriod: cfg.SavePeriod,
+				UID:        env.UID,
+				Store:      env.PedestalStore,
+			}
+			return StreamOp{
+				Description:     "Calculates and subtracts pedestals from stream",
+				StreamProcessor: env.Pedestals.Subtract,
+			}, nil
+		},
+	)
+	RegisterOp("health", "Flags dead, stuck and noisy channels, optionally masking them (window, stuckfrac, noisyfactor, deadfrac, covfrac, mask, interpolate)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			health := &ChannelHealth{}
+			if err := params.Decode(health); err != nil {
+				return nil, err
+			}
+			return StreamOp{
+				Description:     "Flags dead, stuck and noisy channels",
+				StreamProcessor: health.Check,
+			}, nil
+		},
+	)
+	RegisterOp("beam", "Reconstructs beam parameters",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			if env.Mapper == nil {
+				return nil, fmt.Errorf("no detector mapper")
+			}
+			return EventOp{
+				Description:    "Reconstructs beam parameters",
+				EventProcessor: NewBeamReconstruction(env.Mapper).FillBeamInfo,
+			}, params.Decode(&struct{}{})
+		},
+	)
+	RegisterOp("image", "Reconstructs beam image (iterations)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			if env.Mapper == nil {
+				return nil, fmt.Errorf("no detector mapper")
+			}
+			var cfg struct {
+				Iterations int
+			}
+			if err := params.Decode(&cfg); err != nil {
+				return nil, err
+			}
+			recon := NewImageReconstruction(env.Mapper)
+			if cfg.Iterations > 0 {
+				recon.Iterations = cfg.Iterations
+			}
+			return EventOp{
+				Description:    "Reconstructs beam image",
+				EventProcessor: recon.FillImage,
+			}, nil
+		},
+	)
+	RegisterOp("baseline", "Restores pulsed-mode baselines (alpha, gate)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			restorer := &BaselineRestorer{}
+			if err := params.Decode(restorer); err != nil {
+				return nil, err
+			}
+			return StreamOp{
+				Description:     "Restores pulsed-mode baselines",
+				StreamProcessor: restorer.Restore,
+			}, nil
+		},
+	)
+	RegisterOp("pulses", "Finds pulses (threshold, nsigma, alpha, window)",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			finder := &PulseFinder{}
+			if err := params.Decode(finder); err != nil {
+				return nil, err
+			}
+			return StreamOp{
+				Description:     "Finds pulses",
+				StreamProcessor: finder.Find,
+			}, nil
+		},
+	)
+	RegisterOp("keep_raw_frames", "Removes all but raw frames",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			return EventOp{
+				Description:    "Removes all but raw frames",
+				EventProcessor: KeepOnlyRawFrames,
+			}, params.Decode(&struct{}{})
+		},
+	)
+	RegisterOp("remove_loose_samples", "Removes loose samples",
+		func(env *PipelineEnv, params OpParams) (Op, error) {
+			return EventOp{
+				Description:    "Removes loose samples",
+				EventProcessor: RemoveLooseSamples,
+			}, params.Decode(&struct{}{})
+		},
+	)
+}
@@ -5,8 +5,14 @@
 package data
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"math"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rditech/rdi-live/model/rdi/currentmode"
@@ -16,45 +22,554 @@ import (
 
 const subsecdiv = float64(1 << 32)
 
+// PlaybackPositionKey is the metadata key of events passed on by a Player
+// that holds the playback position, as the time since the first frame of the
+// run and the index of the event, along with the speed and state of playback
+const PlaybackPositionKey = "Playback Position"
+
+// positionPeriod is the least time between updates of the playback position
+const positionPeriod = time.Second
+
+// Player passes on recorded events at the rate given by the timestamps of
+// their frames, scaled by Speed.  While playing, it can be paused, stepped an
+// event at a time, sped up or slowed down, and seeked by time or by event
+// index from other goroutines.  Seeking back and looping need a run that can
+// be reopened, as given to PlayRun, and seeking jumps through the time index
+// of the run if given one.  Events played from a channel can only be skipped
+// ahead, and playback ends with the input.
+//
+// The playback position is kept in the PlaybackPositionKey metadata of the
+// events passed on, and an event without entries carries the position
+// whenever playback stops, ends or moves.
 type Player struct {
 	Speed float64
+	Loop  bool
+
+	once     sync.Once
+	controls chan func(*playState)
 }
 
+// ErrBadFrame is returned by processors that find an entry tagged as a frame
+// that cannot be read
+var ErrBadFrame = errors.New("bad frame entry")
+
+// errNotRewindable is returned by sources of events that cannot be rewound
+var errNotRewindable = errors.New("playback cannot go back")
+
 func (p *Player) PlayCmStream(input <-chan *proio.Event, output chan<- *proio.Event) {
+	if err := p.Play(context.Background(), input, output); err != nil {
+		log.Println(err)
+	}
+}
+
+// Play is the ContextStreamProcessor version of PlayCmStream
+func (p *Player) Play(ctx context.Context, input <-chan *proio.Event, output chan<- *proio.Event) error {
+	return p.play(ctx, &chanSource{input: input}, output)
+}
+
+// PlayRun plays the run read by reader, which is closed when done, calling
+// open to read the run again from an entry of its time index, or from the
+// start if the entry is negative.  Without an index, seeking reads through
+// the run up to the target.
+func (p *Player) PlayRun(ctx context.Context, reader *proio.Reader, index *TimeIndex, open func(entry int) (*proio.Reader, error), output chan<- *proio.Event) error {
+	source := &runSource{reader: reader, index: index, open: open}
+	defer source.close()
+	return p.play(ctx, source, output)
+}
+
+// Pause stops playback until resumed
+func (p *Player) Pause() {
+	p.control(func(s *playState) {
+		s.paused = true
+		s.anchored = false
+	})
+}
+
+// Resume continues paused playback, or plays the run again from the start
+// once it has ended
+func (p *Player) Resume() {
+	p.control(func(s *playState) {
+		if s.ended {
+			s.rewind = true
+			s.ended = false
+		}
+		s.paused = false
+		s.anchored = false
+	})
+}
+
+// Step passes on the next event and pauses playback
+func (p *Player) Step() {
+	p.control(func(s *playState) {
+		s.step = true
+	})
+}
+
+// SetSpeed sets the speed of playback relative to the recording
+func (p *Player) SetSpeed(speed float64) error {
+	if !(speed > 0) || math.IsInf(speed, 0) {
+		return fmt.Errorf("bad playback speed %v", speed)
+	}
+	p.control(func(s *playState) {
+		s.speed = speed
+		if s.anchored && s.passed && s.lastStamp >= s.initStamp {
+			// keep pace from the last event passed on
+			s.start = time.Now()
+			s.initStamp = s.lastStamp
+		}
+	})
+	return nil
+}
+
+// SetLoop sets whether playback starts over at the end of the run
+func (p *Player) SetLoop(loop bool) {
+	p.control(func(s *playState) {
+		s.loop = loop
+	})
+}
+
+// SeekTime moves playback to the first event at least the given number of
+// seconds after the first frame of the run
+func (p *Player) SeekTime(seconds float64) error {
+	if !(seconds >= 0) || math.IsInf(seconds, 0) {
+		return fmt.Errorf("bad playback time %v", seconds)
+	}
+	p.control(func(s *playState) {
+		s.seek(false, seconds)
+	})
+	return nil
+}
+
+// SeekEvent moves playback to the event with the given index from the start
+// of the run
+func (p *Player) SeekEvent(index uint64) {
+	p.control(func(s *playState) {
+		s.seek(true, float64(index))
+	})
+}
+
+func (p *Player) controlChan() chan func(*playState) {
+	p.once.Do(func() {
+		p.controls = make(chan func(*playState), 100)
+	})
+	return p.controls
+}
+
+// control queues a change to the state of playback
+func (p *Player) control(change func(*playState)) {
+	select {
+	case p.controlChan() <- change:
+	default:
+		log.Println("player is not taking controls")
+	}
+}
+
+// playSource is a source of events to play
+type playSource interface {
+	// next returns the next event, or nil at the end of the source
+	next(ctx context.Context) (*proio.Event, error)
+	// rewind starts the source over, if rewindable
+	rewind(ctx context.Context) error
+	rewindable() bool
+	// timeIndex returns the time index of the source, or nil if it has none
+	timeIndex() *TimeIndex
+	// jump starts the source over from an entry of its time index
+	jump(ctx context.Context, entry int) error
+}
+
+type chanSource struct {
+	input <-chan *proio.Event
+}
+
+func (s *chanSource) next(ctx context.Context) (*proio.Event, error) {
+	select {
+	case event := <-s.input:
+		return event, nil
+	case <-ctx.Done():
+		return nil, nil
+	}
+}
+
+func (s *chanSource) rewind(ctx context.Context) error {
+	return errNotRewindable
+}
+
+func (s *chanSource) rewindable() bool {
+	return false
+}
+
+func (s *chanSource) timeIndex() *TimeIndex {
+	return nil
+}
+
+func (s *chanSource) jump(ctx context.Context, entry int) error {
+	return errNotRewindable
+}
+
+type runSource struct {
+	reader *proio.Reader
+	index  *TimeIndex
+	open   func(entry int) (*proio.Reader, error)
+}
+
+func (s *runSource) next(ctx context.Context) (*proio.Event, error) {
+	for {
+		event := s.reader.Next()
+		if event != nil {
+			return event, nil
+		}
+		if s.reader.Err == nil || s.reader.Err == io.EOF || s.reader.Err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		// the reader resynchronizes on the next bucket
+		log.Println(s.reader.Err)
+	}
+}
+
+func (s *runSource) rewind(ctx context.Context) error {
+	return s.jump(ctx, -1)
+}
+
+func (s *runSource) rewindable() bool {
+	return true
+}
+
+func (s *runSource) timeIndex() *TimeIndex {
+	return s.index
+}
+
+func (s *runSource) jump(ctx context.Context, entry int) error {
+	reader, err := s.open(entry)
+	if err != nil {
+		return err
+	}
+	s.close()
+	s.reader = reader
+	return nil
+}
+
+func (s *runSource) close() {
+	if s.reader != nil {
+		s.reader.Close()
+	}
+}
+
+// playState is the state of playback, owned by the playing goroutine
+type playState struct {
+	speed  float64
+	loop   bool
+	paused bool
+	step   bool
+	ended  bool
+	rewind bool
+
+	// seekStarted is set by a new seek, until the playing goroutine decides
+	// whether to rewind for it
+	seeking     bool
+	seekStarted bool
+	seekByEvent bool
+	seekTarget  float64
+
+	// index is the index of the next event from the source, and position
+	// the index of the last event passed on
+	index    uint64
+	position uint64
+
+	// runStart is the earliest frame timestamp of the first event with
+	// frames, and lastStamp that of the last event with frames passed on
+	// since the source started over, if passed is set
+	runStart  uint64
+	haveStart bool
+	lastStamp uint64
+	passed    bool
+
+	// moved is set when playback jumps, until the position is updated
+	moved bool
+
+	// playback is paced from the time start at the timestamp initStamp
+	anchored  bool
+	start     time.Time
+	initStamp uint64
+}
+
+// seek starts moving playback to a time or event index
+func (s *playState) seek(byEvent bool, target float64) {
+	s.seeking = true
+	s.seekStarted = true
+	s.seekByEvent = byEvent
+	s.seekTarget = target
+	s.ended = false
+}
+
+// restart resets the position for a source started over from the event with
+// the given index
+func (s *playState) restart(index uint64) {
+	s.index = index
+	s.ended = false
+	s.passed = false
+	s.anchored = false
+	s.moved = true
+}
+
+// seconds returns the time of a timestamp since the start of the run
+func (s *playState) seconds(stamp uint64) float64 {
+	if !s.haveStart || stamp < s.runStart {
+		return 0
+	}
+	return float64(stamp-s.runStart) / subsecdiv
+}
+
+// reached returns whether an event with the given index and timestamp is at
+// or past the seek target
+func (s *playState) reached(index, stamp uint64, hasFrames bool) bool {
+	if s.seekByEvent {
+		return float64(index) >= s.seekTarget
+	}
+	return hasFrames && s.seconds(stamp) >= s.seekTarget
+}
+
+// positionString formats the playback position
+func (s *playState) positionString() string {
+	parts := []string{
+		fmt.Sprintf("%.3f s", s.seconds(s.lastStamp)),
+		fmt.Sprintf("event %v", s.position),
+		fmt.Sprintf("speed %v", s.speed),
+	}
+	switch {
+	case s.ended:
+		parts = append(parts, "ended")
+	case s.paused:
+		parts = append(parts, "paused")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// earliestStamp returns the earliest frame timestamp of an event, and whether
+// the event has any frames
+func earliestStamp(event *proio.Event) (uint64, bool, error) {
+	earliest := uint64(math.MaxUint64)
+	hasFrames := false
+	for _, frameId := range event.TaggedEntries("Frame") {
+		frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+		if !ok {
+			return 0, false, fmt.Errorf("%w: %v", ErrBadFrame, event.Err)
+		}
+		hasFrames = true
+		if frame.Timestamp < earliest {
+			earliest = frame.Timestamp
+		}
+	}
+	return earliest, hasFrames, nil
+}
+
+func (p *Player) play(ctx context.Context, source playSource, output chan<- *proio.Event) error {
 	if p.Speed == 0.0 {
 		p.Speed = 1.0
 	}
-	durationScale := 1.0 / p.Speed
+	s := &playState{speed: p.Speed, loop: p.Loop}
+	controls := p.controlChan()
 
-	var start time.Time
-	initStamp := uint64(math.MaxUint64)
-	lastStamp := uint64(math.MaxUint64)
+	var metadata map[string][]byte
+	var position []byte
+	var positionTime time.Time
+	// sendPosition passes on an event with just the playback position
+	sendPosition := func() bool {
+		position = []byte(s.positionString())
+		positionTime = time.Now()
+		event := proio.NewEvent()
+		for key, value := range metadata {
+			event.Metadata[key] = value
+		}
+		event.Metadata[PlaybackPositionKey] = position
+		select {
+		case output <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	// apply makes a change to the state, passing on the new position
+	apply := func(change func(*playState)) bool {
+		paused, ended, speed := s.paused, s.ended, s.speed
+		change(s)
+		if s.paused != paused || s.ended != ended || s.speed != speed {
+			return sendPosition()
+		}
+		return true
+	}
+
+	// pending is the event read from the source that is next to be passed on
+	var pending *proio.Event
+	var pendingIndex, pendingStamp uint64
+	var pendingFrames bool
+	for {
+		// wait for controls while stopped
+		for (s.paused || s.ended) && !s.seeking && !s.step && !s.rewind {
+			select {
+			case change := <-controls:
+				if !apply(change) {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
 
-	for event := range input {
-		var earliest uint64
-		earliest = math.MaxUint64
-		for _, frameId := range event.TaggedEntries("Frame") {
-			frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
-			if !ok {
-				log.Println(event.Err)
-				return
+		if s.seekStarted {
+			s.seekStarted = false
+			next := s.index
+			if pending != nil {
+				next = pendingIndex
 			}
-			if frame.Timestamp < earliest {
-				earliest = frame.Timestamp
+			if s.seekByEvent {
+				s.rewind = s.seekTarget < float64(next)
+			} else {
+				s.rewind = s.passed && s.seconds(s.lastStamp) >= s.seekTarget
 			}
+
+			// jump through the time index rather than reading up to the
+			// target, unless the target is just ahead
+			if index := source.timeIndex(); index != nil && len(index.Entries) > 0 {
+				if !s.haveStart {
+					s.runStart, s.haveStart = index.Start, true
+				}
+				var entry int
+				if s.seekByEvent {
+					entry = index.FindEvent(uint64(s.seekTarget))
+				} else {
+					entry = index.FindTime(s.runStart + uint64(s.seekTarget*subsecdiv))
+				}
+				if entry >= 0 && (s.rewind || index.Entries[entry].Event > next) {
+					if err := source.jump(ctx, entry); err != nil {
+						log.Println(err)
+					} else {
+						pending = nil
+						s.rewind = false
+						s.restart(index.Entries[entry].Event)
+					}
+				}
+			}
+		}
+		if s.rewind {
+			s.rewind = false
+			pending = nil
+			if err := source.rewind(ctx); err != nil {
+				log.Println(err)
+				s.seeking = false
+				if !sendPosition() {
+					return nil
+				}
+				continue
+			}
+			s.restart(0)
 		}
-		var stampDiff float64
-		if earliest < lastStamp {
-			start = time.Now()
-			initStamp = earliest
-		} else {
-			stampDiff = durationScale * float64(earliest-initStamp) / subsecdiv
+		if s.seeking && pending != nil {
+			if s.reached(pendingIndex, pendingStamp, pendingFrames) {
+				s.seeking = false
+				s.anchored = false
+				s.moved = true
+			} else {
+				pending = nil
+			}
 		}
-		lastStamp = earliest
-		relTime := time.Duration(uint64(stampDiff))*time.Second +
-			time.Duration(uint64(math.Mod(stampDiff, 1.0)*1e9))*time.Nanosecond
-		time.Sleep(time.Until(start.Add(relTime)))
 
-		output <- event
+		if pending == nil {
+			event, err := source.next(ctx)
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if event == nil {
+				if !source.rewindable() {
+					return nil
+				}
+				if s.seeking {
+					log.Println("seeked past the end of the run")
+					s.seeking = false
+				}
+				if s.loop {
+					s.rewind = true
+					continue
+				}
+				s.ended, s.step = true, false
+				if !sendPosition() {
+					return nil
+				}
+				continue
+			}
+			index := s.index
+			s.index++
+
+			stamp, hasFrames, err := earliestStamp(event)
+			if err != nil {
+				return err
+			}
+			if hasFrames && !s.haveStart {
+				s.runStart, s.haveStart = stamp, true
+			}
+			metadata = event.Metadata
+			if s.seeking {
+				if !s.reached(index, stamp, hasFrames) {
+					continue
+				}
+				s.seeking = false
+				s.anchored = false
+				s.moved = true
+			}
+			pending, pendingIndex, pendingStamp, pendingFrames = event, index, stamp, hasFrames
+		}
+
+		// events without frames are passed on right away
+		if pendingFrames && !s.step {
+			if !s.anchored || (s.passed && pendingStamp < s.lastStamp) {
+				s.anchored = true
+				s.start = time.Now()
+				s.initStamp = pendingStamp
+			}
+			stampDiff := float64(pendingStamp-s.initStamp) / subsecdiv / s.speed
+			relTime := time.Duration(stampDiff * float64(time.Second))
+			timer := time.NewTimer(time.Until(s.start.Add(relTime)))
+			select {
+			case <-timer.C:
+			case change := <-controls:
+				timer.Stop()
+				if !apply(change) {
+					return nil
+				}
+				continue
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			}
+		}
+
+		s.position = pendingIndex
+		if pendingFrames {
+			s.lastStamp = pendingStamp
+			s.passed = true
+		}
+		if s.step {
+			s.step = false
+			s.paused = true
+			s.anchored = false
+		}
+		if position == nil || s.paused || s.moved || time.Since(positionTime) >= positionPeriod {
+			position = []byte(s.positionString())
+			positionTime = time.Now()
+			s.moved = false
+		}
+		pending.Metadata[PlaybackPositionKey] = position
+
+		select {
+		case output <- pending:
+		case <-ctx.Done():
+			return nil
+		}
+		pending = nil
 	}
 }
@@ -0,0 +1,246 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"math"
+
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+
+	"github.com/proio-org/go-proio"
+)
+
+// BaselineRestorer tracks the baseline of each mapped channel of a
+// pulsed-mode stream and subtracts it from the waveform.  Samples that are
+// more than Gate standard deviations above the baseline are considered to be
+// part of a pulse and do not update the baseline.
+type BaselineRestorer struct {
+	Alpha float64
+	Gate  float64
+
+	baselines [][]float64
+	variances [][]float64
+}
+
+func (r *BaselineRestorer) Restore(input <-chan *proio.Event, output chan<- *proio.Event) {
+	if r.Alpha == 0 {
+		r.Alpha = 0.01
+	}
+	invAlpha := 1 - r.Alpha
+
+	if r.Gate == 0 {
+		r.Gate = 3
+	}
+	gate2 := r.Gate * r.Gate
+
+	for event := range input {
+		rawFrameIds := event.TaggedEntries("Frame")
+		mappedFrameIds := event.TaggedEntries("Mapped")
+		if len(mappedFrameIds) != len(rawFrameIds) {
+			continue
+		}
+
+		for i, entryId := range mappedFrameIds {
+			frame, ok := event.GetEntry(entryId).(*currentmode.Frame)
+			if !ok {
+				continue
+			}
+			rawFrame, ok := event.GetEntry(rawFrameIds[i]).(*currentmode.Frame)
+			if !ok {
+				continue
+			}
+
+			// if the axis offsets already exist in the stream, assume that
+			// they were taken care of in the detector mapping, and do nothing
+			if rawFrame.AxisOffsets != nil {
+				continue
+			}
+
+			for sampleNum, sample := range frame.Sample {
+				for i, axis := range sample.Axis {
+					axis.Sum = 0
+
+					for len(r.baselines) <= i {
+						r.baselines = append(r.baselines, nil)
+						r.variances = append(r.variances, nil)
+					}
+
+					for j, val := range axis.FloatChannel {
+						if len(r.baselines[i]) <= j {
+							r.baselines[i] = append(r.baselines[i], float64(val))
+							r.variances[i] = append(r.variances[i], 0)
+						}
+
+						diff := float64(val) - r.baselines[i][j]
+						if r.variances[i][j] == 0 || diff*diff < gate2*r.variances[i][j] {
+							r.baselines[i][j] += r.Alpha * diff
+							r.variances[i][j] = invAlpha*r.variances[i][j] + r.Alpha*diff*diff
+						}
+
+						axis.FloatChannel[j] -= float32(r.baselines[i][j])
+						axis.Sum += axis.FloatChannel[j]
+					}
+				}
+
+				if sampleNum == 0 {
+					rawFrame.AxisOffsets = make([]*currentmode.AxisSample, len(sample.Axis))
+					for i := range rawFrame.AxisOffsets {
+						axis := &currentmode.AxisSample{}
+						rawFrame.AxisOffsets[i] = axis
+						axis.FloatChannel = make([]float32, len(sample.Axis[i].FloatChannel))
+						for j := range axis.FloatChannel {
+							axis.FloatChannel[j] = float32(r.baselines[i][j])
+						}
+					}
+				}
+			}
+		}
+
+		output <- event
+	}
+}
+
+// PulseFinder searches baseline-restored, mapped pulsed-mode frames for
+// pulses and adds a "Pulses" frame to the event.  Each sample of a "Pulses"
+// frame is one pulse, timestamped at the first threshold crossing.  The axis
+// float channels hold the pulse height of each channel within Window samples
+// of the crossing, the axis channels hold the number of samples from the
+// crossing to the peak (or -1 for channels that did not cross threshold), and
+// the axis sums hold the summed height of channels that crossed threshold.
+//
+// A channel crosses threshold if it is above Threshold, or above NSigma times
+// its noise RMS if Threshold is zero.
+type PulseFinder struct {
+	Threshold float32
+	NSigma    float64
+	Alpha     float64
+	Window    int
+
+	variances [][]float64
+}
+
+func (f *PulseFinder) Find(input <-chan *proio.Event, output chan<- *proio.Event) {
+	if f.NSigma == 0 {
+		f.NSigma = 5
+	}
+	if f.Alpha == 0 {
+		f.Alpha = 0.01
+	}
+	if f.Window == 0 {
+		f.Window = 8
+	}
+
+	for event := range input {
+		for _, entryId := range event.TaggedEntries("Pulses") {
+			event.RemoveEntry(entryId)
+		}
+
+		for _, entryId := range event.TaggedEntries("Mapped") {
+			frame, ok := event.GetEntry(entryId).(*currentmode.Frame)
+			if !ok {
+				continue
+			}
+
+			pulseFrame := &currentmode.Frame{
+				Timestamp: frame.Timestamp,
+			}
+
+			for i := 0; i < len(frame.Sample); i++ {
+				if !f.crossed(frame.Sample[i]) {
+					continue
+				}
+
+				end := i + f.Window
+				if end > len(frame.Sample) {
+					end = len(frame.Sample)
+				}
+				pulseFrame.Sample = append(pulseFrame.Sample, f.extract(frame.Sample[i:end]))
+				i = end - 1
+			}
+
+			event.AddEntry("Pulses", pulseFrame)
+		}
+
+		output <- event
+	}
+}
+
+func (f *PulseFinder) threshold(axis, channel int) float32 {
+	if f.Threshold != 0 {
+		return f.Threshold
+	}
+	if axis >= len(f.variances) || channel >= len(f.variances[axis]) {
+		return float32(math.Inf(1))
+	}
+	return float32(f.NSigma * math.Sqrt(f.variances[axis][channel]))
+}
+
+// crossed reports whether any channel of the sample is above threshold, and
+// updates the noise estimates with channels that are not
+func (f *PulseFinder) crossed(sample *currentmode.Sample) bool {
+	crossed := false
+	for i, axis := range sample.Axis {
+		for len(f.variances) <= i {
+			f.variances = append(f.variances, nil)
+		}
+
+		for j, val := range axis.FloatChannel {
+			if len(f.variances[i]) <= j {
+				f.variances[i] = append(f.variances[i], float64(val*val))
+				continue
+			}
+
+			if val > f.threshold(i, j) {
+				crossed = true
+			} else {
+				f.variances[i][j] *= 1 - f.Alpha
+				f.variances[i][j] += f.Alpha * float64(val*val)
+			}
+		}
+	}
+	return crossed
+}
+
+func (f *PulseFinder) extract(window []*currentmode.Sample) *currentmode.Sample {
+	pulse := &currentmode.Sample{
+		Timestamp: window[0].Timestamp,
+	}
+
+	for i, axis := range window[0].Axis {
+		pulseAxis := &currentmode.AxisSample{
+			FloatChannel: make([]float32, len(axis.FloatChannel)),
+			Channel:      make([]int32, len(axis.FloatChannel)),
+		}
+		pulse.Axis = append(pulse.Axis, pulseAxis)
+
+		for j := range pulseAxis.FloatChannel {
+			thres := f.threshold(i, j)
+			pulseAxis.Channel[j] = -1
+			pulseAxis.FloatChannel[j] = float32(math.Inf(-1))
+
+			for k, sample := range window {
+				if i >= len(sample.Axis) || j >= len(sample.Axis[i].FloatChannel) {
+					continue
+				}
+				val := sample.Axis[i].FloatChannel[j]
+				if val > pulseAxis.FloatChannel[j] {
+					pulseAxis.FloatChannel[j] = val
+					if val > thres {
+						pulseAxis.Channel[j] = int32(k)
+					}
+				}
+			}
+
+			if math.IsInf(float64(pulseAxis.FloatChannel[j]), -1) {
+				pulseAxis.FloatChannel[j] = 0
+			}
+			if pulseAxis.Channel[j] >= 0 {
+				pulseAxis.Sum += pulseAxis.FloatChannel[j]
+			}
+		}
+	}
+
+	return pulse
+}
@@ -0,0 +1,494 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Credentials locate and authorize an S3-compatible object store.  They are
+// given to the s3 backend as JSON.  Without keys, requests are sent
+// anonymously.  The endpoint defaults to AWS for the region, and the region
+// defaults to us-east-1.
+//
+// For requests made with a ServerContext, any empty fields are taken from the
+// environment variables S3_ENDPOINT, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_REGION.  Other requests, such as those of clients, only use the
+// credentials they are given, and may only give an endpoint on one of
+// S3EndpointHosts.
+type S3Credentials struct {
+	Endpoint  string `json:"endpoint"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	Region    string `json:"region"`
+}
+
+// S3EndpointHosts are the hosts that credentials not from the server may give
+// as their endpoint
+var S3EndpointHosts []string
+
+func parseS3Credentials(ctx context.Context, credentials string) (*S3Credentials, error) {
+	creds := &S3Credentials{}
+	if strings.TrimSpace(credentials) != "" {
+		if err := json.Unmarshal([]byte(credentials), creds); err != nil {
+			return nil, fmt.Errorf("bad s3 credentials: %v", err)
+		}
+	}
+
+	if IsServerContext(ctx) {
+		fallback := func(value *string, key string) {
+			if *value == "" {
+				*value = os.Getenv(key)
+			}
+		}
+		fallback(&creds.Endpoint, "S3_ENDPOINT")
+		fallback(&creds.AccessKey, "AWS_ACCESS_KEY_ID")
+		fallback(&creds.SecretKey, "AWS_SECRET_ACCESS_KEY")
+		fallback(&creds.Region, "AWS_REGION")
+	} else if creds.Endpoint != "" {
+		if err := checkS3Endpoint(creds.Endpoint); err != nil {
+			return nil, err
+		}
+	}
+	if creds.Region == "" {
+		creds.Region = "us-east-1"
+	}
+	if creds.Endpoint == "" {
+		creds.Endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", creds.Region)
+	}
+	creds.Endpoint = strings.TrimRight(creds.Endpoint, "/")
+	return creds, nil
+}
+
+// checkS3Endpoint returns an error unless endpoint is an http or https URL on
+// one of S3EndpointHosts
+func checkS3Endpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("bad s3 endpoint: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("s3 endpoint \"%v\" is not http or https", endpoint)
+	}
+	for _, host := range S3EndpointHosts {
+		if strings.EqualFold(u.Host, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("s3 endpoint host \"%v\" is not allowed", u.Host)
+}
+
+// s3Encode escapes all but the unreserved characters of s, as required for
+// request signing
+func s3Encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign signs a request with AWS signature version 4, given its canonical URI
+// and query and the hash of its payload.  The host and all headers of the
+// request are signed.
+func (c *S3Credentials) sign(req *http.Request, uriPath, rawQuery, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%v/%v/s3/aws4_request", now.Format("20060102"), c.Region)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriPath,
+		rawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+c.SecretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, c.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v,SignedHeaders=%v,Signature=%v",
+		c.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// request sends a path-style request for an object of a bucket, signed with
+// AWS signature version 4 along with any extra headers.  The payload is not
+// signed.
+func (c *S3Credentials) request(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	uriPath := "/" + s3Encode(bucket, true)
+	if key != "" {
+		uriPath += "/" + s3Encode(key, false)
+	}
+
+	var queryParts []string
+	for name, values := range query {
+		for _, value := range values {
+			queryParts = append(queryParts, s3Encode(name, true)+"="+s3Encode(value, true))
+		}
+	}
+	sort.Strings(queryParts)
+	rawQuery := strings.Join(queryParts, "&")
+
+	reqUrl, err := url.Parse(c.Endpoint + uriPath)
+	if err != nil {
+		return nil, err
+	}
+	reqUrl.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if c.AccessKey != "" {
+		c.sign(req, uriPath, rawQuery, "UNSIGNED-PAYLOAD", time.Now())
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.NewDecoder(resp.Body).Decode(&s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("s3 %v %v/%v: %v: %v", method, bucket, key, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("s3 %v %v/%v: %v", method, bucket, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// s3Storage is the storage backend for s3://bucket/key URLs.  Runs are listed
+// by their keys relative to the listed prefix.
+type s3Storage struct{}
+
+func (s3Storage) List(ctx context.Context, u *url.URL, credentials string) ([]*RunObject, error) {
+	creds, err := parseS3Credentials(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var runs []*RunObject
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := creds.request(ctx, http.MethodGet, u.Host, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			if path.Ext(object.Key) == ".proio" {
+				runs = append(runs, &RunObject{Name: strings.TrimPrefix(object.Key, prefix)})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return runs, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s s3Storage) Open(ctx context.Context, u *url.URL, credentials string) (io.ReadCloser, error) {
+	return s.OpenAt(ctx, u, credentials, 0)
+}
+
+// OpenAt requests the object from offset on
+func (s3Storage) OpenAt(ctx context.Context, u *url.URL, credentials string, offset int64) (io.ReadCloser, error) {
+	creds, err := parseS3Credentials(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := creds.request(ctx, http.MethodGet, u.Host, strings.TrimLeft(u.Path, "/"), nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		// the store ignored the range, so read up to the offset
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp.Body, nil
+}
+
+// s3PartSize is the size of the parts objects are uploaded in, which S3
+// requires to be at least 5 MiB for all but the last part
+const s3PartSize = 8 << 20
+
+// s3ObjectWriter uploads an object in parts as they fill, so that only one
+// part is held in memory.  Objects smaller than a part are uploaded with a
+// single request when closed.  If any part fails, the upload is aborted and
+// the error is returned by the following writes and Close.
+type s3ObjectWriter struct {
+	ctx         context.Context
+	creds       *S3Credentials
+	bucket, key string
+
+	part     bytes.Buffer
+	uploadId string
+	etags    []string
+	err      error
+}
+
+func (w *s3ObjectWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if room := s3PartSize - w.part.Len(); len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		w.part.Write(chunk)
+		p = p[len(chunk):]
+
+		if w.part.Len() == s3PartSize {
+			if err := w.uploadPart(); err != nil {
+				w.abort(err)
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// uploadPart uploads the buffered part, starting the multipart upload first
+// if needed
+func (w *s3ObjectWriter) uploadPart() error {
+	if w.uploadId == "" {
+		resp, err := w.creds.request(w.ctx, http.MethodPost, w.bucket, w.key, url.Values{"uploads": {""}}, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadId string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if result.UploadId == "" {
+			return fmt.Errorf("s3 %v/%v: no upload id", w.bucket, w.key)
+		}
+		w.uploadId = result.UploadId
+	}
+
+	query := url.Values{
+		"partNumber": {fmt.Sprint(len(w.etags) + 1)},
+		"uploadId":   {w.uploadId},
+	}
+	resp, err := w.creds.request(w.ctx, http.MethodPut, w.bucket, w.key, query, nil, bytes.NewReader(w.part.Bytes()), int64(w.part.Len()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.etags = append(w.etags, resp.Header.Get("ETag"))
+	w.part.Reset()
+	return nil
+}
+
+// abort gives up on the upload after an error
+func (w *s3ObjectWriter) abort(err error) {
+	w.err = err
+	w.part.Reset()
+	if w.uploadId != "" {
+		resp, err := w.creds.request(w.ctx, http.MethodDelete, w.bucket, w.key, url.Values{"uploadId": {w.uploadId}}, nil, nil, 0)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func (w *s3ObjectWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = fmt.Errorf("s3 %v/%v: writer is closed", w.bucket, w.key)
+
+	if w.uploadId == "" {
+		resp, err := w.creds.request(w.ctx, http.MethodPut, w.bucket, w.key, nil, nil, bytes.NewReader(w.part.Bytes()), int64(w.part.Len()))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if w.part.Len() > 0 {
+		if err := w.uploadPart(); err != nil {
+			w.abort(err)
+			return err
+		}
+	}
+
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{}
+	for i, etag := range w.etags {
+		complete.Parts = append(complete.Parts, completedPart{i + 1, etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		w.abort(err)
+		return err
+	}
+	resp, err := w.creds.request(w.ctx, http.MethodPost, w.bucket, w.key, url.Values{"uploadId": {w.uploadId}}, nil, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		w.abort(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// completion can fail after the response has started, in which case the
+	// error is in the body
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		w.abort(err)
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		err := fmt.Errorf("s3 %v/%v: %v: %v", w.bucket, w.key, result.Code, result.Message)
+		w.abort(err)
+		return err
+	}
+	return nil
+}
+
+func (s3Storage) Create(ctx context.Context, u *url.URL, credentials string) (io.WriteCloser, error) {
+	creds, err := parseS3Credentials(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ObjectWriter{
+		ctx:    ctx,
+		creds:  creds,
+		bucket: u.Host,
+		key:    strings.TrimLeft(u.Path, "/"),
+	}, nil
+}
+
+func (s3Storage) ModTime(ctx context.Context, u *url.URL, credentials string) (time.Time, error) {
+	creds, err := parseS3Credentials(ctx, credentials)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := creds.request(ctx, http.MethodHead, u.Host, strings.TrimLeft(u.Path, "/"), nil, nil, nil, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	return http.ParseTime(resp.Header.Get("Last-Modified"))
+}
+
+func init() {
+	RegisterStorage("s3", s3Storage{})
+}
@@ -8,10 +8,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
-	"path"
 	"path/filepath"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/proio-org/go-proio"
 )
@@ -20,76 +23,190 @@ type RunObject struct {
 	Name string
 }
 
+// Storage is a backend for the URLs of a scheme.  Backends are registered
+// with RegisterStorage.
+type Storage interface {
+	// List returns the runs at a URL
+	List(ctx context.Context, u *url.URL, credentials string) ([]*RunObject, error)
+	// Open opens the object at a URL for reading
+	Open(ctx context.Context, u *url.URL, credentials string) (io.ReadCloser, error)
+	// Create creates the object at a URL for writing.  The object is
+	// complete once closed.
+	Create(ctx context.Context, u *url.URL, credentials string) (io.WriteCloser, error)
+	// ModTime returns the last modification time of the object at a URL
+	ModTime(ctx context.Context, u *url.URL, credentials string) (time.Time, error)
+}
+
+// RangeStorage is implemented by backends that can open objects part way
+// through without reading what comes before
+type RangeStorage interface {
+	// OpenAt opens the object at a URL for reading from a byte offset
+	OpenAt(ctx context.Context, u *url.URL, credentials string, offset int64) (io.ReadCloser, error)
+}
+
+// ErrReadOnly is returned by backends that cannot create objects
+var ErrReadOnly = errors.New("storage is read-only")
+
+type serverContextKey struct{}
+
+// ServerContext returns a context for storage requests of URLs that the server
+// configures itself, rather than ones given by clients.  Backends may fall back
+// on credentials of the server only for such requests.
+func ServerContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serverContextKey{}, true)
+}
+
+// IsServerContext returns whether ctx was made with ServerContext
+func IsServerContext(ctx context.Context) bool {
+	server, _ := ctx.Value(serverContextKey{}).(bool)
+	return server
+}
+
+var (
+	storages     = make(map[string]Storage)
+	storageMutex sync.RWMutex
+)
+
+// RegisterStorage makes a backend available for URLs with the given scheme,
+// replacing any backend already registered for the scheme
+func RegisterStorage(scheme string, storage Storage) {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+
+	storages[scheme] = storage
+}
+
+// GetStorage parses a URL and returns the backend for its scheme
+func GetStorage(urlString string) (Storage, *url.URL, error) {
+	thisUrl, err := url.Parse(urlString)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	storageMutex.RLock()
+	defer storageMutex.RUnlock()
+
+	storage, ok := storages[thisUrl.Scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("bad url scheme \"%v\"", thisUrl.Scheme)
+	}
+	return storage, thisUrl, nil
+}
+
 func ListResourceRuns(ctx context.Context, urlString, credentials string) (runs []*RunObject, err error) {
-	var thisUrl *url.URL
-	thisUrl, err = url.Parse(urlString)
+	storage, thisUrl, err := GetStorage(urlString)
 	if err != nil {
 		return
 	}
+	return storage.List(ctx, thisUrl, credentials)
+}
 
-	switch thisUrl.Scheme {
-	case "gs":
-		runs, err = ListGcsRuns(
-			ctx,
-			thisUrl.Host,
-			strings.TrimLeft(thisUrl.Path, "/"),
-			[]byte(credentials),
-		)
-	case "file":
-		var files []string
-		files, err = filepath.Glob(fmt.Sprintf("%v/%v/*.proio", thisUrl.Host, strings.TrimLeft(thisUrl.Path, "/")))
-		for _, file := range files {
-			runs = append(runs, &RunObject{Name: path.Base(file)})
-		}
-	default:
-		err = errors.New("bad url scheme")
+func GetReader(ctx context.Context, urlString, credentials string) (reader *proio.Reader, err error) {
+	object, err := OpenObject(ctx, urlString, credentials)
+	if err != nil {
+		return
 	}
+	reader = proio.NewReader(object)
+	reader.DeferUntilClose(func() { object.Close() })
 	return
 }
 
-func GetReader(ctx context.Context, urlString, credentials string) (reader *proio.Reader, err error) {
-	var thisUrl *url.URL
-	thisUrl, err = url.Parse(urlString)
+func GetWriter(ctx context.Context, urlString, credentials string) (writer *proio.Writer, err error) {
+	object, err := CreateObject(ctx, urlString, credentials)
+	if err != nil {
+		return
+	}
+	writer = proio.NewWriter(object)
+	writer.DeferUntilClose(object.Close)
+	return
+}
+
+// OpenObject opens the object at a URL for reading
+func OpenObject(ctx context.Context, urlString, credentials string) (object io.ReadCloser, err error) {
+	storage, thisUrl, err := GetStorage(urlString)
+	if err != nil {
+		return
+	}
+	return storage.Open(ctx, thisUrl, credentials)
+}
+
+// OpenObjectAt opens the object at a URL for reading from a byte offset.  If
+// the backend is not a RangeStorage, the object is read up to the offset.
+func OpenObjectAt(ctx context.Context, urlString, credentials string, offset int64) (object io.ReadCloser, err error) {
+	storage, thisUrl, err := GetStorage(urlString)
 	if err != nil {
 		return
 	}
+	if rangeStorage, ok := storage.(RangeStorage); ok {
+		return rangeStorage.OpenAt(ctx, thisUrl, credentials, offset)
+	}
 
-	switch thisUrl.Scheme {
-	case "gs":
-		reader, err = CreateGcsReader(
-			ctx,
-			thisUrl.Host,
-			strings.TrimLeft(thisUrl.Path, "/"),
-			[]byte(credentials),
-		)
-	case "file":
-		reader, err = proio.Open(filepath.Clean(fmt.Sprintf("%v/%v", thisUrl.Host, strings.TrimLeft(thisUrl.Path, "/"))))
-	default:
-		err = errors.New("bad url scheme")
+	object, err = storage.Open(ctx, thisUrl, credentials)
+	if err != nil {
+		return
+	}
+	if _, err = io.CopyN(ioutil.Discard, object, offset); err != nil {
+		object.Close()
+		return nil, err
 	}
 	return
 }
 
-func GetWriter(ctx context.Context, urlString, credentials string) (writer *proio.Writer, err error) {
-	var thisUrl *url.URL
-	thisUrl, err = url.Parse(urlString)
+// ObjectURL returns the URL of an object given by URL or by local file path
+func ObjectURL(source string) (string, error) {
+	if thisUrl, err := url.Parse(source); err == nil && thisUrl.Scheme != "" {
+		return source, nil
+	}
+	path, err := filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(path), nil
+}
+
+// CreateObject creates the object at a URL for writing.  The object is
+// complete once closed.
+func CreateObject(ctx context.Context, urlString, credentials string) (object io.WriteCloser, err error) {
+	storage, thisUrl, err := GetStorage(urlString)
 	if err != nil {
 		return
 	}
+	return storage.Create(ctx, thisUrl, credentials)
+}
+
+// CountingWriter passes writes on to W, counting the bytes written
+type CountingWriter struct {
+	W io.Writer
+
+	n int64
+}
+
+func (w *CountingWriter) Write(p []byte) (int, error) {
+	n, err := w.W.Write(p)
+	atomic.AddInt64(&w.n, int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes written so far
+func (w *CountingWriter) Count() int64 {
+	return atomic.LoadInt64(&w.n)
+}
 
-	switch thisUrl.Scheme {
-	case "gs":
-		writer, err = CreateGcsWriter(
-			ctx,
-			thisUrl.Host,
-			strings.TrimLeft(thisUrl.Path, "/"),
-			[]byte(credentials),
-		)
-	case "file":
-		writer, err = proio.Create(filepath.Clean(fmt.Sprintf("%v/%v", thisUrl.Host, thisUrl.Path)))
-	default:
-		err = errors.New("bad url scheme")
+// ReadObject reads the entire contents of the object at a URL
+func ReadObject(ctx context.Context, urlString, credentials string) (buf []byte, err error) {
+	object, err := OpenObject(ctx, urlString, credentials)
+	if err != nil {
+		return
 	}
+	defer object.Close()
+	return ioutil.ReadAll(object)
+}
 
-	return
+// ObjectModTime returns the last modification time of the object at a URL
+func ObjectModTime(ctx context.Context, urlString, credentials string) (modTime time.Time, err error) {
+	storage, thisUrl, err := GetStorage(urlString)
+	if err != nil {
+		return
+	}
+	return storage.ModTime(ctx, thisUrl, credentials)
 }
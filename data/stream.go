@@ -5,15 +5,25 @@
 package data
 
 import (
+	"context"
+
 	"github.com/proio-org/go-proio"
 )
 
 type StreamProcessor func(<-chan *proio.Event, chan<- *proio.Event)
 
+// ContextStreamProcessor is a StreamProcessor that can be cancelled and can
+// fail.  It must return once input is closed or ctx is done, and must not
+// block sending output once ctx is done.
+type ContextStreamProcessor func(ctx context.Context, input <-chan *proio.Event, output chan<- *proio.Event) error
+
+// StreamOp runs StreamProcessor, or ContextStreamProcessor if it is set, on
+// the stream
 type StreamOp struct {
-	Description     string
-	StreamProcessor StreamProcessor
-	MaxEventBuf     int
+	Description            string
+	StreamProcessor        StreamProcessor
+	ContextStreamProcessor ContextStreamProcessor
+	MaxEventBuf            int
 }
 
 func (o StreamOp) GetDescription() string {
@@ -21,17 +31,34 @@ func (o StreamOp) GetDescription() string {
 }
 
 func (o StreamOp) Run(input <-chan *proio.Event) <-chan *proio.Event {
+	return o.RunContext(context.Background(), input, logFail)
+}
+
+func (o StreamOp) RunContext(ctx context.Context, input <-chan *proio.Event, fail func(error)) <-chan *proio.Event {
 	if o.MaxEventBuf == 0 {
 		o.MaxEventBuf = *maxEventBuf
 	}
 
 	output := make(chan *proio.Event, o.MaxEventBuf)
 
+	if o.ContextStreamProcessor != nil {
+		go func() {
+			defer close(output)
+
+			if err := o.ContextStreamProcessor(ctx, input, output); err != nil {
+				fail(err)
+			}
+		}()
+		return output
+	}
+
+	input = relayContext(ctx, input)
 	go func() {
 		defer close(output)
 
 		o.StreamProcessor(input, output)
 	}()
+	drainOnDone(ctx, output)
 
 	return output
 }
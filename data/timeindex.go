@@ -0,0 +1,347 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/proio-org/go-proio"
+)
+
+// TimeIndexSuffix is added to the URL of a run to give the URL of its time
+// index
+const TimeIndexSuffix = ".index"
+
+// DefaultTimeIndexInterval is the least time in seconds between the entries of
+// a time index that does not set one
+const DefaultTimeIndexInterval = 1.0
+
+// TimeIndex maps the frame timestamps of a run to the offsets of the buckets
+// that hold them, so that the run can be read from part way through.  Each
+// entry starts at a bucket beginning with the first event with frames at
+// least Interval seconds after the start of the previous entry.  Start is the
+// earliest frame timestamp of the first event with frames, and End the latest
+// of any event.
+//
+// Indexes are kept as JSON next to their runs, with TimeIndexSuffix added to
+// the name of the run.
+type TimeIndex struct {
+	Interval float64           `json:"interval"`
+	Start    uint64            `json:"start"`
+	End      uint64            `json:"end"`
+	Events   uint64            `json:"events"`
+	Size     int64             `json:"size"`
+	Entries  []*TimeIndexEntry `json:"entries"`
+}
+
+// TimeIndexEntry is a point of a run to start reading from.  Offset is the
+// byte offset of its bucket, Event the index of its first event, and Start
+// and End the range of the earliest frame timestamps of its events, where End
+// is never less than that of the previous entry.  Metadata holds the metadata
+// of the run that changed since the previous entry.
+type TimeIndexEntry struct {
+	Offset   int64             `json:"offset"`
+	Event    uint64            `json:"event"`
+	Start    uint64            `json:"start"`
+	End      uint64            `json:"end"`
+	Metadata map[string][]byte `json:"metadata,omitempty"`
+}
+
+// FindTime returns the entry to start reading from for the events with frame
+// timestamps from stamp on, or -1 if the index has no entries
+func (idx *TimeIndex) FindTime(stamp uint64) int {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].End >= stamp
+	})
+	if i == len(idx.Entries) {
+		return i - 1
+	}
+	return i
+}
+
+// FindEvent returns the last entry starting at or before the event with the
+// given index, or -1 if there is none
+func (idx *TimeIndex) FindEvent(index uint64) int {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Event > index
+	})
+	return i - 1
+}
+
+// Metadata returns the metadata of the run as of entry i
+func (idx *TimeIndex) Metadata(i int) map[string][]byte {
+	metadata := make(map[string][]byte)
+	for _, entry := range idx.Entries[:i+1] {
+		for key, value := range entry.Metadata {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// timeIndexBuilder adds the entries of a time index as a run is written or
+// read
+type timeIndexBuilder struct {
+	index    *TimeIndex
+	interval uint64
+	metadata map[string][]byte
+}
+
+func newTimeIndexBuilder(interval float64) *timeIndexBuilder {
+	if interval <= 0 {
+		interval = DefaultTimeIndexInterval
+	}
+	return &timeIndexBuilder{
+		index:    &TimeIndex{Interval: interval},
+		interval: uint64(math.Round(interval * subsecdiv)),
+		metadata: make(map[string][]byte),
+	}
+}
+
+// due returns whether an event with frames at stamp starts a new entry
+func (b *timeIndexBuilder) due(stamp uint64) bool {
+	entries := b.index.Entries
+	return len(entries) == 0 || stamp >= entries[len(entries)-1].Start+b.interval
+}
+
+// add adds an entry with the metadata of the run at its start
+func (b *timeIndexBuilder) add(offset int64, event, stamp uint64, metadata map[string][]byte) {
+	entry := &TimeIndexEntry{Offset: offset, Event: event, Start: stamp, End: b.index.End}
+	for key, value := range metadata {
+		if last, ok := b.metadata[key]; !ok || !bytes.Equal(last, value) {
+			if entry.Metadata == nil {
+				entry.Metadata = make(map[string][]byte)
+			}
+			entry.Metadata[key] = value
+			b.metadata[key] = value
+		}
+	}
+	if len(b.index.Entries) == 0 {
+		b.index.Start = stamp
+	}
+	b.index.Entries = append(b.index.Entries, entry)
+	b.update(stamp)
+}
+
+// update extends the last entry to an event with frames at stamp
+func (b *timeIndexBuilder) update(stamp uint64) {
+	if stamp > b.index.End {
+		b.index.End = stamp
+	}
+	if n := len(b.index.Entries); n > 0 {
+		b.index.Entries[n-1].End = b.index.End
+	}
+}
+
+// IndexedWriter writes a run like its proio.Writer while building the time
+// index of the run.  Buckets are flushed as entries of the index start, and
+// the writer must be given the stream the proio.Writer writes to so that the
+// offsets of the buckets are known.
+type IndexedWriter struct {
+	*proio.Writer
+
+	stream   *CountingWriter
+	builder  *timeIndexBuilder
+	events   uint64
+	pushed   map[string][]byte
+	metadata map[string][]byte
+}
+
+// NewIndexedWriter returns a writer of stream that indexes entries at least
+// interval seconds apart
+func NewIndexedWriter(stream *CountingWriter, interval float64) *IndexedWriter {
+	return &IndexedWriter{
+		Writer:   proio.NewWriter(stream),
+		stream:   stream,
+		builder:  newTimeIndexBuilder(interval),
+		pushed:   make(map[string][]byte),
+		metadata: make(map[string][]byte),
+	}
+}
+
+// Push writes an event, starting an entry of the index with it if due
+func (w *IndexedWriter) Push(event *proio.Event) error {
+	// follow the metadata pushed by the proio.Writer for the event, which
+	// goes in the header of the bucket of the event
+	for key, value := range event.Metadata {
+		if !bytes.Equal(w.pushed[key], value) {
+			w.pushed[key] = value
+			w.metadata[key] = value
+		}
+	}
+
+	stamp, hasFrames, err := earliestStamp(event)
+	if err == nil && hasFrames {
+		if w.builder.due(stamp) {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			w.builder.add(w.stream.Count(), w.events, stamp, w.metadata)
+		} else {
+			w.builder.update(stamp)
+		}
+	}
+	w.events++
+	return w.Writer.Push(event)
+}
+
+// PushMetadata sets metadata for the events that follow
+func (w *IndexedWriter) PushMetadata(name string, data []byte) error {
+	w.metadata[name] = data
+	return w.Writer.PushMetadata(name, data)
+}
+
+// Index returns the time index of the events written so far, which is
+// complete once the writer is closed
+func (w *IndexedWriter) Index() *TimeIndex {
+	w.builder.index.Events = w.events
+	w.builder.index.Size = w.stream.Count()
+	return w.builder.index
+}
+
+// countingReader passes reads on from R, counting the bytes read
+type countingReader struct {
+	R io.Reader
+
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// BuildTimeIndex reads the run at a URL to build its time index, with entries
+// at least interval seconds apart
+func BuildTimeIndex(ctx context.Context, urlString, credentials string, interval float64) (*TimeIndex, error) {
+	object, err := OpenObject(ctx, urlString, credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	stream := &countingReader{R: object}
+	reader := proio.NewReader(stream)
+	defer reader.Close()
+
+	builder := newTimeIndexBuilder(interval)
+	var events uint64
+	// header is the header of the bucket being read, which changes with
+	// each bucket
+	var header interface{}
+	// a bucket can start an entry until its first event with frames
+	var candidate bool
+	var candOffset int64
+	var candEvent uint64
+	var candMetadata map[string][]byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		offset := stream.n
+		event := reader.Next()
+		if event == nil {
+			if reader.Err == nil || reader.Err == io.EOF || reader.Err == io.ErrUnexpectedEOF {
+				break
+			}
+			// the reader resynchronizes on the next bucket
+			log.Println(reader.Err)
+			continue
+		}
+		if reader.BucketHeader != header {
+			header = reader.BucketHeader
+			candidate, candOffset, candEvent = true, offset, events
+			candMetadata = make(map[string][]byte)
+			for key, value := range reader.Metadata {
+				candMetadata[key] = value
+			}
+		}
+		events++
+
+		stamp, hasFrames, err := earliestStamp(event)
+		if err != nil {
+			return nil, fmt.Errorf("event %v: %v", events-1, err)
+		}
+		if !hasFrames {
+			continue
+		}
+		if candidate && builder.due(stamp) {
+			builder.add(candOffset, candEvent, stamp, candMetadata)
+		} else {
+			builder.update(stamp)
+		}
+		candidate = false
+	}
+
+	builder.index.Events = events
+	builder.index.Size = stream.n
+	return builder.index, nil
+}
+
+// ReadTimeIndex reads the time index at a URL
+func ReadTimeIndex(ctx context.Context, urlString, credentials string) (*TimeIndex, error) {
+	buf, err := ReadObject(ctx, urlString, credentials)
+	if err != nil {
+		return nil, err
+	}
+	index := &TimeIndex{}
+	if err := json.Unmarshal(buf, index); err != nil {
+		return nil, fmt.Errorf("bad time index %v: %v", urlString, err)
+	}
+	return index, nil
+}
+
+// WriteTimeIndex writes a time index to a URL
+func WriteTimeIndex(ctx context.Context, urlString, credentials string, index *TimeIndex) error {
+	buf, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	object, err := CreateObject(ctx, urlString, credentials)
+	if err != nil {
+		return err
+	}
+	if _, err := object.Write(buf); err != nil {
+		object.Close()
+		return err
+	}
+	return object.Close()
+}
+
+// GetReaderAt opens the run at a URL for reading from entry i of its time
+// index, or from the start if i is negative
+func GetReaderAt(ctx context.Context, urlString, credentials string, index *TimeIndex, i int) (*proio.Reader, error) {
+	if i < 0 {
+		return GetReader(ctx, urlString, credentials)
+	}
+	object, err := OpenObjectAt(ctx, urlString, credentials, index.Entries[i].Offset)
+	if err != nil {
+		return nil, err
+	}
+	reader := proio.NewReader(object)
+	reader.DeferUntilClose(func() { object.Close() })
+	for key, value := range index.Metadata(i) {
+		reader.Metadata[key] = value
+	}
+	return reader, nil
+}
+
+// OpenRunAt opens the run at a URL for reading from the entry of its time
+// index holding the given number of seconds after the first frame of the run
+func OpenRunAt(ctx context.Context, urlString, credentials string, seconds float64) (*proio.Reader, error) {
+	index, err := ReadTimeIndex(ctx, urlString+TimeIndexSuffix, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("no time index for %v: %v", urlString, err)
+	}
+	i := index.FindTime(index.Start + uint64(seconds*subsecdiv))
+	return GetReaderAt(ctx, urlString, credentials, index, i)
+}
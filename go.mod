@@ -18,14 +18,17 @@ require (
 	github.com/onsi/ginkgo v1.14.2 // indirect
 	github.com/onsi/gomega v1.10.3 // indirect
 	github.com/proio-org/go-proio v0.7.0
+	github.com/prometheus/client_golang v0.9.4
 	github.com/sevlyar/go-daemon v0.1.5
 	github.com/skratchdot/open-golang v0.0.0-20190402232053-79abb63cd66e
 	github.com/yuin/gopher-lua v0.0.0-20190514113301-1cd887cd7036 // indirect
 	go-hep.org/x/hep v0.17.1
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/exp v0.0.0-20190121172915-509febef88a4
 	golang.org/x/net v0.0.0-20201006153459-a7d1128ccaa0
 	golang.org/x/oauth2 v0.0.0-20190226205417-e64efc72b421
 	gonum.org/v1/gonum v0.0.0-20190111083114-e53627a82652
 	gonum.org/v1/plot v0.0.0-20190111083220-212db91bf0b8
 	google.golang.org/api v0.5.0
+	gopkg.in/yaml.v2 v2.4.0
 )
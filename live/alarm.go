@@ -0,0 +1,506 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rditech/rdi-live/live/message"
+)
+
+// AlarmLogFile and AlarmWebhook are the defaults for new alarm engines
+var (
+	AlarmLogFile string
+	AlarmWebhook string
+)
+
+// AlarmWebhookHosts are the hosts that the webhooks of individual rules may
+// post to.  Rules with webhooks on other hosts are rejected, so that without
+// any hosts only AlarmWebhook is used.
+var AlarmWebhookHosts []string
+
+// CheckAlarmWebhook returns an error unless webhook is an http or https URL on
+// one of AlarmWebhookHosts
+func CheckAlarmWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return fmt.Errorf("bad alarm webhook: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("alarm webhook \"%v\" is not http or https", webhook)
+	}
+	for _, host := range AlarmWebhookHosts {
+		if strings.EqualFold(u.Host, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("alarm webhook host \"%v\" is not allowed", u.Host)
+}
+
+// AlarmRule is a condition on the values of a source.  The condition is met
+// when the value is below Low ("<"), above High (">"), outside [Low, High]
+// ("outside") or inside [Low, High] ("inside").  The alarm activates once the
+// condition has been met continuously for For, and clears once the value is
+// back within the limits by at least Hysteresis.
+type AlarmRule struct {
+	Name       string
+	Source     string
+	Op         string
+	Low, High  float64
+	For        time.Duration
+	Hysteresis float64
+	Webhook    string
+}
+
+var (
+	alarmRuleRegexp  = regexp.MustCompile(`^\s*(.+?)\s+(<|>|outside|inside)\s+(.+?)(?:\s+for\s+(.+?))?\s*$`)
+	alarmLimitRegexp = regexp.MustCompile(`^(±|\+-|\+/-)?([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)(\D*)$`)
+	alarmSignRegexp  = regexp.MustCompile(`(±|\+-|\+/-)\s+`)
+	alarmUnitRegexp  = regexp.MustCompile(`[[:alpha:]]+`)
+)
+
+// alarmRuleGrammar is shown with rules that cannot be parsed
+const alarmRuleGrammar = "<source> < <limit> | <source> > <limit> | " +
+	"<source> outside|inside <low> <high> | <source> outside|inside ±<limit>, " +
+	"each optionally followed by \"for <duration>\""
+
+// ampereExponents are the powers of ten of prefixed ampere units
+var ampereExponents = map[string]int{
+	"pA": -12,
+	"nA": -9,
+	"uA": -6,
+	"µA": -6,
+	"mA": -3,
+	"A":  0,
+	"kA": 3,
+}
+
+// durationUnits are the names of duration units that time.ParseDuration does
+// not know
+var durationUnits = map[string]string{
+	"sec":     "s",
+	"secs":    "s",
+	"second":  "s",
+	"seconds": "s",
+	"min":     "m",
+	"mins":    "m",
+	"minute":  "m",
+	"minutes": "m",
+	"hr":      "h",
+	"hrs":     "h",
+	"hour":    "h",
+	"hours":   "h",
+}
+
+// ParseAlarmRule parses rules of the forms
+//
+//	<source> < <limit> [for <duration>]
+//	<source> > <limit> [for <duration>]
+//	<source> outside <low> <high> [for <duration>]
+//	<source> inside <low> <high> [for <duration>]
+//	<source> outside ±<limit> [for <duration>]
+//	<source> inside ±<limit> [for <duration>]
+//
+// for example "Total Current < 1 nA for 5 s" or "Mean X outside ±2 mm".
+// Limits may be followed by a unit, with or without a space.  Currents are in
+// amperes, so prefixed ampere units such as nA and uA scale the limit, while
+// other units, such as mm, are taken as the units of the source and leave the
+// limit as written.  Durations are given as, e.g., "5s", "5 s", "1m30s" or
+// "2 min".
+func ParseAlarmRule(text string) (*AlarmRule, error) {
+	match := alarmRuleRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return nil, fmt.Errorf("bad alarm rule \"%v\", expected %v", text, alarmRuleGrammar)
+	}
+
+	rule := &AlarmRule{
+		Source: match[1],
+		Op:     match[2],
+	}
+	limits, symmetric, err := parseAlarmLimits(match[3])
+	if err != nil {
+		return nil, err
+	}
+
+	switch rule.Op {
+	case "<", ">":
+		if symmetric {
+			return nil, fmt.Errorf("alarm rule \"%v\" has a ± limit without outside or inside", text)
+		}
+		if len(limits) != 1 {
+			return nil, fmt.Errorf("alarm rule \"%v\" needs one limit", text)
+		}
+		if rule.Op == "<" {
+			rule.Low = limits[0]
+		} else {
+			rule.High = limits[0]
+		}
+	case "outside", "inside":
+		if len(limits) != 2 {
+			return nil, fmt.Errorf("alarm rule \"%v\" needs two limits", text)
+		}
+		rule.Low, rule.High = limits[0], limits[1]
+		if rule.Low > rule.High {
+			rule.Low, rule.High = rule.High, rule.Low
+		}
+	}
+
+	if match[4] != "" {
+		rule.For, err = parseAlarmDuration(match[4])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rule, nil
+}
+
+// parseAlarmLimits parses limits with optional units, where a ± limit gives
+// both the negative and the positive limit
+func parseAlarmLimits(text string) (limits []float64, symmetric bool, err error) {
+	unitDone := true
+	text = alarmSignRegexp.ReplaceAllString(text, "$1")
+	for _, field := range strings.Fields(text) {
+		match := alarmLimitRegexp.FindStringSubmatch(field)
+		if match == nil {
+			// a unit on its own applies to the limit before it
+			if unitDone {
+				return nil, false, fmt.Errorf("bad alarm limit \"%v\"", field)
+			}
+			scaleLimits(limits, symmetric, field)
+			unitDone = true
+			continue
+		}
+
+		value, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("bad alarm limit \"%v\"", field)
+		}
+		if match[1] != "" {
+			if len(limits) > 0 {
+				return nil, false, fmt.Errorf("bad alarm limits \"%v\"", text)
+			}
+			symmetric = true
+			limits = append(limits, -math.Abs(value), math.Abs(value))
+		} else {
+			if symmetric {
+				return nil, false, fmt.Errorf("bad alarm limits \"%v\"", text)
+			}
+			limits = append(limits, value)
+		}
+
+		unitDone = match[3] != ""
+		if unitDone {
+			scaleLimits(limits, symmetric, match[3])
+		}
+	}
+	return limits, symmetric, nil
+}
+
+// scaleLimits applies the unit of the last limit, or of both limits if the
+// limit is symmetric
+func scaleLimits(limits []float64, symmetric bool, unit string) {
+	exp, ok := ampereExponents[unit]
+	if !ok {
+		return
+	}
+	n := 1
+	if symmetric {
+		n = 2
+	}
+	for i := len(limits) - n; i < len(limits); i++ {
+		if exp < 0 {
+			limits[i] /= math.Pow10(-exp)
+		} else {
+			limits[i] *= math.Pow10(exp)
+		}
+	}
+}
+
+// parseAlarmDuration parses durations as time.ParseDuration, but also allows
+// spaces and longer unit names
+func parseAlarmDuration(text string) (time.Duration, error) {
+	compact := strings.Join(strings.Fields(text), "")
+	compact = alarmUnitRegexp.ReplaceAllStringFunc(compact, func(unit string) string {
+		if short, ok := durationUnits[strings.ToLower(unit)]; ok {
+			return short
+		}
+		return unit
+	})
+	duration, err := time.ParseDuration(compact)
+	if err != nil {
+		return 0, fmt.Errorf("bad alarm duration \"%v\"", text)
+	}
+	return duration, nil
+}
+
+func (r *AlarmRule) String() string {
+	var text string
+	switch r.Op {
+	case "<":
+		text = fmt.Sprintf("%v < %v", r.Source, r.Low)
+	case ">":
+		text = fmt.Sprintf("%v > %v", r.Source, r.High)
+	default:
+		text = fmt.Sprintf("%v %v %v %v", r.Source, r.Op, r.Low, r.High)
+	}
+	if r.For > 0 {
+		text += fmt.Sprintf(" for %v", r.For)
+	}
+	return text
+}
+
+// met returns whether the condition is met by value, with the limits moved
+// outward by margin
+func (r *AlarmRule) met(value, margin float64) bool {
+	switch r.Op {
+	case "<":
+		return value < r.Low+margin
+	case ">":
+		return value > r.High-margin
+	case "outside":
+		return value < r.Low+margin || value > r.High-margin
+	case "inside":
+		return value > r.Low-margin && value < r.High+margin
+	}
+	return false
+}
+
+type alarmState struct {
+	rule      *AlarmRule
+	metSince  time.Time
+	active    bool
+	lastValue float64
+}
+
+// AlarmEngine evaluates alarm rules against source values.  Alarm state
+// changes are published on the stream channel as "alarm" messages, appended
+// as JSON lines to LogFile, and POSTed as JSON to the webhook of the rule or
+// else Webhook.
+type AlarmEngine struct {
+	Stream  string
+	LogFile string
+	Webhook string
+	Publish func(msg *message.Msg)
+	Client  *http.Client
+
+	states   map[string]*alarmState
+	bySource map[string][]*alarmState
+	posts    chan alarmPost
+	closed   bool
+	mutex    sync.Mutex
+}
+
+type alarmPost struct {
+	url  string
+	body []byte
+}
+
+// SetRule adds a rule, replacing any rule with the same name
+func (e *AlarmEngine) SetRule(rule *AlarmRule) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.states == nil {
+		e.states = make(map[string]*alarmState)
+	}
+	e.removeRule(rule.Name)
+	e.states[rule.Name] = &alarmState{rule: rule}
+	e.index()
+}
+
+// RemoveRule removes the rule with the given name
+func (e *AlarmEngine) RemoveRule(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.removeRule(name)
+	e.index()
+}
+
+func (e *AlarmEngine) removeRule(name string) {
+	if state, ok := e.states[name]; ok && state.active {
+		e.notify(state, false, time.Now())
+	}
+	delete(e.states, name)
+}
+
+func (e *AlarmEngine) index() {
+	e.bySource = make(map[string][]*alarmState)
+	for _, state := range e.states {
+		e.bySource[state.rule.Source] = append(e.bySource[state.rule.Source], state)
+	}
+}
+
+// Close stops webhook posts once those already queued are sent
+func (e *AlarmEngine) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.posts != nil {
+		close(e.posts)
+		e.posts = nil
+	}
+	e.closed = true
+}
+
+// Watches returns whether any rule is defined on source
+func (e *AlarmEngine) Watches(source string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return len(e.bySource[source]) > 0
+}
+
+// Summary describes each rule and whether it is active, as HTML lines
+func (e *AlarmEngine) Summary() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var names []string
+	for name := range e.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		state := e.states[name]
+		line := fmt.Sprintf("%v: %v", name, state.rule)
+		if state.active {
+			line += " (ACTIVE)"
+		}
+		lines = append(lines, html.EscapeString(line))
+	}
+	if len(lines) == 0 {
+		return "none"
+	}
+	return strings.Join(lines, "<br>")
+}
+
+// Update evaluates the rules on source with a new value
+func (e *AlarmEngine) Update(source string, value float64, now time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, state := range e.bySource[source] {
+		rule := state.rule
+		state.lastValue = value
+
+		if !state.active {
+			if !rule.met(value, 0) {
+				state.metSince = time.Time{}
+				continue
+			}
+			if state.metSince.IsZero() {
+				state.metSince = now
+			}
+			if now.Sub(state.metSince) >= rule.For {
+				state.active = true
+				e.notify(state, true, now)
+			}
+		} else if !rule.met(value, rule.Hysteresis) {
+			state.active = false
+			state.metSince = time.Time{}
+			e.notify(state, false, now)
+		}
+	}
+}
+
+func (e *AlarmEngine) notify(state *alarmState, active bool, now time.Time) {
+	msg := &message.Msg{
+		Type:     "alarm",
+		Metadata: make(map[string]string),
+	}
+	msg.Metadata["stream"] = e.Stream
+	msg.Metadata["name"] = state.rule.Name
+	msg.Metadata["rule"] = state.rule.String()
+	msg.Metadata["value"] = strconv.FormatFloat(state.lastValue, 'g', 6, 64)
+	msg.Metadata["time"] = now.UTC().Format(time.RFC3339Nano)
+	if active {
+		msg.Metadata["state"] = "active"
+	} else {
+		msg.Metadata["state"] = "cleared"
+	}
+
+	log.Printf("alarm %v on stream %v %v: %v (value %v)\n",
+		state.rule.Name, e.Stream, msg.Metadata["state"], msg.Metadata["rule"], msg.Metadata["value"])
+
+	if e.Publish != nil {
+		e.Publish(msg)
+	}
+
+	msgBytes, err := json.Marshal(msg.Metadata)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if e.LogFile != "" {
+		if err := appendLine(e.LogFile, msgBytes); err != nil {
+			log.Println("unable to log alarm:", err)
+		}
+	}
+
+	webhook := state.rule.Webhook
+	if webhook == "" {
+		webhook = e.Webhook
+	}
+	if webhook != "" && !e.closed {
+		if e.posts == nil {
+			e.posts = make(chan alarmPost, 100)
+			go e.post()
+		}
+		select {
+		case e.posts <- alarmPost{webhook, msgBytes}:
+		default:
+			log.Println("dropping alarm webhook post")
+		}
+	}
+}
+
+// post sends webhook posts in order
+func (e *AlarmEngine) post() {
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	for post := range e.posts {
+		resp, err := client.Post(post.url, "application/json", bytes.NewReader(post.body))
+		if err != nil {
+			log.Println("unable to post alarm:", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Println("alarm webhook returned", resp.Status)
+		}
+	}
+}
+
+func appendLine(filename string, line []byte) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
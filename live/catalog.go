@@ -0,0 +1,341 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Catalog is where recorded runs are indexed.  If nil, runs are not
+// cataloged.
+var Catalog *RunCatalog
+
+var runsBucket = []byte("runs")
+
+// RunRecord describes a recorded run.  Stop is zero while the run is being
+// recorded, or if rdi-live stopped before the run was closed.
+type RunRecord struct {
+	URL       string
+	Name      string
+	Namespace string
+	Stream    string
+	UID       string
+	Detector  string
+	User      string
+	Start     time.Time
+	Stop      time.Time
+	Events    uint64
+	Dropped   uint64
+	Notes     string
+	Tags      []string
+	Metadata  map[string]string
+}
+
+// RunCatalog indexes recorded runs by URL in a bolt database file
+type RunCatalog struct {
+	db *bolt.DB
+}
+
+// OpenRunCatalog opens the catalog in filename, creating it if needed
+func OpenRunCatalog(filename string) (*RunCatalog, error) {
+	db, err := bolt.Open(filename, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &RunCatalog{db: db}, nil
+}
+
+func (c *RunCatalog) Close() error {
+	return c.db.Close()
+}
+
+// Put adds or replaces the record of a run
+func (c *RunCatalog) Put(record *RunRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).Put([]byte(record.URL), recordBytes)
+	})
+}
+
+// Get returns the record of the run at url, or nil if there is none
+func (c *RunCatalog) Get(url string) (*RunRecord, error) {
+	var record *RunRecord
+	err := c.db.View(func(tx *bolt.Tx) error {
+		recordBytes := tx.Bucket(runsBucket).Get([]byte(url))
+		if recordBytes == nil {
+			return nil
+		}
+		record = &RunRecord{}
+		return json.Unmarshal(recordBytes, record)
+	})
+	return record, err
+}
+
+// Update applies update to the record of the run at url
+func (c *RunCatalog) Update(url string, update func(*RunRecord)) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		recordBytes := bucket.Get([]byte(url))
+		if recordBytes == nil {
+			return fmt.Errorf("no run \"%v\" in catalog", url)
+		}
+		record := &RunRecord{}
+		if err := json.Unmarshal(recordBytes, record); err != nil {
+			return err
+		}
+
+		update(record)
+
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(url), recordBytes)
+	})
+}
+
+// RunQuery selects runs by stream, UID, detector name, user and tag, by text
+// found in the name, notes, tags or metadata of the run, and by start time.
+// Empty fields match any run.
+type RunQuery struct {
+	Namespaces []string
+	Stream     string
+	UID        string
+	Detector   string
+	User       string
+	Tag        string
+	Text       string
+	From, To   time.Time
+	Limit      int
+}
+
+func (q *RunQuery) matches(record *RunRecord) bool {
+	inNamespace := false
+	for _, namespace := range q.Namespaces {
+		if record.Namespace == namespace {
+			inNamespace = true
+			break
+		}
+	}
+	if !inNamespace {
+		return false
+	}
+
+	switch {
+	case q.Stream != "" && record.Stream != q.Stream,
+		q.UID != "" && record.UID != q.UID,
+		q.Detector != "" && !strings.EqualFold(record.Detector, q.Detector),
+		q.User != "" && record.User != q.User,
+		!q.From.IsZero() && record.Start.Before(q.From),
+		!q.To.IsZero() && record.Start.After(q.To):
+		return false
+	}
+
+	if q.Tag != "" {
+		tagged := false
+		for _, tag := range record.Tags {
+			if strings.EqualFold(tag, q.Tag) {
+				tagged = true
+				break
+			}
+		}
+		if !tagged {
+			return false
+		}
+	}
+
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		fields := append([]string{record.Name, record.Notes}, record.Tags...)
+		for _, value := range record.Metadata {
+			fields = append(fields, value)
+		}
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Search returns the runs matching a query, most recent first
+func (c *RunCatalog) Search(q *RunQuery) ([]*RunRecord, error) {
+	var records []*RunRecord
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).ForEach(func(_, recordBytes []byte) error {
+			record := &RunRecord{}
+			if err := json.Unmarshal(recordBytes, record); err != nil {
+				return err
+			}
+			if q.matches(record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Start.After(records[j].Start)
+	})
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records, nil
+}
+
+// ParseTags splits a comma-separated list of tags
+func ParseTags(text string) []string {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SearchRuns answers a run search with the parameters stream, uid, detector,
+// user, tag, text, from, to (times as for history queries) and limit
+// (default 100), among the runs of namespaces
+func SearchRuns(namespaces []string, params map[string]string) ([]*RunRecord, error) {
+	if Catalog == nil {
+		return nil, errors.New("no run catalog")
+	}
+
+	q := &RunQuery{
+		Namespaces: namespaces,
+		Stream:     params["stream"],
+		UID:        params["uid"],
+		Detector:   params["detector"],
+		User:       params["user"],
+		Tag:        params["tag"],
+		Text:       params["text"],
+		Limit:      100,
+	}
+	if uid, err := strconv.ParseUint(strings.TrimPrefix(q.UID, "0x"), 16, 64); err == nil {
+		q.UID = fmt.Sprintf("%016x", uid)
+	}
+
+	now := time.Now()
+	var err error
+	if q.From, err = ParseHistoryTime(params["from"], now, time.Time{}); err != nil {
+		return nil, err
+	}
+	if q.To, err = ParseHistoryTime(params["to"], now, time.Time{}); err != nil {
+		return nil, err
+	}
+	if limit := params["limit"]; limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, fmt.Errorf("bad limit \"%v\"", limit)
+		}
+	}
+
+	return Catalog.Search(q)
+}
+
+// AnnotateRun replaces the notes and tags of the run at url with the notes
+// and comma-separated tags in params, if given
+func AnnotateRun(namespaces []string, url string, params map[string]string) (*RunRecord, error) {
+	if Catalog == nil {
+		return nil, errors.New("no run catalog")
+	}
+
+	record, err := Catalog.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || !(&RunQuery{Namespaces: namespaces}).matches(record) {
+		return nil, fmt.Errorf("no run \"%v\" in catalog", url)
+	}
+
+	err = Catalog.Update(url, func(record *RunRecord) {
+		if notes, ok := params["notes"]; ok {
+			record.Notes = notes
+		}
+		if tags, ok := params["tags"]; ok {
+			record.Tags = ParseTags(tags)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Catalog.Get(url)
+}
+
+// catalogRun adds a run started by a stream manager to the catalog
+func (m *StreamManager) catalogRun(url string, start time.Time, metadata map[string]string) {
+	if Catalog == nil {
+		return
+	}
+
+	record := &RunRecord{
+		URL:       url,
+		Name:      path.Base(url),
+		Namespace: m.Namespace,
+		Stream:    m.Name,
+		User:      metadata["User"],
+		Start:     start,
+		Notes:     metadata["Notes"],
+		Tags:      ParseTags(metadata["Tags"]),
+		Metadata:  make(map[string]string),
+	}
+	if record.Notes == "" {
+		record.Notes = metadata["Description"]
+	}
+	if m.Mapper != nil {
+		record.UID = fmt.Sprintf("%016x", m.Mapper.UID)
+		record.Detector = m.Mapper.DetName()
+	}
+	for key, value := range metadata {
+		record.Metadata[key] = value
+	}
+
+	if err := Catalog.Put(record); err != nil {
+		log.Println("unable to catalog run:", err)
+	}
+}
+
+// catalogRunStop records the end of a run in the catalog
+func catalogRunStop(url string, stop time.Time, events, dropped uint64) {
+	if Catalog == nil {
+		return
+	}
+
+	err := Catalog.Update(url, func(record *RunRecord) {
+		record.Stop = stop
+		record.Events = events
+		record.Dropped = dropped
+	})
+	if err != nil {
+		log.Println("unable to catalog run:", err)
+	}
+}
@@ -44,26 +44,8 @@ type ClientHandler struct {
 
 func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get list of namespaces that the user has access to
-	var namespaces []string
-
+	namespaces := live.Namespaces(r)
 	authSession, _ := live.Store.Get(r, "auth-session")
-	if appMetadata, ok := authSession.Values["app_metadata"]; ok {
-		if appMetadata, ok := appMetadata.(map[string]interface{}); ok {
-			if ns, ok := appMetadata["data namespaces"]; ok {
-				if ns, ok := ns.([]interface{}); ok {
-					for _, name := range ns {
-						if name, ok := name.(string); ok {
-							namespaces = append(namespaces, name)
-						}
-					}
-				}
-			}
-		}
-	}
-
-	if len(namespaces) == 0 {
-		namespaces = []string{"everyone"}
-	}
 
 	// Get nickname
 	nickname := "nobody"
@@ -154,6 +136,7 @@ func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			select {
 			case channel <- buf:
 			default:
+				live.ClientDroppedMessages.WithLabelValues("buffer full").Inc()
 			}
 		}
 	}()
@@ -209,6 +192,7 @@ func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				for len(msgBufs) > 0 {
 					select {
 					case <-msgBufs:
+						live.ClientDroppedMessages.WithLabelValues("preempted").Inc()
 					}
 				}
 			default:
@@ -219,6 +203,7 @@ func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 						npr += 1
 					} else {
 						buf = nil
+						live.ClientDroppedMessages.WithLabelValues("rate limit").Inc()
 					}
 				case <-ctx.Done():
 					return
@@ -251,7 +236,7 @@ func (h *ClientHandler) Execute(
 	case "list streams":
 		h.ListStreams(namespaces, cmd, resp)
 	case "stream cmd":
-		h.StreamCmd(namespaces, cmd)
+		h.StreamCmd(nickname, namespaces, cmd)
 	case "stream sub":
 		h.StreamSub(namespaces, cmd, sub, resp)
 	case "stream unsub":
@@ -262,6 +247,12 @@ func (h *ClientHandler) Execute(
 		h.GetRunMetadata(ctx, cmd, resp)
 	case "play run":
 		h.PlayRun(namespaces, ctx, cmd, resp)
+	case "query history":
+		h.QueryHistory(namespaces, cmd, resp)
+	case "search runs":
+		h.SearchRuns(namespaces, cmd, resp)
+	case "annotate run":
+		h.AnnotateRun(namespaces, cmd, resp)
 	default:
 		log.Printf("unknown command\n%v", cmd)
 	}
@@ -276,6 +267,76 @@ func (h *ClientHandler) GetNickname(nickname string, cmd *message.Cmd, resp chan
 	resp <- msg
 }
 
+func (h *ClientHandler) QueryHistory(namespaces []string, cmd *message.Cmd, resp chan<- *message.Msg) {
+	msg := &message.Msg{
+		Type:     "history",
+		Metadata: make(map[string]string),
+	}
+	msg.Metadata["stream"] = cmd.Metadata["stream"]
+	msg.Metadata["source"] = cmd.Metadata["source"]
+	if id, ok := cmd.Metadata["request id"]; ok {
+		msg.Metadata["request id"] = id
+	}
+
+	result, err := live.QueryHistory(namespaces, cmd.Metadata)
+	if err != nil {
+		msg.Metadata["error"] = err.Error()
+	} else if resultBytes, err := json.Marshal(result); err != nil {
+		msg.Metadata["error"] = err.Error()
+	} else {
+		msg.Metadata["result"] = string(resultBytes)
+	}
+	resp <- msg
+}
+
+func (h *ClientHandler) SearchRuns(namespaces []string, cmd *message.Cmd, resp chan<- *message.Msg) {
+	msg := &message.Msg{
+		Type:     "run search",
+		Metadata: make(map[string]string),
+	}
+	msg.Metadata["status"] = "failure"
+	if id, ok := cmd.Metadata["request id"]; ok {
+		msg.Metadata["request id"] = id
+	}
+	defer func() { resp <- msg }()
+
+	runs, err := live.SearchRuns(namespaces, cmd.Metadata)
+	if err != nil {
+		msg.Payload = []byte(err.Error())
+		return
+	}
+	msg.Payload, err = json.Marshal(runs)
+	if err != nil {
+		msg.Payload = []byte(err.Error())
+		return
+	}
+
+	msg.Metadata["status"] = "success"
+}
+
+func (h *ClientHandler) AnnotateRun(namespaces []string, cmd *message.Cmd, resp chan<- *message.Msg) {
+	msg := &message.Msg{
+		Type:     "run annotation",
+		Metadata: make(map[string]string),
+	}
+	msg.Metadata["status"] = "failure"
+	msg.Metadata["url"] = cmd.Metadata["url"]
+	defer func() { resp <- msg }()
+
+	run, err := live.AnnotateRun(namespaces, cmd.Metadata["url"], cmd.Metadata)
+	if err != nil {
+		msg.Payload = []byte(err.Error())
+		return
+	}
+	msg.Payload, err = json.Marshal(run)
+	if err != nil {
+		msg.Payload = []byte(err.Error())
+		return
+	}
+
+	msg.Metadata["status"] = "success"
+}
+
 func (h *ClientHandler) ListStreams(namespaces []string, cmd *message.Cmd, resp chan<- *message.Msg) {
 	for _, namespace := range namespaces {
 		for _, stream := range h.Redis.PubSubChannels(namespace + " stream cmd *").Val() {
@@ -289,9 +350,12 @@ func (h *ClientHandler) ListStreams(namespaces []string, cmd *message.Cmd, resp
 	}
 }
 
-func (h *ClientHandler) StreamCmd(namespaces []string, cmd *message.Cmd) {
+func (h *ClientHandler) StreamCmd(nickname string, namespaces []string, cmd *message.Cmd) {
 	stream := cmd.Metadata["stream"]
 	cmd.Command = cmd.Metadata["stream cmd"]
+	if cmd.Command == "start run" || cmd.Command == "set run trigger" {
+		cmd.Metadata["User"] = nickname
+	}
 	delete(cmd.Metadata, "stream")
 	delete(cmd.Metadata, "stream cmd")
 	cmdBytes, err := json.Marshal(cmd)
@@ -423,6 +487,21 @@ func (h *ClientHandler) PlayRun(namespaces []string, ctx context.Context, cmd *m
 			uidBytes = uuidBytes[:8]
 		}
 		uid := binary.BigEndian.Uint64(uidBytes)
+		data.DefaultDetmaps.Assign(uid, reader.Metadata)
+
+		// runs recorded before time indexes, or copied without them, are
+		// seeked by reading through
+		index, err := data.ReadTimeIndex(ctx, cmd.Metadata["url"]+data.TimeIndexSuffix, cmd.Metadata["credentials"])
+		if err != nil {
+			log.Println("playing run without time index:", err)
+			index = nil
+		}
+
+		player := &data.Player{Speed: 1, Loop: true}
+		open := func(entry int) (*proio.Reader, error) {
+			return data.GetReaderAt(ctx, cmd.Metadata["url"], cmd.Metadata["credentials"], index, entry)
+		}
+		go live.ControlPlayer(ctx, player, h.Addr, namespaces[len(namespaces)-1], streamName)
 
 		input := make(chan *proio.Event)
 		go func() {
@@ -431,24 +510,9 @@ func (h *ClientHandler) PlayRun(namespaces []string, ctx context.Context, cmd *m
 			log.Println("player reader for", thisUrl, "started")
 			defer log.Println("player reader for", thisUrl, "stopped")
 
-			for {
-				for event := range reader.ScanEvents(1000) {
-					select {
-					case input <- event:
-					case <-ctx.Done():
-						reader.Lock()
-						reader.Close()
-						reader.Unlock()
-						return
-					}
-				}
-				reader.Close()
-				reader, err = data.GetReader(ctx, cmd.Metadata["url"], cmd.Metadata["credentials"])
-				if err != nil {
-					msg.Payload = []byte(err.Error())
-					resp <- msg
-					return
-				}
+			if err := player.PlayRun(ctx, reader, index, open, input); err != nil {
+				msg.Payload = []byte(err.Error())
+				resp <- msg
 			}
 		}()
 
@@ -456,7 +520,11 @@ func (h *ClientHandler) PlayRun(namespaces []string, ctx context.Context, cmd *m
 		if ops != nil {
 			log.Println("player for", thisUrl, "started")
 			defer log.Println("player for", thisUrl, "stopped")
-			ops.Sink(input)
+			if err := ops.SinkContext(ctx, input); err != nil && err != context.Canceled {
+				log.Println("player for", thisUrl, "failed:", err)
+				msg.Payload = []byte(err.Error())
+				resp <- msg
+			}
 		}
 	}()
 }
@@ -0,0 +1,34 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rditech/rdi-live/live"
+)
+
+// HistoryHandler answers history queries given as URL query parameters
+// stream, source, from, to and resolution with a JSON live.HistoryResult
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	params := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[key] = values[0]
+		}
+	}
+
+	result, err := live.QueryHistory(live.Namespaces(r), params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
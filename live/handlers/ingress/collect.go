@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"html"
 	"log"
 	"strconv"
 	"time"
@@ -48,6 +49,7 @@ func (wsc *WsCollector) Collect(c *websocket.Conn) {
 		uidBytes = uuidBytes[:8]
 	}
 	uid := binary.BigEndian.Uint64(uidBytes)
+	data.DefaultDetmaps.Assign(uid, reader.Metadata)
 
 	namespace := wsc.DefaultNamespace
 	streamName := data.GetDetName(uid)
@@ -127,9 +129,38 @@ func (wsc *WsCollector) makeNewDataHandler(
 		defer reader.Close()
 		input := reader.ScanEvents(1000)
 
-		// publish input buffer size
+		// make operations array for the stream, instrumented if enabled
+		ops := live.BuildOpArray(namespace, streamName, redisClient, wsc.Addr, uid)
+		var stats *data.PipelineStats
+		if live.PipelineStats {
+			ops, stats = ops.Instrument()
+			for i, opStats := range stats.Ops {
+				opLabel := fmt.Sprintf("%d %v", i, opStats.Name)
+				observer := live.OpDuration.WithLabelValues(namespace, streamName, opLabel)
+				opStats.OnDuration = func(d time.Duration) {
+					observer.Observe(d.Seconds())
+				}
+				defer live.OpDuration.DeleteLabelValues(namespace, streamName, opLabel)
+			}
+		}
+
+		// publish input buffer size and pipeline stats
 		go func() {
-			for {
+			bufferDepth := live.IngressBufferDepth.WithLabelValues(namespace, streamName)
+			defer live.IngressBufferDepth.DeleteLabelValues(namespace, streamName)
+
+			for i := 0; ; i++ {
+				bufferDepth.Set(float64(len(input)))
+				if i%10 == 0 && stats != nil {
+					msg := &message.Msg{
+						Type:     "stream status",
+						Metadata: make(map[string]string),
+					}
+					msg.Metadata["stream"] = streamName
+					msg.Metadata["Pipeline Stats"] = "<pre>" + html.EscapeString(stats.String()) + "</pre>"
+					message.PublishJsonMsg(redisClient, namespace+" stream "+streamName, msg)
+				}
+
 				msg := &message.Msg{
 					Type:     "stream status",
 					Metadata: make(map[string]string),
@@ -154,12 +185,21 @@ func (wsc *WsCollector) makeNewDataHandler(
 			}
 		}()
 
-		// make operations array for the stream
-		ops := live.BuildOpArray(namespace, streamName, redisClient, wsc.Addr, uid)
-
-		// execute operations as a data sink
-		if ops != nil {
-			ops.Sink(input)
+		// execute operations as a data sink, discarding the rest of the
+		// stream if an op fails
+		if len(ops) > 0 {
+			if err := ops.SinkContext(context.Background(), input); err != nil {
+				log.Printf("stream %v failed: %v\n", streamName, err)
+				msg := &message.Msg{
+					Type:     "stream status",
+					Metadata: make(map[string]string),
+				}
+				msg.Metadata["stream"] = streamName
+				msg.Metadata["Error"] = err.Error()
+				message.PublishJsonMsg(redisClient, namespace+" stream "+streamName, msg)
+				for range input {
+				}
+			}
 		}
 
 		log.Println("quitting subscriber goroutine on channel", chanString)
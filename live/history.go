@@ -0,0 +1,324 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryRawSize, HistorySecondSize and HistoryMinuteSize are the number of
+// points kept by new history stores at raw, 1 s and 1 min resolution
+var (
+	HistoryRawSize    = 10000
+	HistorySecondSize = 6 * 3600
+	HistoryMinuteSize = 7 * 24 * 60
+)
+
+// HistorySources selects the time series sources whose history is kept,
+// including Advanced sources.  If nil, every source that is not Advanced is
+// kept.
+var HistorySources *regexp.Regexp
+
+// HistoryRetention is how long the history of a stream is kept after it
+// closes, so that it carries on if the stream reconnects
+var HistoryRetention = 24 * time.Hour
+
+// minEpochStamp is the least sample time in seconds taken to be a Unix time,
+// which sample times of streams with an Epoch anchor are.  Other sample times
+// count from the start of the stream.
+const minEpochStamp = 1e9
+
+// historyTime returns the time to keep a value with sample time stamp at,
+// which is the sample time if it is a Unix time, or else the arrival time now
+func historyTime(stamp float64, now time.Time) time.Time {
+	if stamp < minEpochStamp {
+		return now
+	}
+	return time.Unix(0, int64(stamp*1e9))
+}
+
+// HistoryResolutions lists the resolutions of a history store from finest to
+// coarsest
+var HistoryResolutions = []string{"raw", "1s", "1m"}
+
+// HistoryPoint summarizes the values of a source over a time bin starting at
+// T.  Raw points hold a single value.
+type HistoryPoint struct {
+	T              time.Time
+	Min, Max, Mean float64
+	N              int
+}
+
+// historyRing keeps the most recent points of a source at one resolution.
+// Values are accumulated into a bin of length period before being added.
+type historyRing struct {
+	period time.Duration
+	size   int
+	points []HistoryPoint
+	next   int
+	bin    HistoryPoint
+}
+
+func (r *historyRing) add(t time.Time, value float64) {
+	if r.period == 0 {
+		r.push(HistoryPoint{T: t, Min: value, Max: value, Mean: value, N: 1})
+		return
+	}
+
+	binStart := t.Truncate(r.period)
+	if r.bin.N > 0 && !binStart.Equal(r.bin.T) {
+		r.push(r.bin)
+		r.bin = HistoryPoint{}
+	}
+	if r.bin.N == 0 {
+		r.bin = HistoryPoint{T: binStart, Min: value, Max: value}
+	}
+	r.bin.Min = math.Min(r.bin.Min, value)
+	r.bin.Max = math.Max(r.bin.Max, value)
+	r.bin.Mean += (value - r.bin.Mean) / float64(r.bin.N+1)
+	r.bin.N++
+}
+
+func (r *historyRing) push(point HistoryPoint) {
+	if len(r.points) < r.size {
+		r.points = append(r.points, point)
+		return
+	}
+	r.points[r.next] = point
+	r.next = (r.next + 1) % r.size
+}
+
+// oldest returns the time of the oldest point kept
+func (r *historyRing) oldest() time.Time {
+	if len(r.points) == 0 {
+		return r.bin.T
+	}
+	return r.points[r.next%len(r.points)].T
+}
+
+// query returns the points in [from, to), including the bin in progress
+func (r *historyRing) query(from, to time.Time) []HistoryPoint {
+	var points []HistoryPoint
+	n := len(r.points)
+	for i := 0; i < n; i++ {
+		point := r.points[(r.next+i)%n]
+		if !point.T.Before(from) && point.T.Before(to) {
+			points = append(points, point)
+		}
+	}
+	if r.bin.N > 0 && !r.bin.T.Before(from) && r.bin.T.Before(to) {
+		points = append(points, r.bin)
+	}
+	return points
+}
+
+type sourceHistory struct {
+	rings []*historyRing
+}
+
+// HistoryStore keeps a time series of the values of each source of a stream
+// at raw, 1 s and 1 min resolution
+type HistoryStore struct {
+	sources map[string]*sourceHistory
+	mutex   sync.RWMutex
+
+	// closed is when the stream closed, or zero while it is open, and is
+	// guarded by historiesMutex
+	closed time.Time
+}
+
+func (h *HistoryStore) Add(source string, t time.Time, value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.sources == nil {
+		h.sources = make(map[string]*sourceHistory)
+	}
+	history := h.sources[source]
+	if history == nil {
+		history = &sourceHistory{
+			rings: []*historyRing{
+				{size: HistoryRawSize},
+				{size: HistorySecondSize, period: time.Second},
+				{size: HistoryMinuteSize, period: time.Minute},
+			},
+		}
+		h.sources[source] = history
+	}
+
+	for _, ring := range history.rings {
+		ring.add(t, value)
+	}
+}
+
+// Sources lists the sources with history
+func (h *HistoryStore) Sources() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var sources []string
+	for source := range h.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// Query returns the points of source in [from, to) at the given resolution
+// along with the resolution used.  If resolution is "" or "auto", the finest
+// resolution that still holds from is used.
+func (h *HistoryStore) Query(source string, from, to time.Time, resolution string) ([]HistoryPoint, string, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	history := h.sources[source]
+	if history == nil {
+		return nil, resolution, fmt.Errorf("no history for source \"%v\"", source)
+	}
+
+	ringNum := -1
+	switch resolution {
+	case "", "auto":
+		ringNum = len(history.rings) - 1
+		for i, ring := range history.rings {
+			if !ring.oldest().After(from) {
+				ringNum = i
+				break
+			}
+		}
+	default:
+		for i, name := range HistoryResolutions {
+			if name == resolution {
+				ringNum = i
+			}
+		}
+	}
+	if ringNum < 0 {
+		return nil, resolution, fmt.Errorf("unknown resolution \"%v\"", resolution)
+	}
+
+	return history.rings[ringNum].query(from, to), HistoryResolutions[ringNum], nil
+}
+
+// ParseHistoryTime parses RFC 3339 times, Unix times in seconds, durations
+// relative to now such as "-1h", and "now".  An empty string gives def.
+func ParseHistoryTime(text string, now, def time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	switch text {
+	case "":
+		return def, nil
+	case "now":
+		return now, nil
+	}
+	if d, err := time.ParseDuration(text); err == nil {
+		return now.Add(d), nil
+	}
+	if secs, err := strconv.ParseFloat(text, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, text)
+}
+
+// histories holds the history store of each stream by namespace and name,
+// until HistoryRetention after the stream closes
+var (
+	histories      = make(map[string]*HistoryStore)
+	historiesMutex sync.Mutex
+)
+
+// GetHistory returns the history store of a stream.  If create is set, the
+// stream is open, and the store is created if there is none.
+func GetHistory(namespace, stream string, create bool) *HistoryStore {
+	historiesMutex.Lock()
+	defer historiesMutex.Unlock()
+
+	expireHistories(time.Now())
+	key := namespace + " " + stream
+	history := histories[key]
+	if create {
+		if history == nil {
+			history = &HistoryStore{}
+			histories[key] = history
+		}
+		history.closed = time.Time{}
+	}
+	return history
+}
+
+// CloseHistory marks the history store of a stream closed, so that it is
+// dropped after HistoryRetention unless the stream opens again
+func CloseHistory(namespace, stream string) {
+	historiesMutex.Lock()
+	defer historiesMutex.Unlock()
+
+	if history := histories[namespace+" "+stream]; history != nil {
+		history.closed = time.Now()
+	}
+}
+
+// expireHistories drops the stores of streams closed for longer than
+// HistoryRetention.  It must be called with historiesMutex held.
+func expireHistories(now time.Time) {
+	for key, history := range histories {
+		if !history.closed.IsZero() && now.Sub(history.closed) > HistoryRetention {
+			delete(histories, key)
+		}
+	}
+}
+
+// HistoryResult is the response to a history query.  If no source is given,
+// Sources lists the sources with history.
+type HistoryResult struct {
+	Stream     string
+	Source     string
+	Resolution string
+	Sources    []string       `json:",omitempty"`
+	Points     []HistoryPoint `json:",omitempty"`
+}
+
+// QueryHistory answers a history query with the parameters stream, source,
+// from (default one hour ago), to (default now) and resolution, looking for
+// the stream in each of namespaces
+func QueryHistory(namespaces []string, params map[string]string) (*HistoryResult, error) {
+	stream := params["stream"]
+	var history *HistoryStore
+	for _, namespace := range namespaces {
+		if history = GetHistory(namespace, stream, false); history != nil {
+			break
+		}
+	}
+	if history == nil {
+		return nil, fmt.Errorf("no history for stream \"%v\"", stream)
+	}
+
+	result := &HistoryResult{
+		Stream: stream,
+		Source: params["source"],
+	}
+	if result.Source == "" {
+		result.Sources = history.Sources()
+		return result, nil
+	}
+
+	now := time.Now()
+	from, err := ParseHistoryTime(params["from"], now, now.Add(-time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	to, err := ParseHistoryTime(params["to"], now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Points, result.Resolution, err = history.Query(result.Source, from, to, params["resolution"])
+	return result, err
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics exported by rdi-live
+var (
+	StreamEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rdi_live",
+			Name:      "stream_events_total",
+			Help:      "Number of events handled by each stream.",
+		},
+		[]string{"namespace", "stream"},
+	)
+	StreamDroppedEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rdi_live",
+			Name:      "stream_dropped_events_total",
+			Help:      "Number of events dropped by each stream, by reason.",
+		},
+		[]string{"namespace", "stream", "reason"},
+	)
+	ClientDroppedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rdi_live",
+			Name:      "client_dropped_messages_total",
+			Help:      "Number of messages not sent to websocket clients, by reason.",
+		},
+		[]string{"reason"},
+	)
+	IngressBufferDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rdi_live",
+			Name:      "ingress_buffer_events",
+			Help:      "Number of events waiting in the ingress buffer of each stream.",
+		},
+		[]string{"namespace", "stream"},
+	)
+	RunSpooledEvents = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rdi_live",
+			Name:      "run_spooled_events",
+			Help:      "Number of events of the recorded run of each stream waiting on disk.",
+		},
+		[]string{"namespace", "stream"},
+	)
+	OpDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "rdi_live",
+			Name:      "op_duration_seconds",
+			Help:      "Time spent by each operation of a stream on an event.",
+			Buckets:   prometheus.ExponentialBuckets(1e-6, 4, 12),
+		},
+		[]string{"namespace", "stream", "op"},
+	)
+	SourceValue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rdi_live",
+			Name:      "source_value",
+			Help:      "Latest value of selected time series sources of each stream.",
+		},
+		[]string{"namespace", "stream", "source"},
+	)
+)
+
+// PipelineStats turns on the instrumentation of the ops of live streams,
+// which are then timed as OpDuration and summarized in the "Pipeline Stats"
+// stream status
+var PipelineStats bool
+
+// MetricSources selects the time series sources whose latest values are
+// exported as metrics
+var MetricSources = regexp.MustCompile(`^(Total Current|.* Temp( \d+)?)$`)
+
+func init() {
+	prometheus.MustRegister(
+		StreamEvents,
+		StreamDroppedEvents,
+		ClientDroppedMessages,
+		IngressBufferDepth,
+		RunSpooledEvents,
+		OpDuration,
+		SourceValue,
+	)
+}
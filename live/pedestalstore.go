@@ -0,0 +1,49 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rditech/rdi-live/data"
+
+	"github.com/go-redis/redis"
+)
+
+// PedestalStore is where current-mode streams keep their pedestals.  If nil,
+// pedestals are kept in Redis.
+var PedestalStore data.PedestalStore
+
+// RedisPedestalStore is a PedestalStore that keeps pedestals as JSON values
+// in Redis
+type RedisPedestalStore struct {
+	Client    *redis.Client
+	Namespace string
+}
+
+func (s *RedisPedestalStore) key(uid uint64) string {
+	return fmt.Sprintf("%v pedestals %016x", s.Namespace, uid)
+}
+
+func (s *RedisPedestalStore) SavePedestals(uid uint64, values [][]float64) error {
+	valueBytes, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(s.key(uid), valueBytes, 0).Err()
+}
+
+func (s *RedisPedestalStore) LoadPedestals(uid uint64) ([][]float64, error) {
+	valueBytes, err := s.Client.Get(s.key(uid)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values [][]float64
+	err = json.Unmarshal(valueBytes, &values)
+	return values, err
+}
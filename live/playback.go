@@ -0,0 +1,71 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/rditech/rdi-live/data"
+	"github.com/rditech/rdi-live/live/message"
+)
+
+// ControlPlayer executes the playback commands sent to a played stream until
+// ctx is done.  The commands are "pause", "resume", "step", "speed" (with
+// "speed" metadata), "loop" (with "loop" metadata of on or off), and "seek"
+// (with "time" metadata in seconds from the start of the run, or "event"
+// metadata with an event index).
+func ControlPlayer(ctx context.Context, player *data.Player, addr, namespace, stream string) {
+	cmds := message.ReceivePubSubCmds(ctx, addr, namespace+" stream cmd "+stream)
+	for cmd := range cmds {
+		if err := executePlaybackCmd(player, cmd); err != nil {
+			log.Printf("playback of stream %v: %v\n", stream, err)
+		}
+	}
+}
+
+func executePlaybackCmd(player *data.Player, cmd *message.Cmd) error {
+	switch cmd.Command {
+	case "pause":
+		player.Pause()
+	case "resume":
+		player.Resume()
+	case "step":
+		player.Step()
+	case "speed":
+		speed, err := strconv.ParseFloat(cmd.Metadata["speed"], 64)
+		if err != nil {
+			return fmt.Errorf("bad speed \"%v\"", cmd.Metadata["speed"])
+		}
+		return player.SetSpeed(speed)
+	case "loop":
+		switch strings.ToLower(cmd.Metadata["loop"]) {
+		case "on", "true":
+			player.SetLoop(true)
+		case "off", "false":
+			player.SetLoop(false)
+		default:
+			return fmt.Errorf("bad loop \"%v\"", cmd.Metadata["loop"])
+		}
+	case "seek":
+		if text, ok := cmd.Metadata["event"]; ok {
+			index, err := strconv.ParseUint(text, 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad event \"%v\"", text)
+			}
+			player.SeekEvent(index)
+			return nil
+		}
+		seconds, err := strconv.ParseFloat(cmd.Metadata["time"], 64)
+		if err != nil {
+			return fmt.Errorf("bad time \"%v\"", cmd.Metadata["time"])
+		}
+		return player.SeekTime(seconds)
+	}
+	return nil
+}
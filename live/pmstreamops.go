@@ -0,0 +1,166 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rditech/rdi-live/data"
+	"github.com/rditech/rdi-live/model/rdi/currentmode"
+
+	"github.com/go-redis/redis"
+	"github.com/proio-org/go-proio"
+)
+
+// PmSpectrumBins and PmSpectrumMax define the binning of the pulsed-mode
+// energy spectrum source
+var (
+	PmSpectrumBins = 256
+	PmSpectrumMax  = float32(4096)
+)
+
+// PmPipeline is the pipeline of live pulsed-mode streams, ahead of the stream
+// manager
+var PmPipeline = data.MustParsePipeline(`
+- assemble
+- merge
+- map
+- baseline
+- pulses
+`)
+
+func BuildPmOpArray(namespace, stream string, client *redis.Client, addr string, uid uint64) data.OpArray {
+	return buildPmOpArray(pipelineEnv(namespace, client, uid), namespace, stream, client, addr)
+}
+
+func buildPmOpArray(env *data.PipelineEnv, namespace, stream string, client *redis.Client, addr string) data.OpArray {
+	ops, err := PmPipeline.Build(env)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	streamManager := StreamManager{
+		Namespace:       namespace,
+		Name:            stream,
+		Redis:           client,
+		Addr:            addr,
+		Mapper:          env.Mapper,
+		Pedestals:       env.Pedestals,
+		GenerateSources: PmGenerateSources,
+		CleanupRunData: []data.EventProcessor{
+			data.KeepOnlyRawFrames,
+		},
+	}
+
+	return append(
+		ops,
+		data.StreamOp{
+			StreamProcessor: streamManager.Manage,
+			MaxEventBuf:     1000,
+		},
+	)
+}
+
+func PmGenerateSources(m *StreamManager, event *proio.Event) {
+	pulseRateInfo := m.GetSourceInfo("Pulse Rate")
+	spectrumInfo := m.GetSourceInfo("Energy Spectrum")
+	hitMapInfo := m.GetSourceInfo("Hit Map")
+	axisHitMapInfoCache := make(map[int]*SourceInfo)
+	axisHeightsInfoCache := make(map[int]*SourceInfo)
+
+	mappedFrameIds := event.TaggedEntries("Mapped")
+	for i, frameId := range event.TaggedEntries("Pulses") {
+		frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+		if !ok {
+			continue
+		}
+
+		tFrame := float64(frame.Timestamp) / (1 << 32)
+
+		// the frame duration comes from the waveform the pulses were found in
+		if i < len(mappedFrameIds) {
+			mappedFrame, ok := event.GetEntry(mappedFrameIds[i]).(*currentmode.Frame)
+			if ok && len(mappedFrame.Sample) > 1 {
+				nSamples := len(mappedFrame.Sample)
+				duration := float64(mappedFrame.Sample[nSamples-1].Timestamp) / (1 << 32)
+				duration *= float64(nSamples) / float64(nSamples-1)
+				if duration > 0 {
+					rate := float32(float64(len(frame.Sample)) / duration)
+					m.HandleSource(pulseRateInfo, Normal, &tFrame, &rate)
+				}
+			}
+		}
+
+		spectrum := make([]float32, PmSpectrumBins)
+		var axisHits [][]float32
+		for _, pulse := range frame.Sample {
+			var energy float32
+			centroids := make([]float32, len(pulse.Axis))
+			// the hit map needs a centroid on both of its axes
+			centroided := 0
+			for axis, axisPulse := range pulse.Axis {
+				energy += axisPulse.Sum
+
+				for len(axisHits) <= axis {
+					axisHits = append(axisHits, nil)
+				}
+				if len(axisHits[axis]) < len(axisPulse.FloatChannel) {
+					axisHits[axis] = append(
+						axisHits[axis],
+						make([]float32, len(axisPulse.FloatChannel)-len(axisHits[axis]))...,
+					)
+				}
+
+				axisHeights := axisHeightsInfoCache[axis]
+				if axisHeights == nil {
+					axisHeights = m.GetSourceInfo(fmt.Sprintf("Axis %d Pulse Heights", axis))
+					axisHeightsInfoCache[axis] = axisHeights
+				}
+
+				var weightSum float32
+				for axisChan, height := range axisPulse.FloatChannel {
+					if axisChan >= len(axisPulse.Channel) || axisPulse.Channel[axisChan] < 0 {
+						continue
+					}
+
+					axisHits[axis][axisChan]++
+					centroids[axis] += float32(axisChan) * height
+					weightSum += height
+
+					chanFloat := float32(axisChan)
+					m.HandleSource(axisHeights, Advanced, &chanFloat, &height, &one)
+				}
+				if weightSum > 0 {
+					centroids[axis] /= weightSum
+					if axis < 2 {
+						centroided++
+					}
+				}
+			}
+
+			bin := int(energy / PmSpectrumMax * float32(PmSpectrumBins))
+			if bin >= 0 && bin < PmSpectrumBins {
+				spectrum[bin]++
+			}
+
+			if centroided == 2 {
+				m.HandleSource(hitMapInfo, Normal, &centroids[0], &centroids[1], &one)
+			}
+		}
+
+		m.HandleSource(spectrumInfo, Normal, spectrum)
+
+		for axis, hits := range axisHits {
+			axisHitMap := axisHitMapInfoCache[axis]
+			if axisHitMap == nil {
+				axisHitMap = m.GetSourceInfo(fmt.Sprintf("Axis %d Hit Map", axis))
+				axisHitMapInfoCache[axis] = axisHitMap
+			}
+			m.HandleSource(axisHitMap, Normal, hits)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rditech/rdi-live/data"
+
+	"github.com/proio-org/go-proio"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RunSpoolDir is the directory where recorded runs are spooled while their
+// writers fall behind.  If empty, the system temporary directory is used.
+var RunSpoolDir string
+
+// RunBufferSize is the number of events of a recorded run buffered in memory
+// before the run is spooled to disk
+var RunBufferSize = 10000
+
+// DroppedEventsKey is the metadata key of recorded runs that holds the number
+// of events dropped so far, and is pushed after each gap in a run
+const DroppedEventsKey = "Dropped Events"
+
+// runRecorder writes the events of a run without holding back the stream.
+// Events wait in memory until the buffer is full, and are then handed to a
+// spooler that keeps them in spool files on disk until the writer catches up,
+// so that they are written in order.  Events are only dropped if the spooler
+// falls behind as well, or if they cannot be spooled or read back.
+type runRecorder struct {
+	writer  *data.IndexedWriter
+	cleanup func(*proio.Event)
+
+	buffer     chan *proio.Event
+	spoolQueue chan *proio.Event
+	spoolReady chan struct{}
+	spoolDone  chan struct{}
+	done       chan struct{}
+
+	written, spooled, dropped uint64
+	dropMetric                prometheus.Counter
+	spoolMetric               prometheus.Gauge
+
+	// mutex guards the spooling state, and is never held across disk I/O
+	// so that Record does not block
+	mutex    sync.Mutex
+	spooling bool
+	queued   int
+
+	// spoolMutex guards the spool files, and is taken before mutex
+	spoolMutex  sync.Mutex
+	spool       *proio.Writer
+	spoolFile   string
+	spoolEvents uint64
+	segments    []spoolSegment
+}
+
+// spoolSegment is a closed spool file and the number of events in it
+type spoolSegment struct {
+	filename string
+	events   uint64
+}
+
+func newRunRecorder(writer *data.IndexedWriter, cleanup func(*proio.Event), dropMetric prometheus.Counter, spoolMetric prometheus.Gauge) *runRecorder {
+	r := &runRecorder{
+		writer:      writer,
+		cleanup:     cleanup,
+		buffer:      make(chan *proio.Event, RunBufferSize),
+		spoolQueue:  make(chan *proio.Event, RunBufferSize),
+		spoolReady:  make(chan struct{}, 1),
+		spoolDone:   make(chan struct{}),
+		done:        make(chan struct{}),
+		dropMetric:  dropMetric,
+		spoolMetric: spoolMetric,
+	}
+	go r.runSpooler()
+	go r.run()
+	return r
+}
+
+// Record queues an event to be written.  It never blocks on the writer or
+// the spool files, and must not be called concurrently or after Stop.
+func (r *runRecorder) Record(event *proio.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.spooling {
+		select {
+		case r.buffer <- event:
+			return
+		default:
+			r.spooling = true
+		}
+	}
+
+	select {
+	case r.spoolQueue <- event:
+		r.queued++
+	default:
+		r.drop(1, "unable to spool run: spooler is behind")
+	}
+}
+
+// drop counts events as dropped, logging the first drop of the run
+func (r *runRecorder) drop(n uint64, msg string) {
+	if atomic.AddUint64(&r.dropped, n) == n {
+		log.Println(msg)
+	}
+	r.dropMetric.Add(float64(n))
+}
+
+// runSpooler writes the events handed over by Record to spool files until
+// Stop is called
+func (r *runRecorder) runSpooler() {
+	defer close(r.spoolDone)
+
+	for event := range r.spoolQueue {
+		r.spoolMutex.Lock()
+		err := r.spoolEvent(event)
+		if err == nil {
+			r.spoolEvents++
+		}
+		r.mutex.Lock()
+		r.queued--
+		r.mutex.Unlock()
+		r.spoolMutex.Unlock()
+
+		if err != nil {
+			r.drop(1, fmt.Sprint("unable to spool run: ", err))
+		} else {
+			atomic.AddUint64(&r.spooled, 1)
+			r.spoolMetric.Inc()
+		}
+
+		select {
+		case r.spoolReady <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// spoolEvent writes an event to the current spool file.  It must be called
+// with the spool mutex held.
+func (r *runRecorder) spoolEvent(event *proio.Event) error {
+	if r.spool == nil {
+		file, err := ioutil.TempFile(RunSpoolDir, "run-spool-*.proio")
+		if err != nil {
+			return err
+		}
+		r.spoolFile = file.Name()
+		file.Close()
+
+		if r.spool, err = proio.Create(r.spoolFile); err != nil {
+			os.Remove(r.spoolFile)
+			r.spool = nil
+			return err
+		}
+		r.spool.SetCompression(proio.LZ4)
+		r.spoolEvents = 0
+	}
+	return r.spool.Push(event)
+}
+
+// Stop ends the run once all queued events are written
+func (r *runRecorder) Stop() {
+	close(r.buffer)
+	close(r.spoolQueue)
+}
+
+// Done is closed once the run is written
+func (r *runRecorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Written, Spooled and Dropped return the number of events written so far,
+// waiting on disk, and dropped
+func (r *runRecorder) Written() uint64 {
+	return atomic.LoadUint64(&r.written)
+}
+
+func (r *runRecorder) Spooled() uint64 {
+	return atomic.LoadUint64(&r.spooled)
+}
+
+func (r *runRecorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+func (r *runRecorder) run() {
+	defer close(r.done)
+
+	var lastDropped uint64
+	write := func(event *proio.Event) {
+		if dropped := r.Dropped(); dropped != lastDropped {
+			lastDropped = dropped
+			r.writer.PushMetadata(DroppedEventsKey, []byte(fmt.Sprint(dropped)))
+		}
+
+		r.cleanup(event)
+		if err := r.writer.Push(event); err != nil {
+			log.Println("unable to write run:", err)
+		}
+		atomic.AddUint64(&r.written, 1)
+	}
+	finish := func() {
+		<-r.spoolDone
+		for r.writeSpool(write) {
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-r.buffer:
+			if !ok {
+				finish()
+				return
+			}
+			write(event)
+			continue
+		default:
+		}
+
+		// the buffer is empty, so spooled events are next
+		if r.writeSpool(write) {
+			continue
+		}
+
+		// wait for new events, in the buffer or the spool files
+		select {
+		case event, ok := <-r.buffer:
+			if !ok {
+				finish()
+				return
+			}
+			write(event)
+		case <-r.spoolReady:
+		}
+	}
+}
+
+// writeSpool writes the events in spool files, returning false if there were
+// none.  New events go to the buffer again once there are no spool files and
+// the spooler has caught up.
+func (r *runRecorder) writeSpool(write func(*proio.Event)) bool {
+	r.spoolMutex.Lock()
+	if r.spool != nil {
+		if err := r.spool.Close(); err != nil {
+			log.Println("unable to spool run:", err)
+		}
+		r.segments = append(r.segments, spoolSegment{r.spoolFile, r.spoolEvents})
+		r.spool = nil
+	}
+	segments := r.segments
+	r.segments = nil
+	r.mutex.Lock()
+	if len(segments) == 0 && r.queued == 0 {
+		r.spooling = false
+	}
+	r.mutex.Unlock()
+	r.spoolMutex.Unlock()
+
+	for _, segment := range segments {
+		var read uint64
+		reader, err := proio.Open(segment.filename)
+		if err != nil {
+			log.Println("unable to read run spool:", err)
+		} else {
+			for event := reader.Next(); event != nil && read < segment.events; event = reader.Next() {
+				write(event)
+				read++
+				atomic.AddUint64(&r.spooled, ^uint64(0))
+				r.spoolMetric.Dec()
+			}
+			if read < segment.events && reader.Err != nil {
+				log.Println("unable to read run spool:", reader.Err)
+			}
+			reader.Close()
+		}
+		os.Remove(segment.filename)
+
+		// events that cannot be read back are dropped
+		if lost := segment.events - read; lost > 0 {
+			atomic.AddUint64(&r.spooled, ^(lost - 1))
+			r.spoolMetric.Sub(float64(lost))
+			r.drop(lost, "unable to read run spool")
+		}
+	}
+	return len(segments) > 0
+}
@@ -0,0 +1,264 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package live
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/rditech/rdi-live/data"
+	"github.com/rditech/rdi-live/live/message"
+
+	"github.com/proio-org/go-proio"
+)
+
+// runSettings are the parameters of "start run" and "set run trigger" that
+// are not written to the run as metadata.  A run stops after Duration or
+// after Events events, and rolls over to a new file every RolloverTime or
+// every RolloverSize bytes.  Zero values mean no limit.
+type runSettings struct {
+	URL          string
+	Credentials  string
+	Duration     time.Duration
+	Events       uint64
+	RolloverTime time.Duration
+	RolloverSize int64
+	Metadata     map[string]string
+
+	start, partStart time.Time
+	part             int
+	events           uint64
+	size             *data.CountingWriter
+}
+
+var runSettingKeys = map[string]bool{
+	"url":           true,
+	"credentials":   true,
+	"duration":      true,
+	"events":        true,
+	"rollover time": true,
+	"rollover size": true,
+	"rule":          true,
+	"hysteresis":    true,
+	"pretrigger":    true,
+}
+
+// parseRunSettings parses the parameters url, credentials, duration, events,
+// rollover time and rollover size (in MB), taking the remaining parameters as
+// run metadata
+func parseRunSettings(params map[string]string) (*runSettings, error) {
+	run := &runSettings{
+		URL:         params["url"],
+		Credentials: params["credentials"],
+		Metadata:    make(map[string]string),
+	}
+	if run.URL == "" {
+		return nil, errors.New("run needs a url")
+	}
+
+	var err error
+	if text := params["duration"]; text != "" {
+		if run.Duration, err = time.ParseDuration(text); err != nil {
+			return nil, fmt.Errorf("bad run duration \"%v\"", text)
+		}
+	}
+	if text := params["events"]; text != "" {
+		if run.Events, err = strconv.ParseUint(text, 10, 64); err != nil {
+			return nil, fmt.Errorf("bad run event count \"%v\"", text)
+		}
+	}
+	if text := params["rollover time"]; text != "" {
+		if run.RolloverTime, err = time.ParseDuration(text); err != nil {
+			return nil, fmt.Errorf("bad run rollover time \"%v\"", text)
+		}
+	}
+	if text := params["rollover size"]; text != "" {
+		size, err := strconv.ParseFloat(text, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("bad run rollover size \"%v\"", text)
+		}
+		run.RolloverSize = int64(size * 1e6)
+	}
+
+	for key, value := range params {
+		if !runSettingKeys[key] {
+			run.Metadata[key] = value
+		}
+	}
+	return run, nil
+}
+
+// record passes an event to the run, or to the pre-trigger buffer if no run
+// is being recorded
+func (m *StreamManager) record(event *proio.Event, now time.Time) {
+	if m.recorder == nil {
+		if m.runTrigger != nil {
+			m.runTrigger.buffer(event, now)
+		}
+		return
+	}
+
+	m.recorder.Record(event)
+	m.run.events++
+	m.checkRun(now)
+}
+
+// checkRun stops or rolls over the run once it reaches its limits
+func (m *StreamManager) checkRun(now time.Time) {
+	run := m.run
+	if run == nil {
+		return
+	}
+
+	switch {
+	case run.Duration > 0 && now.Sub(run.start) >= run.Duration,
+		run.Events > 0 && run.events >= run.Events:
+		log.Printf("run reached its limit after %v and %v events",
+			now.Sub(run.start).Truncate(time.Second), run.events)
+		m.stopRun(&message.Cmd{})
+		if m.runTrigger != nil {
+			m.pubRunTrigger()
+		}
+	case run.RolloverTime > 0 && now.Sub(run.partStart) >= run.RolloverTime,
+		run.RolloverSize > 0 && run.size.Count() >= run.RolloverSize:
+		run.part++
+		m.openRunPart()
+	}
+}
+
+type triggeredEvent struct {
+	event *proio.Event
+	time  time.Time
+}
+
+// runTrigger starts a run when its rule activates, and stops the run when the
+// rule clears.  Events from the PreTrigger before the run starts are written
+// at the start of the run.
+type runTrigger struct {
+	Params     map[string]string
+	PreTrigger time.Duration
+
+	rules   *AlarmEngine
+	run     *runSettings
+	pending []triggeredEvent
+}
+
+// buffer keeps an event for the next run, dropping events older than the
+// pre-trigger time
+func (t *runTrigger) buffer(event *proio.Event, now time.Time) {
+	if t.PreTrigger <= 0 {
+		return
+	}
+
+	t.pending = append(t.pending, triggeredEvent{event, now})
+	i := 0
+	for i < len(t.pending) && now.Sub(t.pending[i].time) > t.PreTrigger {
+		t.pending[i] = triggeredEvent{}
+		i++
+	}
+	t.pending = t.pending[i:]
+}
+
+func (m *StreamManager) setRunTrigger(cmd *message.Cmd) {
+	if _, err := parseRunSettings(cmd.Metadata); err != nil {
+		log.Println(err)
+		m.PubStatus("Run Trigger", err.Error())
+		return
+	}
+	rule, err := ParseAlarmRule(cmd.Metadata["rule"])
+	if err != nil {
+		log.Println(err)
+		m.PubStatus("Run Trigger", err.Error())
+		return
+	}
+	rule.Name = "run trigger"
+	if hysteresis, err := strconv.ParseFloat(cmd.Metadata["hysteresis"], 64); err == nil {
+		rule.Hysteresis = hysteresis
+	}
+
+	trigger := &runTrigger{Params: make(map[string]string)}
+	if text := cmd.Metadata["pretrigger"]; text != "" {
+		if trigger.PreTrigger, err = time.ParseDuration(text); err != nil {
+			log.Printf("bad pre-trigger time \"%v\"\n", text)
+			m.PubStatus("Run Trigger", fmt.Sprintf("bad pre-trigger time \"%v\"", text))
+			return
+		}
+	}
+	for key, value := range cmd.Metadata {
+		trigger.Params[key] = value
+	}
+	trigger.Params["Trigger"] = rule.String()
+
+	m.rmRunTrigger(cmd)
+	trigger.rules = &AlarmEngine{
+		Stream: m.Name,
+		Publish: func(msg *message.Msg) {
+			if msg.Metadata["state"] == "active" {
+				m.startTriggeredRun(trigger)
+			} else {
+				m.stopTriggeredRun(trigger)
+			}
+		},
+	}
+	trigger.rules.SetRule(rule)
+	m.runTrigger = trigger
+	m.pubRunTrigger()
+}
+
+func (m *StreamManager) rmRunTrigger(cmd *message.Cmd) {
+	if m.runTrigger == nil {
+		return
+	}
+	m.runTrigger.rules.RemoveRule("run trigger")
+	m.runTrigger = nil
+	m.pubRunTrigger()
+}
+
+func (m *StreamManager) startTriggeredRun(trigger *runTrigger) {
+	if m.run != nil {
+		log.Println("run trigger activated while a run is being recorded")
+		return
+	}
+
+	params := make(map[string]string)
+	for key, value := range trigger.Params {
+		params[key] = value
+	}
+	m.startRun(&message.Cmd{Command: "start run", Metadata: params})
+	if m.recorder == nil {
+		return
+	}
+
+	trigger.run = m.run
+	for _, pending := range trigger.pending {
+		m.recorder.Record(pending.event)
+		m.run.events++
+	}
+	trigger.pending = nil
+	m.pubRunTrigger()
+}
+
+func (m *StreamManager) stopTriggeredRun(trigger *runTrigger) {
+	if m.run != nil && m.run == trigger.run {
+		m.stopRun(&message.Cmd{})
+	}
+	trigger.run = nil
+	m.pubRunTrigger()
+}
+
+func (m *StreamManager) pubRunTrigger() {
+	trigger := m.runTrigger
+	switch {
+	case trigger == nil:
+		m.PubStatus("Run Trigger", "none")
+	case trigger.run != nil && trigger.run == m.run:
+		m.PubStatus("Run Trigger", trigger.Params["Trigger"]+" (RECORDING)")
+	default:
+		m.PubStatus("Run Trigger", trigger.Params["Trigger"]+" (armed)")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package shows
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rditech/rdi-live/live/message"
+
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette/moreland"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// ImageSample is an N by M image with pixel values stored with the x index
+// running fastest.  Pixel centers are at X0 + i*Pitch and Y0 + j*Pitch.
+type ImageSample struct {
+	N, M   int
+	X0, Y0 float64
+	Pitch  float64
+	Pixels []float32
+}
+
+// imageGrid implements plotter.GridXYZ for a smoothed image
+type imageGrid struct {
+	ImageSample
+	values []float64
+}
+
+func (g *imageGrid) Dims() (c, r int)   { return g.N, g.M }
+func (g *imageGrid) Z(c, r int) float64 { return g.values[r*g.N+c] }
+func (g *imageGrid) X(c int) float64    { return g.X0 + float64(c)*g.Pitch }
+func (g *imageGrid) Y(r int) float64    { return g.Y0 + float64(r)*g.Pitch }
+
+type Image struct {
+	Alpha            float64
+	DisableAutorange bool
+	FramePeriod      time.Duration
+	Min, Max         float64
+
+	grid *imageGrid
+
+	frame        *message.Msg
+	frameCount   uint64
+	frameExpired bool
+
+	sync.RWMutex
+}
+
+func (s *Image) Frame() (*message.Msg, uint64) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.frame, s.frameCount
+}
+
+func (s *Image) Execute(cmd *message.Cmd) error {
+	s.Lock()
+	defer s.Unlock()
+
+	switch cmd.Command {
+	case "set params":
+		for param, value := range cmd.Metadata {
+			switch param {
+			case "reset":
+				s.grid = nil
+			case "autorange":
+				if strings.ToLower(value) == "false" {
+					s.DisableAutorange = true
+				} else {
+					s.DisableAutorange = false
+				}
+			case "min":
+				min, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					s.Min = min
+				}
+			case "max":
+				max, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					s.Max = max
+				}
+			case "alpha":
+				alpha, err := strconv.ParseFloat(value, 64)
+				if err == nil && alpha > 0 && alpha <= 1 {
+					s.Alpha = alpha
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Image) AddSample(vi interface{}) {
+	v, ok := vi.(*ImageSample)
+	if !ok || v.N*v.M != len(v.Pixels) {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Alpha <= 0 {
+		s.Alpha = 1
+	}
+
+	if s.grid == nil || s.grid.N != v.N || s.grid.M != v.M {
+		s.grid = &imageGrid{
+			ImageSample: *v,
+			values:      make([]float64, len(v.Pixels)),
+		}
+		if s.grid.Pitch == 0 {
+			s.grid.Pitch = 1
+		}
+		for i, val := range v.Pixels {
+			s.grid.values[i] = float64(val)
+		}
+	} else {
+		for i, val := range v.Pixels {
+			s.grid.values[i] *= 1 - s.Alpha
+			s.grid.values[i] += s.Alpha * float64(val)
+		}
+	}
+
+	if s.frameExpired {
+		s.frameExpired = false
+		go s.updateFrame(true)
+	}
+}
+
+func (s *Image) updateFrame(doLock bool) {
+	if doLock {
+		s.Lock()
+		defer s.Unlock()
+	}
+
+	p, _ := plot.New()
+	p.BackgroundColor = color.Transparent
+	hp := &hplot.Plot{
+		Plot:  p,
+		Style: hplot.DefaultStyle,
+	}
+	if s.grid != nil {
+		colorMap := moreland.Kindlmann()
+		h := plotter.NewHeatMap(s.grid, colorMap.Palette(1000))
+		if s.DisableAutorange {
+			h.Min = s.Min
+			h.Max = s.Max
+		} else {
+			s.Min = h.Min
+			s.Max = h.Max
+		}
+		if h.Max <= h.Min || math.IsInf(h.Min, 0) || math.IsInf(h.Max, 0) {
+			h.Max = h.Min + 1
+		}
+		h.Underflow = h.Palette.Colors()[0]
+		h.Overflow = h.Palette.Colors()[len(h.Palette.Colors())-1]
+		hp.Add(h)
+	}
+
+	img := vgimg.New(4*vg.Inch, 2.5*vg.Inch)
+	c := draw.New(img)
+	p.Draw(c)
+	buf := &bytes.Buffer{}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	encoder.Encode(buf, img.Image())
+
+	s.frame = &message.Msg{
+		Metadata: make(map[string]string),
+		Payload:  buf.Bytes(),
+	}
+	s.frame.Metadata["show type"] = "Image"
+	s.frame.Metadata["is png"] = "true"
+	s.frame.Metadata["reset"] = ""
+	s.frame.Metadata["alpha"] = strconv.FormatFloat(s.Alpha, 'g', 8, 64)
+	s.frame.Metadata["autorange"] = strconv.FormatBool(!s.DisableAutorange)
+	s.frame.Metadata["min"] = strconv.FormatFloat(s.Min, 'g', 4, 64)
+	s.frame.Metadata["max"] = strconv.FormatFloat(s.Max, 'g', 4, 64)
+
+	s.frameCount++
+
+	go func() {
+		time.Sleep(s.FramePeriod)
+		s.Lock()
+		defer s.Unlock()
+		s.frameExpired = true
+	}()
+}
+
+func (s *Image) UpdateFrame() {
+	s.updateFrame(true)
+}
+
+func (s *Image) UpdateFrameCount() {
+	s.Lock()
+	defer s.Unlock()
+	s.frameCount++
+}
+
+func (s *Image) InitPlot() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Alpha = 1
+}
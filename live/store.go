@@ -6,6 +6,7 @@ package live
 
 import (
 	"encoding/gob"
+	"net/http"
 	"os"
 
 	"github.com/gorilla/sessions"
@@ -16,3 +17,29 @@ var Store = sessions.NewCookieStore([]byte(os.Getenv("SESSION_KEY")))
 func init() {
 	gob.Register(map[string]interface{}{})
 }
+
+// Namespaces returns the list of namespaces that the user of a request has
+// access to
+func Namespaces(r *http.Request) []string {
+	var namespaces []string
+
+	authSession, _ := Store.Get(r, "auth-session")
+	if appMetadata, ok := authSession.Values["app_metadata"]; ok {
+		if appMetadata, ok := appMetadata.(map[string]interface{}); ok {
+			if ns, ok := appMetadata["data namespaces"]; ok {
+				if ns, ok := ns.([]interface{}); ok {
+					for _, name := range ns {
+						if name, ok := name.(string); ok {
+							namespaces = append(namespaces, name)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(namespaces) == 0 {
+		namespaces = []string{"everyone"}
+	}
+	return namespaces
+}
@@ -7,6 +7,7 @@ package live
 import (
 	"context"
 	"fmt"
+	"html"
 	"log"
 	"net/url"
 	"strconv"
@@ -16,12 +17,14 @@ import (
 	"github.com/rditech/rdi-live/data"
 	"github.com/rditech/rdi-live/live/message"
 	"github.com/rditech/rdi-live/live/shows"
+	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
 	"github.com/rditech/rdi-live/model/rdi/slowdata"
 
 	"github.com/go-redis/redis"
 	"github.com/golang/protobuf/proto"
 	"github.com/google/uuid"
 	"github.com/proio-org/go-proio"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type ShowInfo struct {
@@ -37,6 +40,7 @@ const (
 	RollXY
 	XY
 	Hist2D
+	Image
 )
 
 type SourceType int
@@ -51,6 +55,12 @@ type SourceInfo struct {
 	ShowIds     []uuid.UUID
 	CompatShows []ShowType
 	Type        SourceType
+
+	metric        prometheus.Gauge
+	metricChecked bool
+
+	history        bool
+	historyChecked bool
 }
 
 type StreamManager struct {
@@ -58,6 +68,10 @@ type StreamManager struct {
 	Name            string
 	Redis           *redis.Client
 	Addr            string
+	Mapper          *data.Mapper
+	Pedestals       *data.Pedestals
+	Alarms          *AlarmEngine
+	History         *HistoryStore
 	InitShows       func(*StreamManager)
 	GenerateSources func(*StreamManager, *proio.Event)
 	CleanupRunData  []data.EventProcessor
@@ -68,11 +82,16 @@ type StreamManager struct {
 	showInfo   map[uuid.UUID]ShowInfo
 	sourceInfo map[string]*SourceInfo
 
-	runChannel  chan *proio.Event
+	recorder    *runRecorder
+	run         *runSettings
 	runFilename string
+	runTrigger  *runTrigger
 
 	doPubDesc                bool
 	lastTempMeta, lastHvMeta []byte
+	lastPedStatus            string
+	lastUnmatched, lastLost  string
+	lastPosition             string
 	startTime                time.Time
 }
 
@@ -81,6 +100,7 @@ func (m *StreamManager) Manage(input <-chan *proio.Event, output chan<- *proio.E
 	m.ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
 	defer m.rmAllShows(&message.Cmd{})
+	defer m.stopRun(&message.Cmd{})
 
 	if m.sourceInfo == nil {
 		m.sourceInfo = make(map[string]*SourceInfo)
@@ -89,6 +109,21 @@ func (m *StreamManager) Manage(input <-chan *proio.Event, output chan<- *proio.E
 		m.showInfo = make(map[uuid.UUID]ShowInfo)
 	}
 
+	if m.History == nil {
+		m.History = GetHistory(m.Namespace, m.Name, true)
+	}
+
+	if m.Alarms == nil {
+		m.Alarms = &AlarmEngine{
+			LogFile: AlarmLogFile,
+			Webhook: AlarmWebhook,
+		}
+	}
+	m.Alarms.Stream = m.Name
+	m.Alarms.Publish = func(msg *message.Msg) {
+		message.PublishJsonMsg(m.Redis, m.Namespace+" stream "+m.Name, msg)
+	}
+
 	if m.InitShows != nil {
 		m.InitShows(m)
 	}
@@ -97,6 +132,17 @@ func (m *StreamManager) Manage(input <-chan *proio.Event, output chan<- *proio.E
 	m.announce()
 	defer m.closeStream()
 
+	events := StreamEvents.WithLabelValues(m.Namespace, m.Name)
+
+	// with PipelineStats, the stream manager is timed with the other ops
+	var duration prometheus.Observer
+	if !PipelineStats {
+		duration = OpDuration.WithLabelValues(m.Namespace, m.Name, "stream manager")
+	}
+
+	runTicker := time.NewTicker(time.Second)
+	defer runTicker.Stop()
+
 	m.startTime = time.Now()
 	for {
 		select {
@@ -105,15 +151,15 @@ func (m *StreamManager) Manage(input <-chan *proio.Event, output chan<- *proio.E
 				return
 			}
 
+			start := time.Now()
 			m.handleMetadata(event)
 			m.GenerateSources(m, event)
-
-			if m.runChannel != nil {
-				select {
-				case m.runChannel <- event:
-				default:
-				}
+			if duration != nil {
+				duration.Observe(time.Since(start).Seconds())
 			}
+			events.Inc()
+
+			m.record(event, start)
 			output <- event
 		case cmd := <-cmds:
 			if cmd.Command == "kill" {
@@ -121,6 +167,8 @@ func (m *StreamManager) Manage(input <-chan *proio.Event, output chan<- *proio.E
 			}
 
 			m.execute(cmd)
+		case now := <-runTicker.C:
+			m.checkRun(now)
 		}
 	}
 }
@@ -143,6 +191,45 @@ func (m *StreamManager) HandleSource(sourceInfo *SourceInfo, t SourceType, value
 		sourceInfo.Type = t
 	}
 
+	// keep the history of time series sources and check them for alarms
+	if len(value) == 2 {
+		stamp, isSeries := value[0].(*float64)
+		val, ok := value[1].(*float32)
+		if isSeries && ok {
+			now := time.Now()
+			if !sourceInfo.historyChecked {
+				sourceInfo.historyChecked = true
+				if HistorySources != nil {
+					sourceInfo.history = HistorySources.MatchString(sourceInfo.Name)
+				} else {
+					sourceInfo.history = t == Normal
+				}
+			}
+			if m.History != nil && sourceInfo.history {
+				// history follows the time of the samples where they are
+				// anchored to the epoch, so that buffered and played back
+				// data keep their spacing
+				m.History.Add(sourceInfo.Name, historyTime(*stamp, now), float64(*val))
+			}
+			if m.Alarms != nil && m.Alarms.Watches(sourceInfo.Name) {
+				m.Alarms.Update(sourceInfo.Name, float64(*val), now)
+			}
+			if m.runTrigger != nil && m.runTrigger.rules.Watches(sourceInfo.Name) {
+				m.runTrigger.rules.Update(sourceInfo.Name, float64(*val), now)
+			}
+
+			if !sourceInfo.metricChecked {
+				sourceInfo.metricChecked = true
+				if MetricSources != nil && MetricSources.MatchString(sourceInfo.Name) {
+					sourceInfo.metric = SourceValue.WithLabelValues(m.Namespace, m.Name, sourceInfo.Name)
+				}
+			}
+			if sourceInfo.metric != nil {
+				sourceInfo.metric.Set(float64(*val))
+			}
+		}
+	}
+
 	if len(value) == 3 {
 		val0f, ok0f := value[0].(*float32)
 		val1f, ok1f := value[1].(*float32)
@@ -160,14 +247,41 @@ func (m *StreamManager) HandleSource(sourceInfo *SourceInfo, t SourceType, value
 				switch show.(type) {
 				case *shows.Hist2D:
 					showInfo.SampleChannel <- &shows.Hist2DSample{
-						float64(*val0f),
-						float64(*val1f),
-						float64(*val2f),
+						X:      float64(*val0f),
+						Y:      float64(*val1f),
+						Weight: float64(*val2f),
 					}
 				}
 			}
 		}
 	} else if len(value) == 2 {
+		pixels, okPixels := value[0].([]float32)
+		geom, okGeom := value[1].(*detmapmodel.DetectorConfig_ImageConfig_Geometry)
+		if okPixels && okGeom {
+			if sourceInfo.CompatShows == nil {
+				sourceInfo.CompatShows = []ShowType{Image}
+				m.listSource(sourceInfo.Name, sourceInfo)
+			}
+
+			for _, showId := range sourceInfo.ShowIds {
+				showInfo := m.showInfo[showId]
+				show := showInfo.Show
+
+				switch show.(type) {
+				case *shows.Image:
+					showInfo.SampleChannel <- &shows.ImageSample{
+						N:      int(geom.N),
+						M:      int(geom.M),
+						X0:     float64(geom.XOffset),
+						Y0:     float64(geom.YOffset),
+						Pitch:  float64(geom.Pitch),
+						Pixels: pixels,
+					}
+				}
+			}
+			return
+		}
+
 		val0d, ok0d := value[0].(*float64)
 		val0f, ok0f := value[0].(*float32)
 		val1f, ok1f := value[1].(*float32)
@@ -184,9 +298,9 @@ func (m *StreamManager) HandleSource(sourceInfo *SourceInfo, t SourceType, value
 				switch show.(type) {
 				case *shows.RollXY:
 					showInfo.SampleChannel <- &shows.RollXYSample{
-						*val0d,
-						float64(*val1f),
-						sourceInfo.Name,
+						X:        *val0d,
+						Y:        float64(*val1f),
+						LineName: sourceInfo.Name,
 					}
 				}
 			}
@@ -203,9 +317,9 @@ func (m *StreamManager) HandleSource(sourceInfo *SourceInfo, t SourceType, value
 				switch show.(type) {
 				case *shows.XY:
 					showInfo.SampleChannel <- &shows.XYSample{
-						float64(*val0f),
-						float64(*val1f),
-						sourceInfo.Name,
+						X:        float64(*val0f),
+						Y:        float64(*val1f),
+						LineName: sourceInfo.Name,
 					}
 				}
 			}
@@ -226,8 +340,8 @@ func (m *StreamManager) HandleSource(sourceInfo *SourceInfo, t SourceType, value
 			switch show.(type) {
 			case *shows.Projection:
 				showInfo.SampleChannel <- &shows.ProjectionSample{
-					valArray,
-					sourceInfo.Name,
+					Y:        valArray,
+					LineName: sourceInfo.Name,
 				}
 			}
 		}
@@ -246,6 +360,23 @@ func (m *StreamManager) announce() {
 }
 
 func (m *StreamManager) closeStream() {
+	for _, sourceInfo := range m.sourceInfo {
+		if sourceInfo.metric != nil {
+			SourceValue.DeleteLabelValues(m.Namespace, m.Name, sourceInfo.Name)
+			sourceInfo.metric = nil
+			sourceInfo.metricChecked = false
+		}
+	}
+	StreamEvents.DeleteLabelValues(m.Namespace, m.Name)
+	OpDuration.DeleteLabelValues(m.Namespace, m.Name, "stream manager")
+	StreamDroppedEvents.DeleteLabelValues(m.Namespace, m.Name, "run")
+	RunSpooledEvents.DeleteLabelValues(m.Namespace, m.Name)
+	CloseHistory(m.Namespace, m.Name)
+	m.History = nil
+	if m.Alarms != nil {
+		m.Alarms.Close()
+	}
+
 	msg := &message.Msg{
 		Metadata: make(map[string]string),
 	}
@@ -278,10 +409,28 @@ func (m *StreamManager) execute(cmd *message.Cmd) {
 		m.startRun(cmd)
 	case "stop run":
 		m.stopRun(cmd)
+	case "set run trigger":
+		m.setRunTrigger(cmd)
+	case "rm run trigger":
+		m.rmRunTrigger(cmd)
 	case "pub run meta":
 		m.pubRunMeta(cmd)
 	case "pub desc":
 		m.pubDesc(cmd)
+	case "take pedestals":
+		m.takePedestals(cmd)
+	case "freeze pedestals":
+		m.freezePedestals(cmd)
+	case "save pedestals":
+		m.savePedestals(cmd)
+	case "load pedestals":
+		m.loadPedestals(cmd)
+	case "set alarm":
+		m.setAlarm(cmd)
+	case "rm alarm":
+		m.rmAlarm(cmd)
+	case "list alarms":
+		m.listAlarms(cmd)
 	}
 }
 
@@ -318,6 +467,10 @@ func (m *StreamManager) newShow(cmd *message.Cmd) {
 		plot := &shows.Projection{FramePeriod: period}
 		plot.InitPlot()
 		show = plot
+	case "Image":
+		plot := &shows.Image{FramePeriod: period}
+		plot.InitPlot()
+		show = plot
 	default:
 		return
 	}
@@ -511,6 +664,8 @@ func (m *StreamManager) listSource(source string, sourceInfo *SourceInfo) {
 			compatShowList += "Roll XY"
 		case Projection:
 			compatShowList += "Projection"
+		case Image:
+			compatShowList += "Image"
 		}
 		if i < len(sourceInfo.CompatShows)-1 {
 			compatShowList += ", "
@@ -533,29 +688,64 @@ func (m *StreamManager) listSource(source string, sourceInfo *SourceInfo) {
 var RunDateFormat = "2006_Jan2_15_04_05_UTC"
 
 func (m *StreamManager) startRun(cmd *message.Cmd) {
-	urlString := cmd.Metadata["url"] + "/" + time.Now().UTC().Format(RunDateFormat) + ".proio"
-	writer, err := data.GetWriter(m.ctx, urlString, cmd.Metadata["credentials"])
+	run, err := parseRunSettings(cmd.Metadata)
 	if err != nil {
 		log.Println(err)
+		m.PubStatus("Run", err.Error())
 		return
 	}
 
-	thisUrl, err := url.Parse(urlString)
-	m.runFilename = strings.TrimLeft(thisUrl.Path, "/")
+	m.stopRun(cmd)
+	run.start = time.Now()
+	m.run = run
+	m.openRunPart()
+}
 
-	if m.runChannel != nil {
-		m.runChannel <- nil
+// openRunPart starts writing the run to a new file, which is the first part
+// of the run or the next one after a rollover
+func (m *StreamManager) openRunPart() {
+	run := m.run
+	run.partStart = time.Now()
+
+	name := run.start.UTC().Format(RunDateFormat)
+	metadata := make(map[string]string)
+	for key, value := range run.Metadata {
+		metadata[key] = value
 	}
-	m.runChannel = make(chan *proio.Event, 10000)
+	if run.RolloverSize > 0 || run.RolloverTime > 0 {
+		if run.part > 0 {
+			name += fmt.Sprintf("_%d", run.part)
+		}
+		metadata["Part"] = fmt.Sprint(run.part)
+	}
+	urlString := run.URL + "/" + name + ".proio"
+
+	// the run outlives the stream until all of its events are written
+	object, err := data.CreateObject(data.ServerContext(context.Background()), urlString, run.Credentials)
+	if err != nil {
+		log.Println(err)
+		m.PubStatus("Run", err.Error())
+		m.stopRun(&message.Cmd{})
+		return
+	}
+	run.size = &data.CountingWriter{W: object}
+	writer := data.NewIndexedWriter(run.size, data.DefaultTimeIndexInterval)
+	writer.DeferUntilClose(object.Close)
+
+	if m.recorder != nil {
+		m.recorder.Stop()
+	}
+
+	thisUrl, err := url.Parse(urlString)
+	m.runFilename = strings.TrimLeft(thisUrl.Path, "/")
 
 	log.Printf("starting run %v://%v/%v", thisUrl.Scheme, thisUrl.Host, m.runFilename)
 
 	writer.SetCompression(proio.LZ4)
-	delete(cmd.Metadata, "credentials")
-	delete(cmd.Metadata, "url")
-	for key, value := range cmd.Metadata {
+	for key, value := range metadata {
 		writer.PushMetadata(key, []byte(value))
 	}
+	m.catalogRun(urlString, run.partStart, metadata)
 
 	msg := &message.Msg{
 		Type:     "stream status",
@@ -565,12 +755,27 @@ func (m *StreamManager) startRun(cmd *message.Cmd) {
 	msg.Metadata["Run"] = m.runFilename
 	message.PublishJsonMsg(m.Redis, m.Namespace+" stream "+m.Name, msg)
 
+	cleanup := func(event *proio.Event) {
+		for key := range metadata {
+			delete(event.Metadata, key)
+		}
+
+		for _, proc := range m.CleanupRunData {
+			proc(event)
+		}
+	}
+	recorder := newRunRecorder(
+		writer,
+		cleanup,
+		StreamDroppedEvents.WithLabelValues(m.Namespace, m.Name, "run"),
+		RunSpooledEvents.WithLabelValues(m.Namespace, m.Name),
+	)
+	m.recorder = recorder
+
+	runFilename := m.runFilename
 	ctx, cancel := context.WithCancel(m.ctx)
 	go func() {
-		defer writer.Close()
-
 		go func() {
-			start := time.Now()
 			for {
 				time.Sleep(100 * time.Millisecond)
 
@@ -585,36 +790,36 @@ func (m *StreamManager) startRun(cmd *message.Cmd) {
 					Metadata: make(map[string]string),
 				}
 				msg.Metadata["stream"] = m.Name
-				msg.Metadata["Run Time"] = fmt.Sprintf("%v", time.Since(start).Truncate(100*time.Millisecond))
+				msg.Metadata["Run Time"] = fmt.Sprintf("%v", time.Since(run.start).Truncate(100*time.Millisecond))
+				msg.Metadata["Run Spooled"] = fmt.Sprint(recorder.Spooled())
+				msg.Metadata["Run Dropped"] = fmt.Sprint(recorder.Dropped())
 				message.PublishJsonMsg(m.Redis, m.Namespace+" stream "+m.Name, msg)
 			}
 		}()
 		defer cancel()
-		defer log.Printf("stopping run %v://%v/%v", thisUrl.Scheme, thisUrl.Host, m.runFilename)
-
-		for event := range m.runChannel {
-			if event == nil {
-				return
-			}
-
-			for key := range cmd.Metadata {
-				delete(event.Metadata, key)
-			}
 
-			for _, proc := range m.CleanupRunData {
-				proc(event)
-			}
-			writer.Push(event)
+		<-recorder.Done()
+		if err := writer.Close(); err != nil {
+			log.Println("unable to close run:", err)
+		}
+		// the time index goes next to the run for opening it part way through
+		err := data.WriteTimeIndex(data.ServerContext(context.Background()), urlString+data.TimeIndexSuffix, run.Credentials, writer.Index())
+		if err != nil {
+			log.Println("unable to write time index of run:", err)
 		}
+		catalogRunStop(urlString, time.Now(), recorder.Written(), recorder.Dropped())
+		log.Printf("stopping run %v://%v/%v after %v events with %v dropped",
+			thisUrl.Scheme, thisUrl.Host, runFilename, recorder.Written(), recorder.Dropped())
 	}()
 }
 
 func (m *StreamManager) stopRun(cmd *message.Cmd) {
-	log.Printf("stopping run")
-	if m.runChannel != nil {
-		m.runChannel <- nil
+	if m.recorder != nil {
+		log.Printf("stopping run")
+		m.recorder.Stop()
 	}
-	m.runChannel = nil
+	m.recorder = nil
+	m.run = nil
 }
 
 func (m *StreamManager) pubRunMeta(cmd *message.Cmd) {
@@ -624,7 +829,107 @@ func (m *StreamManager) pubDesc(cmd *message.Cmd) {
 	m.doPubDesc = true
 }
 
+func (m *StreamManager) takePedestals(cmd *message.Cmd) {
+	if m.Pedestals == nil {
+		return
+	}
+	nFrames, err := strconv.Atoi(cmd.Metadata["frames"])
+	if err != nil || nFrames <= 0 {
+		nFrames = 100
+	}
+	m.Pedestals.Take(nFrames)
+	m.pubPedestalStatus()
+}
+
+func (m *StreamManager) freezePedestals(cmd *message.Cmd) {
+	if m.Pedestals == nil {
+		return
+	}
+	m.Pedestals.Freeze(strings.ToLower(cmd.Metadata["freeze"]) != "false")
+	m.pubPedestalStatus()
+}
+
+func (m *StreamManager) savePedestals(cmd *message.Cmd) {
+	if m.Pedestals == nil {
+		return
+	}
+	if err := m.Pedestals.Save(); err != nil {
+		log.Println("unable to save pedestals:", err)
+	}
+}
+
+func (m *StreamManager) loadPedestals(cmd *message.Cmd) {
+	if m.Pedestals == nil {
+		return
+	}
+	if err := m.Pedestals.Load(); err != nil {
+		log.Println("unable to load pedestals:", err)
+	}
+}
+
+// pubPedestalStatus publishes the pedestal status if it has changed
+func (m *StreamManager) pubPedestalStatus() {
+	if m.Pedestals == nil {
+		return
+	}
+	status := m.Pedestals.Status()
+	if status == m.lastPedStatus {
+		return
+	}
+	m.lastPedStatus = status
+	m.PubStatus("Pedestals", status)
+}
+
+func (m *StreamManager) setAlarm(cmd *message.Cmd) {
+	rule, err := ParseAlarmRule(cmd.Metadata["rule"])
+	if err != nil {
+		log.Println(err)
+		m.PubStatus("Alarms", html.EscapeString(err.Error()))
+		return
+	}
+	rule.Name = cmd.Metadata["name"]
+	if rule.Name == "" {
+		rule.Name = cmd.Metadata["rule"]
+	}
+	if hysteresis, err := strconv.ParseFloat(cmd.Metadata["hysteresis"], 64); err == nil {
+		rule.Hysteresis = hysteresis
+	}
+	if webhook := cmd.Metadata["webhook"]; webhook != "" {
+		if err := CheckAlarmWebhook(webhook); err != nil {
+			log.Println(err)
+			m.PubStatus("Alarms", html.EscapeString(err.Error()))
+			return
+		}
+		rule.Webhook = webhook
+	}
+
+	m.Alarms.SetRule(rule)
+	m.listAlarms(cmd)
+}
+
+func (m *StreamManager) rmAlarm(cmd *message.Cmd) {
+	m.Alarms.RemoveRule(cmd.Metadata["name"])
+	m.listAlarms(cmd)
+}
+
+func (m *StreamManager) listAlarms(cmd *message.Cmd) {
+	m.PubStatus("Alarms", m.Alarms.Summary())
+}
+
+// PubStatus publishes a stream status entry
+func (m *StreamManager) PubStatus(key, value string) {
+	msg := &message.Msg{
+		Type:     "stream status",
+		Metadata: make(map[string]string),
+	}
+	msg.Metadata["stream"] = m.Name
+	msg.Metadata[key] = value
+	message.PublishJsonMsg(m.Redis, m.Namespace+" stream "+m.Name, msg)
+}
+
 func (m *StreamManager) handleMetadata(event *proio.Event) {
+	m.pubPedestalStatus()
+
 	if m.doPubDesc {
 		m.doPubDesc = false
 
@@ -635,6 +940,22 @@ func (m *StreamManager) handleMetadata(event *proio.Event) {
 		msg.Metadata["stream"] = m.Name
 		msg.Metadata["Description"] = string(event.Metadata["Description"])
 		message.PublishJsonMsg(m.Redis, m.Namespace+" stream "+m.Name, msg)
+
+		// make sure new clients see the pedestal status
+		m.lastPedStatus = ""
+	}
+
+	if unmatched := string(event.Metadata[data.UnmatchedSamplesKey]); unmatched != m.lastUnmatched {
+		m.lastUnmatched = unmatched
+		m.PubStatus("Unmatched Samples", unmatched)
+	}
+	if lost := string(event.Metadata[data.LostSamplesKey]); lost != m.lastLost {
+		m.lastLost = lost
+		m.PubStatus("Lost Samples", lost)
+	}
+	if position := string(event.Metadata[data.PlaybackPositionKey]); position != m.lastPosition {
+		m.lastPosition = position
+		m.PubStatus("Playback Position", position)
 	}
 
 	tempMeta := event.Metadata["Temp"]
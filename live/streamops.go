@@ -6,6 +6,9 @@ package live
 
 import (
 	"fmt"
+	"log"
+	"math"
+	"strings"
 
 	"github.com/rditech/rdi-live/data"
 	"github.com/rditech/rdi-live/model/rdi/currentmode"
@@ -15,36 +18,25 @@ import (
 	"github.com/proio-org/go-proio"
 )
 
+// BuildPlayer returns the ops of a stream played from a recorded run, which
+// are fed by a data.Player, or nil if the run cannot be played
 func BuildPlayer(
 	namespace, stream string,
 	client *redis.Client,
 	addr string,
 	uid uint64,
 ) data.OpArray {
-	player := &data.Player{Speed: 1}
-	var sp data.StreamProcessor
 	switch data.GetMode(uid) {
-	case detmapmodel.HpsConfig_CURRENT:
-		sp = player.PlayCmStream
+	case detmapmodel.HpsConfig_CURRENT, detmapmodel.HpsConfig_PULSED:
 	default:
-	}
-	if sp == nil {
 		return nil
 	}
 
-	ops := BuildOpArray(namespace, stream, client, addr, uid)
-	if ops == nil {
-		return nil
-	}
-	ops = append(
-		data.OpArray{
-			data.StreamOp{
-				StreamProcessor: sp,
-			},
-		},
-		ops...,
-	)
-	return ops
+	// played runs track pedestals of their own, and must neither start from
+	// nor overwrite the pedestals saved for the live detector
+	env := pipelineEnv(namespace, client, uid)
+	env.PedestalStore = nil
+	return buildOpArray(env, namespace, stream, client, addr, uid)
 }
 
 func BuildOpArray(
@@ -52,69 +44,89 @@ func BuildOpArray(
 	client *redis.Client,
 	addr string,
 	uid uint64,
+) data.OpArray {
+	return buildOpArray(pipelineEnv(namespace, client, uid), namespace, stream, client, addr, uid)
+}
+
+func buildOpArray(
+	env *data.PipelineEnv,
+	namespace, stream string,
+	client *redis.Client,
+	addr string,
+	uid uint64,
 ) data.OpArray {
 	var ops data.OpArray
 	switch data.GetMode(uid) {
 	case detmapmodel.HpsConfig_CURRENT:
-		ops = BuildCmOpArray(namespace, stream, client, addr, uid)
+		ops = buildCmOpArray(env, namespace, stream, client, addr)
+	case detmapmodel.HpsConfig_PULSED:
+		ops = buildPmOpArray(env, namespace, stream, client, addr)
 	default:
 	}
 	return ops
 }
 
+// CmPipeline is the pipeline of live current-mode streams, ahead of the stream
+// manager
+var CmPipeline = data.MustParsePipeline(`
+- assemble
+- lostsamples
+- merge
+- map
+- correlate
+- pedestals
+- health
+- beam
+- image
+`)
+
+// pipelineEnv returns the environment for building the pipeline of a live
+// stream
+func pipelineEnv(namespace string, client *redis.Client, uid uint64) *data.PipelineEnv {
+	env := &data.PipelineEnv{
+		UID:           uid,
+		Mapper:        data.DefaultDetmaps.Mapper(uid, nil),
+		PedestalStore: PedestalStore,
+		Concurrency:   16,
+		MaxEventBuf:   1,
+	}
+	if env.PedestalStore == nil && client != nil {
+		env.PedestalStore = &RedisPedestalStore{Client: client, Namespace: namespace}
+	}
+	return env
+}
+
 func BuildCmOpArray(namespace, stream string, client *redis.Client, addr string, uid uint64) data.OpArray {
-	corr := &data.Correlator{}
-	peds := &data.Pedestals{}
-	recon := data.NewBeamReconstruction(uid)
+	return buildCmOpArray(pipelineEnv(namespace, client, uid), namespace, stream, client, addr)
+}
+
+func buildCmOpArray(env *data.PipelineEnv, namespace, stream string, client *redis.Client, addr string) data.OpArray {
+	ops, err := CmPipeline.Build(env)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
 	streamManager := StreamManager{
 		Namespace:       namespace,
 		Name:            stream,
 		Redis:           client,
 		Addr:            addr,
+		Mapper:          env.Mapper,
+		Pedestals:       env.Pedestals,
 		GenerateSources: CmGenerateSources,
 		CleanupRunData: []data.EventProcessor{
 			data.KeepOnlyRawFrames,
 		},
 	}
 
-	cmAggregateOps := append(
-		data.OpArray{
-			data.EventOp{
-				EventProcessor: data.AssembleFrame,
-				Concurrency:    16,
-				MaxEventBuf:    1,
-			},
-		},
-		data.StreamOp{
-			Description:     "Merge current-mode stream aggregate",
-			StreamProcessor: data.CmMerge,
-			MaxEventBuf:     1,
-		},
-		data.EventOp{
-			EventProcessor: data.MapEvent,
-			Concurrency:    16,
-			MaxEventBuf:    1,
-		},
-		data.StreamOp{
-			StreamProcessor: corr.CorrelateCmEvent,
-			MaxEventBuf:     1,
-		},
-		data.StreamOp{
-			StreamProcessor: peds.Subtract,
-			MaxEventBuf:     1,
-		},
-		data.EventOp{
-			EventProcessor: recon.FillBeamInfo,
-			Concurrency:    16,
-			MaxEventBuf:    1,
-		},
+	return append(
+		ops,
 		data.StreamOp{
 			StreamProcessor: streamManager.Manage,
 			MaxEventBuf:     1000,
 		},
 	)
-
-	return cmAggregateOps
 }
 
 var one = float32(1)
@@ -137,6 +149,11 @@ func CmGenerateSources(m *StreamManager, event *proio.Event) {
 		m.HandleSource(correlationInfo, Normal, &tFrame, &frame.Correlation)
 
 		for _, sample := range frame.Sample {
+			// placeholders for lost samples are shown in "Lost Samples"
+			// rather than as currents
+			if sample.Placeholder {
+				continue
+			}
 			tSample := tFrame + float64(sample.Timestamp)/(1<<32)
 
 			var totI float32
@@ -172,10 +189,31 @@ func CmGenerateSources(m *StreamManager, event *proio.Event) {
 		}
 	}
 
+	lostInfo := m.GetSourceInfo("Lost Samples")
+	for _, frameId := range event.TaggedEntries("Lost") {
+		frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+		if !ok {
+			continue
+		}
+
+		tFrame := float64(frame.Timestamp) / (1 << 32)
+		for _, sample := range frame.Sample {
+			tSample := tFrame + float64(sample.Timestamp)/(1<<32)
+			for _, hpsSample := range sample.Hps {
+				nLost := float32(hpsSample.Sum)
+				m.HandleSource(lostInfo, Normal, &tSample, &nLost)
+			}
+		}
+	}
+
 	meanXInfo := m.GetSourceInfo("Mean X")
 	meanYInfo := m.GetSourceInfo("Mean Y")
 	meanXYInfo := m.GetSourceInfo("Mean XY")
 	meanAndTotalI := m.GetSourceInfo("Mean and Total Current")
+	sigmaXInfo := m.GetSourceInfo("Sigma X")
+	sigmaYInfo := m.GetSourceInfo("Sigma Y")
+	covInfo := m.GetSourceInfo("XY Covariance")
+	ellipseInfo := m.GetSourceInfo("Beam Ellipse")
 	for _, frameId := range event.TaggedEntries("Reduced") {
 		frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
 		if !ok {
@@ -184,6 +222,7 @@ func CmGenerateSources(m *StreamManager, event *proio.Event) {
 
 		tFrame := float64(frame.Timestamp) / (1 << 32)
 
+		var frameInfo currentmode.Sample_BeamInfo
 		for _, sample := range frame.Sample {
 			tSample := tFrame + float64(sample.Timestamp)/(1<<32)
 
@@ -195,6 +234,123 @@ func CmGenerateSources(m *StreamManager, event *proio.Event) {
 				&sample.BeamInfo.MeanYPos,
 				&sample.BeamInfo.TotalCurrent,
 			)
+
+			sigmaX := float32(math.Sqrt(math.Max(float64(sample.BeamInfo.XVar), 0)))
+			sigmaY := float32(math.Sqrt(math.Max(float64(sample.BeamInfo.YVar), 0)))
+			m.HandleSource(sigmaXInfo, Normal, &tSample, &sigmaX)
+			m.HandleSource(sigmaYInfo, Normal, &tSample, &sigmaY)
+			m.HandleSource(covInfo, Advanced, &tSample, &sample.BeamInfo.XYCov)
+
+			frameInfo.MeanXPos += sample.BeamInfo.MeanXPos
+			frameInfo.MeanYPos += sample.BeamInfo.MeanYPos
+			frameInfo.XVar += sample.BeamInfo.XVar
+			frameInfo.YVar += sample.BeamInfo.YVar
+			frameInfo.XYCov += sample.BeamInfo.XYCov
+		}
+
+		if nSamples := float32(len(frame.Sample)); nSamples > 0 {
+			frameInfo.MeanXPos /= nSamples
+			frameInfo.MeanYPos /= nSamples
+			frameInfo.XVar /= nSamples
+			frameInfo.YVar /= nSamples
+			frameInfo.XYCov /= nSamples
+
+			for _, pt := range beamEllipse(&frameInfo, nEllipsePoints) {
+				m.HandleSource(ellipseInfo, Normal, &pt[0], &pt[1])
+			}
+		}
+	}
+
+	for _, frameId := range event.TaggedEntries("Health") {
+		frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+		if !ok || len(frame.Sample) == 0 {
+			continue
+		}
+
+		var flags []float32
+		var flagged []string
+		for i, axis := range frame.Sample[0].Axis {
+			for j, flag := range axis.Channel {
+				flags = append(flags, float32(flag))
+				if data.ChannelFlag(flag) != data.ChannelOk {
+					flagged = append(flagged, fmt.Sprintf("axis %d channel %d %v", i, j, data.ChannelFlag(flag)))
+				}
+			}
+
+			rmsInfo := m.GetSourceInfo(fmt.Sprintf("Axis %d Channel RMS", i))
+			m.HandleSource(rmsInfo, Advanced, axis.FloatChannel)
 		}
+		m.HandleSource(m.GetSourceInfo("Channel Health"), Normal, flags)
+
+		status := "ok"
+		if len(flagged) > 0 {
+			status = fmt.Sprintf("%d masked: %v", len(flagged), strings.Join(flagged, ", "))
+		}
+		m.PubStatus("Channel Health", status)
+	}
+
+	imageFrameIds := event.TaggedEntries("Image")
+	if len(imageFrameIds) > 0 && m.Mapper != nil {
+		imageConfs := m.Mapper.ImageConfigs()
+		if len(imageConfs) == 0 {
+			return
+		}
+		geometry := imageConfs[0].Geometry
+
+		for _, frameId := range imageFrameIds {
+			frame, ok := event.GetEntry(frameId).(*currentmode.Frame)
+			if !ok || len(frame.Sample) == 0 {
+				continue
+			}
+
+			for i, axis := range frame.Sample[0].Axis {
+				var geom *detmapmodel.DetectorConfig_ImageConfig_Geometry
+				if i < len(geometry) {
+					geom = geometry[i]
+				} else if len(geometry) == 0 {
+					geom = &detmapmodel.DetectorConfig_ImageConfig_Geometry{
+						N:     uint32(len(axis.FloatChannel)),
+						M:     1,
+						Pitch: 1,
+					}
+				} else {
+					break
+				}
+
+				imageInfo := m.GetSourceInfo("Image")
+				if i > 0 {
+					imageInfo = m.GetSourceInfo(fmt.Sprintf("Image %d", i))
+				}
+				m.HandleSource(imageInfo, Normal, axis.FloatChannel, geom)
+			}
+		}
+	}
+}
+
+const nEllipsePoints = 32
+
+// beamEllipse returns n points on the one-sigma ellipse described by the mean
+// and covariance of a beam
+func beamEllipse(info *currentmode.Sample_BeamInfo, n int) [][2]float32 {
+	a := float64(info.XVar)
+	b := float64(info.XYCov)
+	c := float64(info.YVar)
+
+	// eigen decomposition of the symmetric covariance matrix
+	halfTrace := (a + c) / 2
+	disc := math.Sqrt((a-c)*(a-c)/4 + b*b)
+	sigmaMajor := math.Sqrt(math.Max(halfTrace+disc, 0))
+	sigmaMinor := math.Sqrt(math.Max(halfTrace-disc, 0))
+	angle := math.Atan2(2*b, a-c) / 2
+	sinA, cosA := math.Sincos(angle)
+
+	points := make([][2]float32, n)
+	for i := range points {
+		sinT, cosT := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+		u := sigmaMajor * cosT
+		v := sigmaMinor * sinT
+		points[i][0] = info.MeanXPos + float32(u*cosA-v*sinA)
+		points[i][1] = info.MeanYPos + float32(u*sinA+v*cosA)
 	}
+	return points
 }
@@ -144,6 +144,24 @@ function handleStreamStatus(msg) {
     }
 }
 
+function escapeHtml(text) {
+    return String(text).replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+}
+
+function handleAlarm(msg) {
+    var status = {
+        Metadata: {
+            stream: msg.Metadata.stream
+        }
+    };
+    var text = escapeHtml(msg.Metadata.state.toUpperCase() + ': ' + msg.Metadata.rule + ' (' + msg.Metadata.value + ')');
+    if (msg.Metadata.state === 'active') {
+        text = '<span style="color: red">' + text + '</span>';
+    }
+    status.Metadata['Alarm ' + escapeHtml(msg.Metadata.name)] = text;
+    handleStreamStatus(status);
+}
+
 var dragsource = null;
 
 function handleSourceAnnounce(msg) {
@@ -283,6 +301,11 @@ function makeStreamBox(msg, stream) {
     rundesc.setAttribute('placeholder', 'Run Description');
     runctldiv.appendChild(rundesc);
 
+    var runtags = document.createElement('input');
+    runtags.setAttribute('class', 'control');
+    runtags.setAttribute('placeholder', 'Run Tags (comma separated)');
+    runctldiv.appendChild(runtags);
+
     runstart.addEventListener(
         'click',
         function() {
@@ -299,7 +322,8 @@ function makeStreamBox(msg, stream) {
                     'stream cmd': 'start run',
                     url: resource['url'],
                     credentials: resource['credentials'],
-                    Description: rundesc.value
+                    Description: rundesc.value,
+                    Tags: runtags.value
                 }
             };
             ws.send(JSON.stringify(cmd));
@@ -320,6 +344,162 @@ function makeStreamBox(msg, stream) {
         }
     );
 
+    // Pedestal control
+    var pedctldiv = document.createElement('div');
+    pedctldiv.style.overflow = 'hidden';
+
+    var pedfreeze = document.createElement('button');
+    pedfreeze.setAttribute('class', 'control red');
+    pedfreeze.innerHTML = 'Freeze';
+    pedctldiv.appendChild(pedfreeze);
+
+    var pedresume = document.createElement('button');
+    pedresume.setAttribute('class', 'control green');
+    pedresume.innerHTML = 'Resume';
+    pedctldiv.appendChild(pedresume);
+
+    var pedtake = document.createElement('button');
+    pedtake.setAttribute('class', 'control');
+    pedtake.innerHTML = 'Take Pedestals (Beam Off)';
+    pedctldiv.appendChild(pedtake);
+
+    var pedframes = document.createElement('input');
+    pedframes.type = 'number';
+    pedframes.min = 1;
+    pedframes.value = 100;
+    pedframes.title = 'Number of frames to average';
+    pedframes.classList.add('control');
+    pedctldiv.appendChild(pedframes);
+
+    function sendStreamCmd(command, metadata) {
+        cmd = {
+            Command: 'stream cmd',
+            Metadata: {
+                stream: stream,
+                'stream cmd': command
+            }
+        };
+        for (var key in metadata) {
+            cmd.Metadata[key] = metadata[key];
+        }
+        ws.send(JSON.stringify(cmd));
+    }
+
+    pedfreeze.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('freeze pedestals', {freeze: 'true'});
+        }
+    );
+
+    pedresume.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('freeze pedestals', {freeze: 'false'});
+        }
+    );
+
+    pedtake.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('take pedestals', {frames: String(pedframes.value)});
+        }
+    );
+
+    // Playback control
+    var playctldiv = document.createElement('div');
+    playctldiv.style.overflow = 'hidden';
+
+    var playpause = document.createElement('button');
+    playpause.setAttribute('class', 'control red');
+    playpause.innerHTML = 'Pause';
+    playctldiv.appendChild(playpause);
+
+    var playresume = document.createElement('button');
+    playresume.setAttribute('class', 'control green');
+    playresume.innerHTML = 'Resume';
+    playctldiv.appendChild(playresume);
+
+    var playstep = document.createElement('button');
+    playstep.setAttribute('class', 'control');
+    playstep.innerHTML = 'Step';
+    playctldiv.appendChild(playstep);
+
+    var playspeed = document.createElement('input');
+    playspeed.type = 'number';
+    playspeed.min = 0.1;
+    playspeed.step = 0.1;
+    playspeed.value = 1;
+    playspeed.title = 'Playback speed';
+    playspeed.classList.add('control');
+    playctldiv.appendChild(playspeed);
+
+    var playloop = document.createElement('select');
+    playloop.classList.add('control');
+    playloop.title = 'Loop at the end of the run';
+    ['on', 'off'].forEach(function(value) {
+        var option = document.createElement('option');
+        option.value = value;
+        option.innerHTML = 'Loop ' + value;
+        playloop.appendChild(option);
+    });
+    playctldiv.appendChild(playloop);
+
+    var playseek = document.createElement('button');
+    playseek.setAttribute('class', 'control');
+    playseek.innerHTML = 'Seek';
+    playctldiv.appendChild(playseek);
+
+    var playtime = document.createElement('input');
+    playtime.type = 'number';
+    playtime.min = 0;
+    playtime.value = 0;
+    playtime.title = 'Seconds from the start of the run';
+    playtime.classList.add('control');
+    playctldiv.appendChild(playtime);
+
+    playpause.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('pause', {});
+        }
+    );
+
+    playresume.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('resume', {});
+        }
+    );
+
+    playstep.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('step', {});
+        }
+    );
+
+    playspeed.addEventListener(
+        'change',
+        function() {
+            sendStreamCmd('speed', {speed: String(playspeed.value)});
+        }
+    );
+
+    playloop.addEventListener(
+        'change',
+        function() {
+            sendStreamCmd('loop', {loop: playloop.value});
+        }
+    );
+
+    playseek.addEventListener(
+        'click',
+        function() {
+            sendStreamCmd('seek', {time: String(playtime.value)});
+        }
+    );
+
     // Stream status
     var statusdiv = document.createElement('div');
     var statustablediv = document.createElement('div');
@@ -349,6 +529,12 @@ function makeStreamBox(msg, stream) {
     fillControlTabs(box, [{
         name: 'Run Control',
         element: runctldiv
+    }, {
+        name: 'Pedestals',
+        element: pedctldiv
+    }, {
+        name: 'Playback',
+        element: playctldiv
     }, {
         name: 'Data',
         element: datadiv
@@ -73,6 +73,9 @@ wsonmessage = function(event) {
         case 'stream unsub':
             handleUnsubscribe(msg);
             break;
+        case 'alarm':
+            handleAlarm(msg);
+            break;
         case 'stream status':
             handleStreamStatus(msg);
             break;
@@ -108,11 +108,14 @@ type Sample struct {
 	// a particular HPS installation.
 	Hps map[uint64]*HpsSample `protobuf:"bytes,2,rep,name=hps,proto3" json:"hps,omitempty" protobuf_key:"varint,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	// axis is filled once mapping is applied and the hps field is emptied.
-	Axis                 []*AxisSample    `protobuf:"bytes,3,rep,name=axis,proto3" json:"axis,omitempty"`
-	BeamInfo             *Sample_BeamInfo `protobuf:"bytes,4,opt,name=beam_info,json=beamInfo,proto3" json:"beam_info,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
-	XXX_unrecognized     []byte           `json:"-"`
-	XXX_sizecache        int32            `json:"-"`
+	Axis     []*AxisSample    `protobuf:"bytes,3,rep,name=axis,proto3" json:"axis,omitempty"`
+	BeamInfo *Sample_BeamInfo `protobuf:"bytes,4,opt,name=beam_info,json=beamInfo,proto3" json:"beam_info,omitempty"`
+	// placeholder marks a sample standing in for a lost sample, which is not
+	// a real reading of the channels
+	Placeholder          bool     `protobuf:"varint,5,opt,name=placeholder,proto3" json:"placeholder,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Sample) Reset()         { *m = Sample{} }
@@ -168,6 +171,13 @@ func (m *Sample) GetBeamInfo() *Sample_BeamInfo {
 	return nil
 }
 
+func (m *Sample) GetPlaceholder() bool {
+	if m != nil {
+		return m.Placeholder
+	}
+	return false
+}
+
 type Sample_BeamInfo struct {
 	MeanXPos             float32  `protobuf:"fixed32,1,opt,name=mean_x_pos,json=meanXPos,proto3" json:"mean_x_pos,omitempty"`
 	MeanYPos             float32  `protobuf:"fixed32,2,opt,name=mean_y_pos,json=meanYPos,proto3" json:"mean_y_pos,omitempty"`
@@ -397,38 +407,39 @@ func init() {
 func init() { proto.RegisterFile("proto/rdi/currentmode/frame.proto", fileDescriptor_24c8349567168d5a) }
 
 var fileDescriptor_24c8349567168d5a = []byte{
-	// 527 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0x5d, 0x8b, 0xd3, 0x40,
-	0x14, 0x25, 0x49, 0xd3, 0x8f, 0xdb, 0x94, 0xd5, 0x11, 0x34, 0xd4, 0x7d, 0x88, 0xdd, 0x97, 0x3c,
-	0xb5, 0x52, 0x5f, 0x44, 0x58, 0xc1, 0x2d, 0x8a, 0xbe, 0xa8, 0x8c, 0xb0, 0xb8, 0x82, 0x84, 0x69,
-	0x3b, 0x61, 0x83, 0x49, 0x26, 0xcc, 0x4c, 0x4b, 0xf2, 0x87, 0x7c, 0xf3, 0xaf, 0xe8, 0x5f, 0x92,
-	0xf9, 0x48, 0x5b, 0xac, 0xd6, 0x97, 0x7d, 0xbb, 0x3d, 0xf7, 0xdc, 0x7b, 0xce, 0x3d, 0xd3, 0x16,
-	0x9e, 0x54, 0x9c, 0x49, 0x36, 0xe3, 0xeb, 0x6c, 0xb6, 0xda, 0x70, 0x4e, 0x4b, 0x59, 0xb0, 0x35,
-	0x9d, 0xa5, 0x9c, 0x14, 0x74, 0xaa, 0x7b, 0xe8, 0x8c, 0xaf, 0xb3, 0xe9, 0x41, 0x73, 0xf2, 0xcb,
-	0x05, 0xff, 0x8d, 0x22, 0xa0, 0x73, 0x18, 0xc8, 0xac, 0xa0, 0x42, 0x92, 0xa2, 0x0a, 0x9d, 0xc8,
-	0x89, 0x3b, 0x78, 0x0f, 0xa0, 0x19, 0x74, 0x05, 0x29, 0xaa, 0x9c, 0x86, 0x6e, 0xe4, 0xc5, 0xc3,
-	0xf9, 0xa3, 0xe9, 0x1f, 0x9b, 0xa6, 0x9f, 0x74, 0x1b, 0x5b, 0x1a, 0xba, 0x84, 0x1e, 0x4b, 0x53,
-	0x41, 0xa5, 0x08, 0x3d, 0x3d, 0x71, 0x71, 0x34, 0xa1, 0x75, 0xa7, 0x1f, 0x0c, 0xeb, 0x75, 0x29,
-	0x79, 0x83, 0xdb, 0x19, 0xf4, 0x12, 0x02, 0x52, 0x67, 0x22, 0x69, 0x77, 0xf8, 0x7a, 0xc7, 0xe3,
-	0xa3, 0x1d, 0xaf, 0xea, 0x4c, 0x58, 0xe5, 0xa1, 0x1a, 0xb0, 0xdb, 0x50, 0x04, 0xc3, 0x15, 0xe3,
-	0x9c, 0xe6, 0x44, 0x66, 0xac, 0x0c, 0x3b, 0x91, 0x13, 0xbb, 0xf8, 0x10, 0x1a, 0x5f, 0x43, 0x70,
-	0x28, 0x8d, 0xee, 0x81, 0xf7, 0x8d, 0x36, 0xf6, 0x72, 0x55, 0xa2, 0xa7, 0xe0, 0x6f, 0x49, 0xbe,
-	0x51, 0x27, 0x3b, 0xf1, 0x70, 0x3e, 0x3e, 0x12, 0x7f, 0x5b, 0xb5, 0xda, 0x86, 0xf8, 0xc2, 0x7d,
-	0xee, 0x4c, 0x7e, 0x7a, 0xd0, 0x35, 0xe8, 0x7f, 0x22, 0x9d, 0x83, 0x77, 0x5b, 0x09, 0x9b, 0x67,
-	0xf4, 0x8f, 0x3c, 0x95, 0x86, 0x89, 0x46, 0x91, 0xd1, 0x0c, 0x3a, 0xea, 0x4a, 0x1b, 0xe9, 0xc9,
-	0x38, 0x34, 0x11, 0x5d, 0xc2, 0x60, 0x49, 0x49, 0x91, 0x64, 0x65, 0xca, 0x74, 0x0a, 0x27, 0xa4,
-	0xae, 0x28, 0x29, 0xde, 0x95, 0x29, 0xc3, 0xfd, 0xa5, 0xad, 0xc6, 0x18, 0xfa, 0xad, 0x81, 0xbb,
-	0x0a, 0x68, 0xfc, 0xc3, 0x81, 0x7e, 0x2b, 0x85, 0xce, 0x01, 0x0a, 0x4a, 0xca, 0xa4, 0x4e, 0x2a,
-	0x26, 0xf4, 0x6e, 0x17, 0xf7, 0x15, 0xf2, 0xf9, 0x23, 0x13, 0xbb, 0x6e, 0xa3, 0xbb, 0xee, 0xbe,
-	0x7b, 0xa3, 0xba, 0x17, 0x30, 0x92, 0x4c, 0x92, 0x3c, 0xb1, 0x92, 0xa1, 0xa7, 0x09, 0x81, 0x06,
-	0x17, 0x06, 0x43, 0x0f, 0xc0, 0xaf, 0x93, 0x2d, 0xe1, 0xf6, 0x2b, 0xd0, 0xa9, 0xaf, 0x09, 0x57,
-	0x60, 0xa3, 0x41, 0xdf, 0x80, 0x8d, 0x02, 0x1f, 0x42, 0xaf, 0x4e, 0x9a, 0x64, 0xc5, 0xb6, 0x61,
-	0x57, 0xc3, 0x7e, 0x7d, 0xb3, 0x60, 0xdb, 0xc9, 0x77, 0x07, 0x06, 0xbb, 0x43, 0x50, 0x08, 0xbd,
-	0xd5, 0x2d, 0x29, 0x4b, 0x9a, 0x87, 0x4e, 0xe4, 0xc5, 0xf7, 0x71, 0xfb, 0x51, 0xe5, 0x23, 0x36,
-	0x85, 0x76, 0x89, 0xb0, 0x2a, 0x95, 0xc1, 0x34, 0x67, 0x44, 0x26, 0xed, 0x84, 0x7a, 0x36, 0x17,
-	0x07, 0x1a, 0x5c, 0xd8, 0x31, 0x45, 0xca, 0x6a, 0xba, 0xde, 0x91, 0x3a, 0x91, 0x17, 0x9f, 0xe1,
-	0x40, 0x83, 0x07, 0x24, 0xf3, 0xbb, 0x4a, 0xca, 0x4d, 0xb1, 0xa4, 0xc6, 0xf8, 0x08, 0x07, 0x06,
-	0x7c, 0xaf, 0xb1, 0xc9, 0x57, 0x80, 0xfd, 0xfb, 0x9f, 0x30, 0x7a, 0x64, 0xcb, 0xfd, 0x8b, 0x2d,
-	0x7b, 0x8d, 0x89, 0x54, 0x95, 0x57, 0xa3, 0x2f, 0xc3, 0x83, 0xb7, 0x5d, 0x76, 0xf5, 0x3f, 0xca,
-	0xb3, 0xdf, 0x01, 0x00, 0x00, 0xff, 0xff, 0xa0, 0x01, 0x8c, 0x1e, 0x76, 0x04, 0x00, 0x00,
+	// 543 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x94, 0xcd, 0x8e, 0xd3, 0x3c,
+	0x14, 0x86, 0x95, 0xa4, 0xe9, 0xcf, 0x69, 0xaa, 0xf9, 0x3e, 0x23, 0x41, 0x54, 0x66, 0x11, 0x3a,
+	0x9b, 0xac, 0x5a, 0x54, 0x36, 0x08, 0x69, 0x90, 0x98, 0x0a, 0x34, 0x6c, 0x00, 0x19, 0x69, 0xc4,
+	0x20, 0xa1, 0xc8, 0x6d, 0x1c, 0x35, 0x22, 0x89, 0x23, 0xdb, 0xad, 0x92, 0x1b, 0x62, 0xc7, 0xb5,
+	0x70, 0x2b, 0x5c, 0x02, 0xb2, 0xe3, 0xb4, 0x11, 0x85, 0x99, 0x0d, 0x3b, 0xf7, 0x39, 0xef, 0xf9,
+	0x7b, 0xed, 0x06, 0x9e, 0x94, 0x9c, 0x49, 0xb6, 0xe0, 0x71, 0xba, 0xd8, 0xec, 0x38, 0xa7, 0x85,
+	0xcc, 0x59, 0x4c, 0x17, 0x09, 0x27, 0x39, 0x9d, 0xeb, 0x18, 0x3a, 0xe3, 0x71, 0x3a, 0xef, 0x04,
+	0x67, 0x3f, 0x6c, 0x70, 0xdf, 0x28, 0x01, 0x3a, 0x87, 0x91, 0x4c, 0x73, 0x2a, 0x24, 0xc9, 0x4b,
+	0xdf, 0x0a, 0xac, 0xb0, 0x87, 0x8f, 0x00, 0x2d, 0xa0, 0x2f, 0x48, 0x5e, 0x66, 0xd4, 0xb7, 0x03,
+	0x27, 0x1c, 0x2f, 0x1f, 0xcd, 0x7f, 0xab, 0x34, 0xff, 0xa8, 0xc3, 0xd8, 0xc8, 0xd0, 0x25, 0x0c,
+	0x58, 0x92, 0x08, 0x2a, 0x85, 0xef, 0xe8, 0x8c, 0x8b, 0x93, 0x0c, 0xdd, 0x77, 0xfe, 0xbe, 0x51,
+	0xbd, 0x2e, 0x24, 0xaf, 0x71, 0x9b, 0x83, 0x5e, 0x82, 0x47, 0xaa, 0x54, 0x44, 0x6d, 0x0d, 0x57,
+	0xd7, 0x78, 0x7c, 0x52, 0xe3, 0x55, 0x95, 0x0a, 0xd3, 0x79, 0xac, 0x12, 0x4c, 0x35, 0x14, 0xc0,
+	0x78, 0xc3, 0x38, 0xa7, 0x19, 0x91, 0x29, 0x2b, 0xfc, 0x5e, 0x60, 0x85, 0x36, 0xee, 0xa2, 0xe9,
+	0x0d, 0x78, 0xdd, 0xd6, 0xe8, 0x3f, 0x70, 0xbe, 0xd2, 0xda, 0x6c, 0xae, 0x8e, 0xe8, 0x29, 0xb8,
+	0x7b, 0x92, 0xed, 0xd4, 0xca, 0x56, 0x38, 0x5e, 0x4e, 0x4f, 0x9a, 0x5f, 0x97, 0x6d, 0xef, 0x46,
+	0xf8, 0xc2, 0x7e, 0x6e, 0xcd, 0x7e, 0x3a, 0xd0, 0x6f, 0xe8, 0x3d, 0x96, 0x2e, 0xc1, 0xd9, 0x96,
+	0xc2, 0xf8, 0x19, 0xfc, 0xc5, 0x4f, 0xd5, 0xa3, 0xb1, 0x46, 0x89, 0xd1, 0x02, 0x7a, 0x6a, 0x4b,
+	0xdf, 0xb9, 0xdf, 0x0e, 0x2d, 0x44, 0x97, 0x30, 0x5a, 0x53, 0x92, 0x47, 0x69, 0x91, 0x30, 0xed,
+	0xc2, 0x1d, 0xad, 0xae, 0x28, 0xc9, 0xdf, 0x16, 0x09, 0xc3, 0xc3, 0xb5, 0x39, 0x29, 0x1b, 0xcb,
+	0x8c, 0x6c, 0xe8, 0x96, 0x65, 0x31, 0xe5, 0xbe, 0x1b, 0x58, 0xe1, 0x10, 0x77, 0xd1, 0x14, 0xc3,
+	0xf0, 0xba, 0xfc, 0xb7, 0x16, 0x4e, 0xbf, 0x5b, 0x30, 0x6c, 0x87, 0x41, 0xe7, 0x00, 0x39, 0x25,
+	0x45, 0x54, 0x45, 0x25, 0x13, 0xba, 0xb6, 0x8d, 0x87, 0x8a, 0x7c, 0xfa, 0xc0, 0xc4, 0x21, 0x5a,
+	0xeb, 0xa8, 0x7d, 0x8c, 0xde, 0xaa, 0xe8, 0x05, 0x4c, 0x24, 0x93, 0x24, 0x8b, 0x4c, 0x4b, 0xdf,
+	0xd1, 0x02, 0x4f, 0xc3, 0x55, 0xc3, 0xd0, 0x03, 0x70, 0xab, 0x68, 0x4f, 0xb8, 0x79, 0x24, 0xbd,
+	0xea, 0x86, 0x70, 0x05, 0x6b, 0x0d, 0xdd, 0x06, 0xd6, 0x0a, 0x3e, 0x84, 0x41, 0x15, 0xd5, 0xd1,
+	0x86, 0xed, 0xfd, 0xbe, 0xc6, 0x6e, 0x75, 0xbb, 0x62, 0xfb, 0xd9, 0x37, 0x0b, 0x46, 0x87, 0x45,
+	0x90, 0x0f, 0x83, 0xcd, 0x96, 0x14, 0x05, 0xcd, 0x7c, 0x2b, 0x70, 0xc2, 0xff, 0x71, 0xfb, 0x53,
+	0xf9, 0x23, 0x76, 0xb9, 0x9e, 0x12, 0x61, 0x75, 0x54, 0x03, 0x26, 0x19, 0x23, 0x32, 0x6a, 0x33,
+	0xd4, 0xc5, 0xda, 0xd8, 0xd3, 0x70, 0x65, 0xd2, 0x94, 0x28, 0xad, 0x68, 0x7c, 0x10, 0xf5, 0x02,
+	0x27, 0x3c, 0xc3, 0x9e, 0x86, 0x1d, 0x51, 0xf3, 0xcf, 0x8b, 0x8a, 0x5d, 0xbe, 0x36, 0x77, 0x35,
+	0xc1, 0x5e, 0x03, 0xdf, 0x69, 0x36, 0xfb, 0x02, 0x70, 0x7c, 0x21, 0x77, 0x0c, 0x7a, 0x32, 0x96,
+	0xfd, 0x87, 0xb1, 0xcc, 0x36, 0x8d, 0xa5, 0xea, 0x78, 0x35, 0xf9, 0x3c, 0xee, 0xdc, 0xed, 0xba,
+	0xaf, 0xbf, 0x39, 0xcf, 0x7e, 0x0d, 0x00, 0x1f, 0xcb, 0x93, 0xa8, 0x98, 0x04, 0x00, 0x00,
 }
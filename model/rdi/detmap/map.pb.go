@@ -133,14 +133,16 @@ func (m *Map) GetDetConfig() map[uint32]*DetectorConfig {
 }
 
 type HpsConfig struct {
-	NChannels            uint32                              `protobuf:"varint,1,opt,name=n_channels,json=nChannels,proto3" json:"n_channels,omitempty"`
-	Channel              map[uint32]*HpsConfig_ChannelConfig `protobuf:"bytes,2,rep,name=channel,proto3" json:"channel,omitempty" protobuf_key:"varint,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	CurrentConv          float32                             `protobuf:"fixed32,3,opt,name=current_conv,json=currentConv,proto3" json:"current_conv,omitempty"`
-	Mode                 HpsConfig_Mode                      `protobuf:"varint,4,opt,name=mode,proto3,enum=rdi.detmap.HpsConfig_Mode" json:"mode,omitempty"`
-	DetConfig            uint32                              `protobuf:"varint,5,opt,name=det_config,json=detConfig,proto3" json:"det_config,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}                            `json:"-"`
-	XXX_unrecognized     []byte                              `json:"-"`
-	XXX_sizecache        int32                               `json:"-"`
+	NChannels   uint32                              `protobuf:"varint,1,opt,name=n_channels,json=nChannels,proto3" json:"n_channels,omitempty"`
+	Channel     map[uint32]*HpsConfig_ChannelConfig `protobuf:"bytes,2,rep,name=channel,proto3" json:"channel,omitempty" protobuf_key:"varint,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	CurrentConv float32                             `protobuf:"fixed32,3,opt,name=current_conv,json=currentConv,proto3" json:"current_conv,omitempty"`
+	Mode        HpsConfig_Mode                      `protobuf:"varint,4,opt,name=mode,proto3,enum=rdi.detmap.HpsConfig_Mode" json:"mode,omitempty"`
+	DetConfig   uint32                              `protobuf:"varint,5,opt,name=det_config,json=detConfig,proto3" json:"det_config,omitempty"`
+	// seconds between samples
+	SamplePeriod         float64  `protobuf:"fixed64,6,opt,name=sample_period,json=samplePeriod,proto3" json:"sample_period,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *HpsConfig) Reset()         { *m = HpsConfig{} }
@@ -203,6 +205,13 @@ func (m *HpsConfig) GetDetConfig() uint32 {
 	return 0
 }
 
+func (m *HpsConfig) GetSamplePeriod() float64 {
+	if m != nil {
+		return m.SamplePeriod
+	}
+	return 0
+}
+
 type HpsConfig_ChannelConfig struct {
 	Axis        uint32 `protobuf:"varint,1,opt,name=axis,proto3" json:"axis,omitempty"`
 	AxisChannel uint32 `protobuf:"varint,2,opt,name=axis_channel,json=axisChannel,proto3" json:"axis_channel,omitempty"`
@@ -637,56 +646,57 @@ func init() {
 func init() { proto.RegisterFile("proto/rdi/detmap/map.proto", fileDescriptor_5b65d484bb8b6a20) }
 
 var fileDescriptor_5b65d484bb8b6a20 = []byte{
-	// 809 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x55, 0xdd, 0x8f, 0xea, 0x44,
-	0x14, 0xbf, 0x85, 0xf2, 0xd1, 0x53, 0x16, 0xc9, 0x5c, 0x4d, 0x6a, 0x13, 0x95, 0xcb, 0x35, 0x37,
-	0x24, 0x26, 0xe5, 0x86, 0x7d, 0x51, 0xa3, 0x3e, 0x08, 0xac, 0x12, 0x81, 0xbd, 0x29, 0x4b, 0xb2,
-	0x9a, 0x98, 0x66, 0x96, 0x0e, 0x30, 0xb1, 0x5f, 0x69, 0x67, 0xb1, 0xfd, 0x27, 0x7d, 0xf6, 0xcd,
-	0x37, 0xff, 0x0e, 0x33, 0xd3, 0x29, 0x94, 0x5d, 0x34, 0x78, 0x9f, 0x98, 0x73, 0xce, 0xcc, 0x8f,
-	0xdf, 0xf9, 0x9d, 0x8f, 0x82, 0x19, 0xc5, 0x21, 0x0b, 0x07, 0xb1, 0x4b, 0x07, 0x2e, 0x61, 0x3e,
-	0x8e, 0x06, 0x3e, 0x8e, 0x2c, 0xe1, 0x44, 0x10, 0xbb, 0xd4, 0xca, 0xbd, 0xbd, 0xbf, 0xaa, 0x50,
-	0x9d, 0xe3, 0x08, 0x7d, 0x0b, 0xb0, 0x8b, 0x12, 0x67, 0x1d, 0x06, 0x1b, 0xba, 0x35, 0x94, 0x6e,
-	0xb5, 0xaf, 0x0f, 0x3f, 0xb5, 0x8e, 0x17, 0xad, 0x39, 0x8e, 0xac, 0x1f, 0xa3, 0x64, 0x24, 0x2e,
-	0x4c, 0x02, 0x16, 0x67, 0xb6, 0xb6, 0x2b, 0x6c, 0x34, 0x83, 0x0f, 0xc4, 0x73, 0xec, 0xd1, 0x87,
-	0x18, 0x33, 0x1a, 0x06, 0x46, 0x45, 0x60, 0xbc, 0x3e, 0x87, 0x71, 0xbc, 0x95, 0x03, 0xb5, 0x77,
-	0x27, 0x4e, 0x4e, 0xc6, 0x25, 0xac, 0x20, 0x53, 0x3d, 0x4f, 0x66, 0x4c, 0xd8, 0x09, 0x19, 0xb7,
-	0xb0, 0xcd, 0x25, 0xb4, 0x4f, 0x99, 0xa2, 0x0e, 0x54, 0x7f, 0x23, 0x99, 0xa1, 0x74, 0x95, 0xfe,
-	0x95, 0xcd, 0x8f, 0xe8, 0x0b, 0xa8, 0xed, 0xb1, 0xf7, 0x48, 0x8c, 0x4a, 0x57, 0xe9, 0xeb, 0xc3,
-	0x8f, 0xca, 0xe8, 0x87, 0xc7, 0x76, 0x7e, 0xe7, 0xeb, 0xca, 0x97, 0x8a, 0xf9, 0x2b, 0xbc, 0x3c,
-	0x43, 0xfd, 0x0c, 0xf2, 0xdb, 0x53, 0x64, 0xf3, 0x29, 0xf2, 0x11, 0xa1, 0x0c, 0x7f, 0x0f, 0xed,
-	0xd3, 0x84, 0xfe, 0x27, 0xf2, 0x98, 0x30, 0xb2, 0x66, 0x61, 0xfc, 0x8c, 0x78, 0xef, 0x8f, 0x2a,
-	0x68, 0x87, 0x8c, 0xd0, 0x27, 0x00, 0x81, 0xb3, 0xde, 0xe1, 0x20, 0x20, 0x5e, 0x22, 0xc1, 0xb5,
-	0x60, 0x24, 0x1d, 0xe8, 0x1b, 0x68, 0xc8, 0xa0, 0xac, 0x5f, 0xef, 0xac, 0x30, 0x96, 0x7c, 0x90,
-	0x4b, 0x5f, 0x3c, 0x41, 0xaf, 0xa0, 0xb5, 0x7e, 0x8c, 0x63, 0x12, 0x88, 0xda, 0xed, 0x8d, 0x6a,
-	0x57, 0xe9, 0x57, 0x6c, 0x5d, 0xfa, 0x46, 0x61, 0xb0, 0x47, 0x16, 0xa8, 0x7e, 0xe8, 0x12, 0x43,
-	0xed, 0x2a, 0xfd, 0xf6, 0x73, 0x71, 0x72, 0xf4, 0x79, 0xe8, 0x12, 0x5b, 0xdc, 0xe3, 0x7c, 0x4b,
-	0xad, 0x50, 0xcb, 0xf9, 0x1e, 0x4b, 0x1d, 0xc1, 0x95, 0xa4, 0x22, 0xf3, 0x43, 0xa0, 0xe2, 0x94,
-	0x16, 0x99, 0x89, 0x33, 0xa7, 0xc5, 0x7f, 0x9d, 0x63, 0x66, 0x3c, 0xa6, 0x73, 0x9f, 0x7c, 0x8c,
-	0x5e, 0x42, 0x2d, 0xc2, 0xae, 0x93, 0x8a, 0x66, 0xab, 0xd8, 0x6a, 0x84, 0xdd, 0xfb, 0xc2, 0x99,
-	0x19, 0xea, 0xc1, 0xf9, 0xb3, 0xe9, 0x40, 0xab, 0x9c, 0xfc, 0x99, 0x32, 0x7d, 0x75, 0x5a, 0xa6,
-	0xd7, 0xff, 0xa9, 0xe0, 0xf3, 0x7a, 0x7d, 0x06, 0x2a, 0xcf, 0x1f, 0xe9, 0xd0, 0x18, 0xad, 0x6c,
-	0x7b, 0xb2, 0xb8, 0xeb, 0xbc, 0x40, 0x00, 0xf5, 0x77, 0xab, 0xd9, 0x72, 0x32, 0xee, 0x28, 0xbd,
-	0xeb, 0xbc, 0xbd, 0x4b, 0xf3, 0xf2, 0x54, 0x77, 0x45, 0xf0, 0x2d, 0xeb, 0xde, 0xfb, 0xb3, 0x2e,
-	0x1a, 0xac, 0xd4, 0x23, 0x5c, 0xaa, 0x00, 0xfb, 0x44, 0x50, 0xd7, 0x6c, 0x71, 0x46, 0x53, 0x68,
-	0x51, 0x1f, 0x6f, 0x49, 0x21, 0x78, 0xde, 0x04, 0x6f, 0xfe, 0xbd, 0xd3, 0xac, 0x29, 0xbf, 0x2e,
-	0xb3, 0xd0, 0xe9, 0xd1, 0x30, 0xff, 0xae, 0x81, 0x5e, 0x0a, 0xa2, 0x9f, 0xa0, 0xb9, 0x25, 0xa1,
-	0x4f, 0x58, 0x9c, 0xc9, 0xfd, 0x32, 0xb8, 0x0c, 0xd6, 0xfa, 0x41, 0x3e, 0xb3, 0x0f, 0x00, 0x68,
-	0x05, 0x2d, 0x59, 0x4d, 0xc7, 0xa3, 0x09, 0x93, 0x3c, 0x87, 0x17, 0x02, 0x4a, 0xed, 0xc7, 0x24,
-	0x59, 0xdb, 0xba, 0xc4, 0x99, 0xd1, 0x84, 0xa1, 0xef, 0x40, 0x29, 0xf6, 0xcd, 0xdb, 0x0b, 0xb1,
-	0xe6, 0x98, 0xc5, 0x34, 0xb5, 0xc3, 0xdf, 0x6d, 0x85, 0xaf, 0x41, 0xcd, 0xa3, 0x81, 0x43, 0x12,
-	0xe6, 0x30, 0xd1, 0x35, 0xef, 0x83, 0xd3, 0xf0, 0x68, 0x30, 0x49, 0xd8, 0x1d, 0xef, 0xbf, 0xd4,
-	0x89, 0xc2, 0xc4, 0xa8, 0xe5, 0xfd, 0x97, 0xbe, 0x0b, 0x13, 0xee, 0xcc, 0x84, 0xb3, 0x9e, 0x3b,
-	0x33, 0xee, 0xbc, 0x01, 0x55, 0x14, 0xbe, 0x21, 0xa6, 0xea, 0x62, 0x19, 0xc2, 0x60, 0x1f, 0x7a,
-	0x8f, 0x62, 0x15, 0x89, 0xf7, 0xe8, 0x73, 0x68, 0xfb, 0x38, 0x75, 0x5c, 0xba, 0xd9, 0x38, 0x09,
-	0xdd, 0xfa, 0xd8, 0x68, 0x8a, 0x11, 0x6e, 0xf9, 0x38, 0x1d, 0xd3, 0xcd, 0x66, 0xc9, 0x7d, 0xe8,
-	0x63, 0x68, 0x8a, 0xa0, 0xe3, 0x0d, 0x0d, 0x4d, 0xc4, 0x1b, 0xc2, 0x9e, 0x0d, 0xcd, 0x18, 0x9a,
-	0x45, 0xb5, 0x50, 0x0b, 0x94, 0x40, 0xce, 0x85, 0x12, 0x70, 0xcb, 0x97, 0x93, 0xa7, 0xf8, 0xe8,
-	0x43, 0xa8, 0x45, 0x94, 0xad, 0x77, 0x72, 0x45, 0xe4, 0x06, 0x07, 0x4e, 0x9d, 0x70, 0xb3, 0x49,
-	0x08, 0x13, 0x0b, 0xa2, 0x62, 0x37, 0xd2, 0x5b, 0x61, 0xf2, 0x50, 0x56, 0x84, 0x6a, 0x79, 0x28,
-	0xcb, 0x43, 0xe6, 0x35, 0xe8, 0xa5, 0x82, 0xf2, 0x81, 0xdc, 0x45, 0xc5, 0x02, 0xe0, 0x47, 0xde,
-	0xe8, 0xbc, 0xc8, 0xf2, 0xdf, 0xc5, 0xd9, 0x7c, 0x05, 0xda, 0x41, 0x71, 0xce, 0x06, 0xc7, 0x31,
-	0xce, 0xe4, 0xe0, 0xe4, 0x46, 0xef, 0x0d, 0xe8, 0x25, 0x85, 0x50, 0x13, 0xd4, 0xc5, 0xed, 0x62,
-	0xd2, 0x79, 0x81, 0xae, 0x40, 0x1b, 0x4f, 0x6f, 0x6e, 0x56, 0xcb, 0xe9, 0xed, 0xa2, 0xa3, 0x7c,
-	0xdf, 0xfc, 0xa5, 0x9e, 0x6b, 0xfd, 0x50, 0x17, 0xdf, 0xd7, 0xeb, 0x7f, 0x02, 0x00, 0x00, 0xff,
-	0xff, 0x03, 0x48, 0x56, 0xe5, 0x7d, 0x07, 0x00, 0x00,
+	// 829 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x55, 0x5d, 0x8f, 0xe3, 0x34,
+	0x14, 0x5d, 0x37, 0xe9, 0x47, 0x6e, 0x3a, 0xa5, 0xf2, 0x82, 0x14, 0x22, 0x2d, 0x74, 0x3b, 0x68,
+	0x55, 0x09, 0x29, 0xb3, 0xea, 0xbc, 0x00, 0x02, 0x1e, 0x98, 0x76, 0x60, 0xc4, 0x7c, 0x29, 0xb3,
+	0x23, 0x2d, 0x48, 0x28, 0xf2, 0x36, 0xee, 0xd4, 0x22, 0x89, 0xa3, 0xc4, 0x53, 0x92, 0x7f, 0xca,
+	0x1b, 0x6f, 0xfc, 0x01, 0xfe, 0x00, 0xb2, 0xe3, 0xb4, 0xcd, 0x4e, 0x41, 0x85, 0xa7, 0xfa, 0x9e,
+	0xeb, 0x7b, 0x7a, 0x7c, 0xcf, 0xb5, 0x03, 0x6e, 0x9a, 0x71, 0xc1, 0x4f, 0xb2, 0x90, 0x9d, 0x84,
+	0x54, 0xc4, 0x24, 0x3d, 0x89, 0x49, 0xea, 0x29, 0x10, 0x43, 0x16, 0x32, 0xaf, 0x42, 0xc7, 0x7f,
+	0x18, 0x60, 0x5c, 0x91, 0x14, 0x7f, 0x03, 0xb0, 0x4a, 0xf3, 0x60, 0xc1, 0x93, 0x25, 0x7b, 0x70,
+	0xd0, 0xc8, 0x98, 0xd8, 0xd3, 0x4f, 0xbc, 0xed, 0x46, 0xef, 0x8a, 0xa4, 0xde, 0x0f, 0x69, 0x7e,
+	0xa6, 0x36, 0xcc, 0x13, 0x91, 0x95, 0xbe, 0xb5, 0xaa, 0x63, 0x7c, 0x09, 0x1f, 0xa8, 0x72, 0x12,
+	0xb1, 0x77, 0x19, 0x11, 0x8c, 0x27, 0x4e, 0x4b, 0x71, 0x1c, 0xef, 0xe3, 0xd8, 0xee, 0xaa, 0x88,
+	0x06, 0xab, 0x06, 0x28, 0xc5, 0x84, 0x54, 0xd4, 0x62, 0x8c, 0xfd, 0x62, 0x66, 0x54, 0x34, 0xc4,
+	0x84, 0x75, 0xec, 0xde, 0xc1, 0xa0, 0xa9, 0x14, 0x0f, 0xc1, 0xf8, 0x95, 0x96, 0x0e, 0x1a, 0xa1,
+	0xc9, 0x91, 0x2f, 0x97, 0xf8, 0x73, 0x68, 0xaf, 0x49, 0xf4, 0x48, 0x9d, 0xd6, 0x08, 0x4d, 0xec,
+	0xe9, 0x47, 0xbb, 0xec, 0x9b, 0x62, 0xbf, 0xda, 0xf3, 0x55, 0xeb, 0x0b, 0xe4, 0xfe, 0x02, 0xcf,
+	0xf7, 0x48, 0xdf, 0xc3, 0xfc, 0xba, 0xc9, 0xec, 0xbe, 0xcf, 0xbc, 0x65, 0xd8, 0xa5, 0x7f, 0x0b,
+	0x83, 0xe6, 0x81, 0xfe, 0x23, 0xf3, 0x8c, 0x0a, 0xba, 0x10, 0x3c, 0x7b, 0x22, 0x7c, 0xfc, 0x97,
+	0x01, 0xd6, 0xe6, 0x44, 0xf8, 0x05, 0x40, 0x12, 0x2c, 0x56, 0x24, 0x49, 0x68, 0x94, 0x6b, 0x72,
+	0x2b, 0x39, 0xd3, 0x00, 0xfe, 0x1a, 0xba, 0x3a, 0xa9, 0xfd, 0x1b, 0xef, 0x6d, 0x8c, 0xa7, 0x0b,
+	0xaa, 0xd6, 0xd7, 0x25, 0xf8, 0x25, 0xf4, 0x17, 0x8f, 0x59, 0x46, 0x13, 0xe5, 0xdd, 0xda, 0x31,
+	0x46, 0x68, 0xd2, 0xf2, 0x6d, 0x8d, 0x9d, 0xf1, 0x64, 0x8d, 0x3d, 0x30, 0x63, 0x1e, 0x52, 0xc7,
+	0x1c, 0xa1, 0xc9, 0x60, 0xea, 0xee, 0x67, 0xbf, 0xe2, 0x21, 0xf5, 0xd5, 0x3e, 0xfc, 0xa2, 0x31,
+	0x0a, 0xed, 0x4a, 0xef, 0xc6, 0x6a, 0x7c, 0x0c, 0x47, 0x39, 0x89, 0xd3, 0x88, 0x06, 0x29, 0xcd,
+	0x18, 0x0f, 0x9d, 0xce, 0x08, 0x4d, 0x90, 0xdf, 0xaf, 0xc0, 0x5b, 0x85, 0xb9, 0x29, 0x1c, 0x69,
+	0xbd, 0xba, 0x0a, 0x83, 0x49, 0x0a, 0x56, 0x1f, 0x5f, 0xad, 0xa5, 0x76, 0xf9, 0x1b, 0x6c, 0x8f,
+	0x2f, 0x73, 0xb6, 0xc4, 0x74, 0x31, 0x7e, 0x0e, 0xed, 0x94, 0x84, 0x41, 0xa1, 0x26, 0xb2, 0xe5,
+	0x9b, 0x29, 0x09, 0xdf, 0xd6, 0x60, 0xe9, 0x98, 0x1b, 0xf0, 0x27, 0x37, 0x80, 0xfe, 0x6e, 0x87,
+	0xf6, 0x78, 0xf9, 0x65, 0xd3, 0xcb, 0xe3, 0x7f, 0x6d, 0xf3, 0x53, 0x53, 0x3f, 0x05, 0x53, 0x36,
+	0x09, 0xdb, 0xd0, 0x3d, 0xbb, 0xf7, 0xfd, 0xf9, 0xf5, 0x9b, 0xe1, 0x33, 0x0c, 0xd0, 0xb9, 0xbd,
+	0xbf, 0xbc, 0x9b, 0xcf, 0x86, 0x68, 0x7c, 0x0a, 0x83, 0xe6, 0xb0, 0x3d, 0x31, 0x07, 0x8d, 0x8c,
+	0xf7, 0xcc, 0x19, 0xff, 0xde, 0x81, 0x41, 0x73, 0x90, 0x64, 0xab, 0x12, 0x12, 0x53, 0x25, 0xdd,
+	0xf2, 0xd5, 0x1a, 0x5f, 0x40, 0x9f, 0xc5, 0xe4, 0x81, 0xd6, 0xae, 0x54, 0x93, 0xf2, 0xea, 0x9f,
+	0xc7, 0xd1, 0xbb, 0x90, 0xdb, 0xf5, 0x29, 0x6c, 0xb6, 0x0d, 0xdc, 0x3f, 0xdb, 0x60, 0xef, 0x24,
+	0xf1, 0x8f, 0xd0, 0x7b, 0xa0, 0x3c, 0xa6, 0x22, 0x2b, 0xf5, 0x23, 0x74, 0x72, 0x18, 0xad, 0xf7,
+	0xbd, 0x2e, 0xf3, 0x37, 0x04, 0xf8, 0x1e, 0xfa, 0xda, 0xcd, 0x20, 0x62, 0xb9, 0xd0, 0x3a, 0xa7,
+	0x07, 0x12, 0xea, 0xde, 0xcf, 0x68, 0xbe, 0xf0, 0x6d, 0xcd, 0x73, 0xc9, 0x72, 0x81, 0xbf, 0x05,
+	0x54, 0x3f, 0x4a, 0xaf, 0x0f, 0xe4, 0xba, 0x22, 0x22, 0x63, 0x85, 0xcf, 0x7f, 0xf3, 0x91, 0x7c,
+	0x2b, 0xad, 0x88, 0x25, 0x01, 0xcd, 0x45, 0x20, 0x1c, 0xf3, 0x7f, 0xf2, 0x74, 0x23, 0x96, 0xcc,
+	0x73, 0xf1, 0x46, 0xce, 0x5f, 0x11, 0xa4, 0x3c, 0x77, 0xda, 0xd5, 0xfc, 0x15, 0xb7, 0x3c, 0x97,
+	0x60, 0xa9, 0xc0, 0x4e, 0x05, 0x96, 0x12, 0x3c, 0x07, 0x53, 0x19, 0xdf, 0x55, 0x57, 0xef, 0xe0,
+	0x36, 0xf0, 0x64, 0xcd, 0xa3, 0x47, 0xf5, 0x5e, 0xa9, 0x7a, 0xfc, 0x19, 0x0c, 0x62, 0x52, 0x04,
+	0x21, 0x5b, 0x2e, 0x83, 0x9c, 0x3d, 0xc4, 0xc4, 0xe9, 0xa9, 0x7b, 0xde, 0x8f, 0x49, 0x31, 0x63,
+	0xcb, 0xe5, 0x9d, 0xc4, 0xf0, 0xc7, 0xd0, 0x53, 0xc9, 0x20, 0x9a, 0x3a, 0x96, 0xca, 0x77, 0x55,
+	0x7c, 0x39, 0x75, 0x33, 0xe8, 0xd5, 0x6e, 0xe1, 0x3e, 0xa0, 0x44, 0xdf, 0x0b, 0x94, 0xc8, 0x28,
+	0xd6, 0x37, 0x0f, 0xc5, 0xf8, 0x43, 0x68, 0xa7, 0x4c, 0x2c, 0x56, 0xfa, 0x1d, 0xa9, 0x02, 0x49,
+	0x5c, 0x04, 0x7c, 0xb9, 0xcc, 0xa9, 0x50, 0xaf, 0x48, 0xcb, 0xef, 0x16, 0x37, 0x2a, 0x94, 0xa9,
+	0xb2, 0x4e, 0xb5, 0xab, 0x54, 0x59, 0xa5, 0xdc, 0x53, 0xb0, 0x77, 0x0c, 0x95, 0x17, 0x72, 0x95,
+	0xd6, 0x0f, 0x80, 0x5c, 0xca, 0x41, 0x97, 0x26, 0xeb, 0x7f, 0x57, 0x6b, 0xf7, 0x25, 0x58, 0x9b,
+	0x8e, 0x4b, 0x35, 0x24, 0xcb, 0x48, 0xa9, 0x2f, 0x4e, 0x15, 0x8c, 0x5f, 0x81, 0xbd, 0xd3, 0x21,
+	0xdc, 0x03, 0xf3, 0xfa, 0xe6, 0x7a, 0x3e, 0x7c, 0x86, 0x8f, 0xc0, 0x9a, 0x5d, 0x9c, 0x9f, 0xdf,
+	0xdf, 0x5d, 0xdc, 0x5c, 0x0f, 0xd1, 0x77, 0xbd, 0x9f, 0x3b, 0x55, 0xaf, 0xdf, 0x75, 0xd4, 0x47,
+	0xf8, 0xf4, 0xef, 0x01, 0x00, 0x32, 0xc3, 0x7f, 0x6a, 0xa2, 0x07, 0x00, 0x00,
 }
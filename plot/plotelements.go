@@ -7,6 +7,8 @@ package plot
 import (
 	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"gonum.org/v1/plot"
 )
@@ -37,6 +39,11 @@ func Log10Min15(x float64) float64 {
 	return math.Log10(x)
 }
 
+// WallClockMin is the smallest value that RollTicks labels as a UTC time of
+// day rather than a number, so that axes of absolute Unix time read as clock
+// times
+const WallClockMin = 1e9
+
 type RollTicks struct {
 	NSuggestedTicks int
 }
@@ -79,7 +86,11 @@ func (t RollTicks) Ticks(min, max float64) []plot.Tick {
 	var ticks []plot.Tick
 	for _, v := range labels {
 		vRounded := round(v, prec)
-		ticks = append(ticks, plot.Tick{Value: vRounded, Label: formatFloatTick(vRounded, -1)})
+		label := formatFloatTick(vRounded, -1)
+		if min >= WallClockMin {
+			label = formatClockTick(v, majorDelta)
+		}
+		ticks = append(ticks, plot.Tick{Value: vRounded, Label: label})
 	}
 	minorDelta := majorDelta / 2
 	if ticks[len(ticks)-1].Value > max-minorDelta {
@@ -159,3 +170,20 @@ func (LogTicks) Ticks(min, max float64) []plot.Tick {
 func formatFloatTick(v float64, prec int) string {
 	return strconv.FormatFloat(v, 'g', prec, 64)
 }
+
+// formatClockTick formats Unix time v as a UTC time of day, with as many
+// decimals of seconds as needed to tell apart ticks delta apart
+func formatClockTick(v, delta float64) string {
+	sec := math.Floor(v)
+	t := time.Unix(int64(sec), int64(math.Round((v-sec)*1e9))).UTC()
+	layout := "15:04:05"
+	decimals := 0
+	if delta < 1 {
+		decimals = int(math.Ceil(-math.Log10(delta) - 1e-9))
+		if decimals > 9 {
+			decimals = 9
+		}
+		layout += "." + strings.Repeat("0", decimals)
+	}
+	return t.Round(time.Duration(math.Pow10(9 - decimals))).Format(layout)
+}
@@ -11,16 +11,19 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"time"
 
 	"github.com/proio-org/go-proio"
+	"github.com/rditech/rdi-live/data"
 	"github.com/rditech/rdi-live/model/rdi/currentmode"
 )
 
 var (
 	outFile   = flag.String("o", "", "file to save output to")
 	compLevel = flag.Int("c", 1, "output compression level: 0 for uncompressed, 1 for LZ4 compression, 2 for GZIP compression, 3 for LZMA compression")
+	period    = flag.Float64("period", data.DefaultSamplePeriod, "time in seconds between samples")
 )
 
 func printUsage() {
@@ -90,7 +93,8 @@ func main() {
 	defer close(blocks)
 	go func() {
 		var offsets map[uint64]*currentmode.HpsSample
-		timestamp := (uint64(time.Now().Second()) << 32)
+		timestamp := uint64(time.Now().Unix()) << 32
+		sampleTicks := uint64(math.Round(*period * (1 << 32)))
 		event := proio.NewEvent()
 		for blockBuf := range blocks {
 			//timeS := binary.LittleEndian.Uint32(blockBuf[:4])
@@ -141,7 +145,7 @@ func main() {
 
 				frame.Sample[sampleNum] = sample
 
-				timestamp += 171799
+				timestamp += sampleTicks
 			}
 
 			if checksum == doubleChecksum {
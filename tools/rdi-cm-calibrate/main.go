@@ -0,0 +1,144 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/rditech/rdi-live/data"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/proio-org/go-proio"
+)
+
+var (
+	outFile     = flag.String("o", "", "file to save the calibrated detector map to")
+	detmapUrl   = flag.String("m", "", "detector map file, URL or packed name to calibrate instead of the default")
+	darkFile    = flag.String("dark", "", "proio file of a dark run to subtract from the channel responses")
+	current     = flag.Float64("current", 0, "known current per channel, in mapped units (0 for a flat-field run)")
+	deadFrac    = flag.Float64("dead", 0.05, "fraction of the target response below which a channel is dead")
+	outlierFrac = flag.Float64("outlier", 0.2, "gain correction beyond which a channel is an outlier")
+	quiet       = flag.Bool("q", false, "only report dead and outlier channels")
+)
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr,
+		`Usage: `+os.Args[0]+` [options] <proio-input-file>
+
+Compute per-channel gains from a flat-field or known-current run, report dead
+and outlier channels, and write a detector map with the resulting HPS
+calibrations.
+
+options:
+`,
+	)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		printUsage()
+		log.Fatal("Invalid arguments")
+	}
+
+	if *detmapUrl != "" {
+		data.DefaultDetmaps.Default = *detmapUrl
+	}
+
+	signal, metadata := channelResponse(flag.Arg(0))
+	var dark map[uint64][]float64
+	if *darkFile != "" {
+		dark, _ = channelResponse(*darkFile)
+	}
+
+	var uid uint64
+	if uidBytes := metadata["UID"]; len(uidBytes) == 8 {
+		uid = binary.BigEndian.Uint64(uidBytes)
+	}
+	detmap := data.DefaultDetmaps.Mapper(uid, metadata).Detmap()
+
+	calib := &data.GainCalibration{
+		Current:     *current,
+		DeadFrac:    *deadFrac,
+		OutlierFrac: *outlierFrac,
+	}
+	gains, err := calib.Compute(detmap, signal, dark)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var nDead, nOutliers int
+	for _, gain := range gains {
+		var status string
+		switch {
+		case gain.Dead:
+			status = "dead"
+			nDead++
+		case gain.Outlier:
+			status = "outlier"
+			nOutliers++
+		case *quiet:
+			continue
+		}
+		fmt.Printf(
+			"hps %016x channel %3d: response %10.4g gain %7.4f current_conv %10.4g %v\n",
+			gain.Hps, gain.Channel, gain.Response, gain.Gain, gain.CurrentConv, status,
+		)
+	}
+	log.Printf("%v channels calibrated, %v dead, %v outliers\n", len(gains), nDead, nOutliers)
+
+	calibrated := data.ApplyGains(detmap, gains)
+	for _, problem := range data.ValidateDetmap(calibrated) {
+		log.Println(problem)
+	}
+
+	if *outFile == "" {
+		return
+	}
+	detmapBytes, err := proto.Marshal(calibrated)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*outFile, detmapBytes, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// channelResponse returns the mean raw channel values of a run along with
+// the run metadata
+func channelResponse(filename string) (map[uint64][]float64, map[string][]byte) {
+	reader, err := proio.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+
+	response := &data.ChannelResponse{}
+	ops := data.OpArray{
+		data.EventOp{
+			Description: "Assemble frames for raw samples",
+			EventProcessor: func(event *proio.Event) {
+				if len(event.TaggedEntries("Frame")) == 0 {
+					data.AssembleFrame(event)
+				}
+			},
+		},
+		data.EventOp{
+			Description:    "Accumulate channel responses",
+			EventProcessor: response.Accumulate,
+		},
+	}
+	ops.Sink(reader.ScanEvents(10))
+
+	return response.Means(), reader.Metadata
+}
@@ -85,7 +85,8 @@ func main() {
 
 	blocksIn := make(chan []byte, blockBufSize)
 	blocksOut := make(chan []byte, blockBufSize)
-	go writeBlocks(blocksIn, blocksOut)
+	epochs := make(chan []byte, 1)
+	go writeBlocks(blocksIn, blocksOut, epochs)
 
 	for len(blocksOut) < blockBufSize {
 		blocksOut <- make([]byte, cyclonev.BUF_BLK_SIZE)
@@ -110,6 +111,7 @@ func main() {
 	}
 
 	lastBlockSel := -1
+	var lastEpoch time.Time
 	for block := range blocksOut {
 		blockSel := reader.ReadBlock(block)
 		if blockSel != (lastBlockSel+1)%cyclonev.NUM_SAMPLE_BLOCKS && lastBlockSel >= 0 {
@@ -117,6 +119,16 @@ func main() {
 		}
 		lastBlockSel = blockSel
 
+		// anchor the sample numbers to UTC time with the last sample of the
+		// block, which was just taken
+		if now := time.Now(); now.Sub(lastEpoch) >= epochInterval {
+			lastEpoch = now
+			select {
+			case epochs <- formatEpoch(now, lastSampleNum(block)):
+			default:
+			}
+		}
+
 		select {
 		case <-c:
 			goto wrapup
@@ -132,7 +144,19 @@ wrapup:
 	log.Println("quitting nicely")
 }
 
-func writeBlocks(blocksIn <-chan []byte, blocksOut chan<- []byte) {
+// formatEpoch returns the epoch anchor stamped in the stream metadata, in the
+// format of data.EpochKey
+func formatEpoch(t time.Time, sampleNum uint32) []byte {
+	return []byte(fmt.Sprintf("%v %v", t.UTC().Format(time.RFC3339Nano), sampleNum))
+}
+
+// lastSampleNum returns the sample number of the last sample of a block
+func lastSampleNum(block []byte) uint32 {
+	sampleOff := sampleInitOff + (samplesPerBlock-1)*(sampleHdrSize+sampleBufSize)
+	return binary.LittleEndian.Uint32(block[sampleOff+8 : sampleOff+12])
+}
+
+func writeBlocks(blocksIn <-chan []byte, blocksOut chan<- []byte, epochs <-chan []byte) {
 	uidBytes, err := hex.DecodeString(os.Getenv("HPS_UID"))
 	if err != nil {
 		log.Fatal("failure to decode UID hex text")
@@ -173,6 +197,7 @@ func writeBlocks(blocksIn <-chan []byte, blocksOut chan<- []byte) {
 		}()
 
 		var writer *proio.Writer
+		var epoch []byte
 		tryConn := func() error {
 			conn, err := websocket.Dial(url, "", "http://localhost/")
 			if err != nil {
@@ -185,6 +210,9 @@ func writeBlocks(blocksIn <-chan []byte, blocksOut chan<- []byte) {
 			writer.BucketDumpThres = 0x1
 			writer.DeferUntilClose(conn.Close)
 			writer.PushMetadata("UID", uidBytes)
+			if epoch != nil {
+				writer.PushMetadata("Epoch", epoch)
+			}
 
 			return nil
 		}
@@ -222,6 +250,9 @@ func writeBlocks(blocksIn <-chan []byte, blocksOut chan<- []byte) {
 				writer.PushMetadata("HV", buf)
 			case buf := <-tempData:
 				writer.PushMetadata("Temp", buf)
+			case buf := <-epochs:
+				epoch = buf
+				writer.PushMetadata("Epoch", buf)
 			}
 		}
 
@@ -305,7 +336,8 @@ wrapup:
 }
 
 const (
-	blockBufSize = 1000
+	blockBufSize  = 1000
+	epochInterval = time.Hour
 
 	nChannels       = cyclonev.CHN_COUNT * cyclonev.ADC_COUNT
 	sampleInitOff   = cyclonev.HEADER_SIZE
@@ -14,8 +14,8 @@ func main() {
 	player := &data.Player{}
 	playOp := data.OpArray{
 		data.StreamOp{
-			Description:     "Repeats data using timestamp information to \"play\" data at a realistic rate from a recording",
-			StreamProcessor: player.PlayCmStream,
+			Description:            "Repeats data using timestamp information to \"play\" data at a realistic rate from a recording",
+			ContextStreamProcessor: player.Play,
 		},
 	}
 	playOp.RunCmdFlagParse()
@@ -13,6 +13,19 @@ import (
 	"github.com/google/uuid"
 )
 
+// defaultPipeline is used when no pipeline spec is given
+var defaultPipeline = data.MustParsePipeline(`
+- map
+- correlate
+- pedestals
+- health
+- beam
+- image
+`)
+
+var pipelineFile = data.FlagSet.String("p", "",
+	"pipeline spec file (YAML or JSON) to use instead of the default, with ops\n"+data.RegisteredOps())
+
 func main() {
 	ops := data.OpArray{}
 	reader := ops.GetReader()
@@ -25,25 +38,21 @@ func main() {
 	}
 	uid := binary.BigEndian.Uint64(uidBytes)
 
-	peds := &data.Pedestals{}
-	recon := data.NewBeamReconstruction(uid)
-	ops = data.OpArray{
-		data.EventOp{
-			Description:    "Maps event axes",
-			EventProcessor: data.MapEvent,
-		},
-		data.EventOp{
-			Description:    "Calculates and adds event correlation",
-			EventProcessor: data.CorrelateCmEvent,
-		},
-		data.StreamOp{
-			Description:     "Calculates and subtracts pedestals from stream",
-			StreamProcessor: peds.Subtract,
-		},
-		data.EventOp{
-			Description:    "Reconstruct beam paramters",
-			EventProcessor: recon.FillBeamInfo,
-		},
+	pipeline := defaultPipeline
+	if *pipelineFile != "" {
+		var err error
+		pipeline, err = data.LoadPipeline(*pipelineFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ops, err := pipeline.Build(&data.PipelineEnv{
+		UID:    uid,
+		Mapper: data.DefaultDetmaps.Mapper(uid, reader.Metadata),
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	ops.RunCmd()
@@ -11,10 +11,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/rditech/rdi-live/data"
 	"github.com/rditech/rdi-live/model/rdi/currentmode"
 
 	"github.com/proio-org/go-proio"
@@ -23,6 +25,7 @@ import (
 var (
 	outFile   = flag.String("o", "", "file to save output to")
 	compLevel = flag.Int("c", 1, "output compression level: 0 for uncompressed, 1 for LZ4 compression, 2 for GZIP compression, 3 for LZMA compression")
+	period    = flag.Float64("period", data.DefaultSamplePeriod, "time in seconds between samples")
 )
 
 func printUsage() {
@@ -90,7 +93,8 @@ func main() {
 
 	event := proio.NewEvent()
 	var timestamp uint64
-	timestamp = (uint64(time.Now().Second()) << 32)
+	timestamp = uint64(time.Now().Unix()) << 32
+	sampleTicks := uint64(math.Round(*period * (1 << 32)))
 	frame := &currentmode.Frame{Timestamp: timestamp}
 	count := 0
 	for reader.Scan() {
@@ -113,7 +117,7 @@ func main() {
 			hpsSample.Channel[i] = int32(binary.BigEndian.Uint32(sampleBytes))
 		}
 
-		timestamp += 171799
+		timestamp += sampleTicks
 		count++
 		if count == 64 {
 			event.AddEntry("Frame", frame)
@@ -0,0 +1,311 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rditech/rdi-live/data"
+	detmapmodel "github.com/rditech/rdi-live/model/rdi/detmap"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	format    = flag.String("f", "", "text format (json or yaml), determined from the file extension by default")
+	outFile   = flag.String("o", "", "file to save output to")
+	credsFile = flag.String("creds", "", "credentials file for reading maps from cloud storage")
+)
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr,
+		`Usage: `+os.Args[0]+` [options] <command> <args>
+
+Inspect, build and check detector maps.  Maps are given as a file path, a URL
+(file://, gs://, s3://, ...), or the name of a map packed into rdi-live.
+
+commands:
+  dump <map>            write a map as JSON (default) or YAML
+  build <text-file>     build a binary map from JSON or YAML
+  validate <map>        check a map for inconsistencies
+  diff <map> <map>      list the differences between two maps
+
+options:
+`,
+	)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		printUsage()
+		log.Fatal("Invalid arguments")
+	}
+
+	var creds string
+	if *credsFile != "" {
+		credsBytes, err := ioutil.ReadFile(*credsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds = string(credsBytes)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "dump":
+		if len(args) != 1 {
+			log.Fatal("dump takes one map")
+		}
+		detmap := loadMap(args[0], creds)
+		text, err := marshalText(detmap, textFormat(*outFile, "json"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeOutput(text)
+	case "build":
+		if len(args) != 1 {
+			log.Fatal("build takes one text file")
+		}
+		text, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		detmap, err := unmarshalText(text, textFormat(args[0], "json"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, problem := range data.ValidateDetmap(detmap) {
+			log.Println(problem)
+		}
+		detmapBytes, err := proto.Marshal(detmap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeOutput(detmapBytes)
+	case "validate":
+		if len(args) != 1 {
+			log.Fatal("validate takes one map")
+		}
+		detmap := loadMap(args[0], creds)
+		nErrors := 0
+		for _, problem := range data.ValidateDetmap(detmap) {
+			fmt.Println(problem)
+			if !problem.Warning {
+				nErrors++
+			}
+		}
+		if nErrors > 0 {
+			log.Fatalf("%v errors found", nErrors)
+		}
+	case "diff":
+		if len(args) != 2 {
+			log.Fatal("diff takes two maps")
+		}
+		a, err := genericMap(loadMap(args[0], creds))
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := genericMap(loadMap(args[1], creds))
+		if err != nil {
+			log.Fatal(err)
+		}
+		diffs := diffValues("", a, b, nil)
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+	default:
+		printUsage()
+		log.Fatalf("Unknown command \"%v\"", flag.Arg(0))
+	}
+}
+
+func loadMap(source, creds string) *detmapmodel.Map {
+	detmap, _, err := data.LoadDetmap(source, creds)
+	if err != nil {
+		log.Fatalf("unable to load detector map \"%v\": %v", source, err)
+	}
+	return detmap
+}
+
+func writeOutput(output []byte) {
+	if *outFile == "" {
+		os.Stdout.Write(output)
+		return
+	}
+	if err := ioutil.WriteFile(*outFile, output, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// textFormat returns the format given by flag, or else by the extension of
+// filename
+func textFormat(filename, fallback string) string {
+	if *format != "" {
+		return strings.ToLower(*format)
+	}
+	switch {
+	case strings.HasSuffix(filename, ".yaml"), strings.HasSuffix(filename, ".yml"):
+		return "yaml"
+	case strings.HasSuffix(filename, ".json"):
+		return "json"
+	}
+	return fallback
+}
+
+var marshaler = jsonpb.Marshaler{OrigName: true, Indent: "  "}
+
+func marshalText(detmap *detmapmodel.Map, format string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := marshaler.Marshal(buf, detmap); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		buf.WriteString("\n")
+		return buf.Bytes(), nil
+	case "yaml":
+		// go through yaml.MapSlice to keep the field order of the JSON
+		var value yaml.MapSlice
+		if err := yaml.Unmarshal(buf.Bytes(), &value); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(value)
+	}
+	return nil, fmt.Errorf("unknown format \"%v\"", format)
+}
+
+func unmarshalText(text []byte, format string) (*detmapmodel.Map, error) {
+	switch format {
+	case "json":
+	case "yaml":
+		var value interface{}
+		if err := yaml.Unmarshal(text, &value); err != nil {
+			return nil, err
+		}
+		var err error
+		text, err = json.Marshal(jsonValue(value))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format \"%v\"", format)
+	}
+
+	detmap := &detmapmodel.Map{}
+	if err := jsonpb.Unmarshal(bytes.NewReader(text), detmap); err != nil {
+		return nil, err
+	}
+	return detmap, nil
+}
+
+// jsonValue converts the map[interface{}]interface{} values produced by the
+// YAML decoder into map[string]interface{} values that can be encoded as JSON
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{})
+		for key, val := range value {
+			converted[fmt.Sprint(key)] = jsonValue(val)
+		}
+		return converted
+	case []interface{}:
+		for i, val := range value {
+			value[i] = jsonValue(val)
+		}
+	}
+	return value
+}
+
+// genericMap returns the JSON form of a map decoded into generic values
+func genericMap(detmap *detmapmodel.Map) (interface{}, error) {
+	text, err := marshaler.MarshalToString(detmap)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal([]byte(text), &value)
+	return value, err
+}
+
+func diffValues(path string, a, b interface{}, diffs []string) []string {
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		keys := make(map[string]bool)
+		for key := range aMap {
+			keys[key] = true
+		}
+		for key := range bMap {
+			keys[key] = true
+		}
+		var sortedKeys []string
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+		for _, key := range sortedKeys {
+			diffs = diffValues(joinPath(path, key), aMap[key], bMap[key], diffs)
+		}
+		return diffs
+	}
+
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		for i := 0; i < len(aList) || i < len(bList); i++ {
+			var aVal, bVal interface{}
+			if i < len(aList) {
+				aVal = aList[i]
+			}
+			if i < len(bList) {
+				bVal = bList[i]
+			}
+			diffs = diffValues(joinPath(path, fmt.Sprint(i)), aVal, bVal, diffs)
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		diffs = append(diffs, fmt.Sprintf("%v: %v -> %v", path, describe(a), describe(b)))
+	}
+	return diffs
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describe(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "(none)"
+	case map[string]interface{}, []interface{}:
+		text, _ := json.Marshal(value)
+		return string(text)
+	}
+	return fmt.Sprint(value)
+}
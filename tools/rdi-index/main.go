@@ -0,0 +1,94 @@
+// Copyright 2019 Radiation Detection and Imaging (RDI), LLC
+// Use of this source code is governed by the BSD 3-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/rditech/rdi-live/data"
+)
+
+var (
+	interval  = flag.Float64("i", data.DefaultTimeIndexInterval, "least time in seconds between index entries")
+	force     = flag.Bool("f", false, "replace existing indexes")
+	credsFile = flag.String("creds", "", "credentials file for runs in cloud storage")
+)
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr,
+		`Usage: `+os.Args[0]+` [options] <run>...
+
+Build the time indexes of runs recorded without them, so that they can be
+played and read from part way through.  Runs are given as a file path or a URL
+(file://, gs://, s3://, ...), and each index is written next to its run with
+the extension `+data.TimeIndexSuffix+` added.
+
+options:
+`,
+	)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		printUsage()
+		log.Fatal("Invalid arguments")
+	}
+
+	var creds string
+	if *credsFile != "" {
+		credsBytes, err := ioutil.ReadFile(*credsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds = string(credsBytes)
+	}
+
+	// runs given on the command line may use the credentials of the environment
+	ctx := data.ServerContext(context.Background())
+	failed := false
+	for _, run := range flag.Args() {
+		if err := indexRun(ctx, run, creds); err != nil {
+			log.Printf("unable to index %v: %v", run, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func indexRun(ctx context.Context, run, creds string) error {
+	urlString, err := data.ObjectURL(run)
+	if err != nil {
+		return err
+	}
+	indexUrl := urlString + data.TimeIndexSuffix
+
+	if !*force {
+		if _, err := data.ObjectModTime(ctx, indexUrl, creds); err == nil {
+			log.Printf("skipping %v, which is already indexed", run)
+			return nil
+		}
+	}
+
+	index, err := data.BuildTimeIndex(ctx, urlString, creds, *interval)
+	if err != nil {
+		return err
+	}
+	if err := data.WriteTimeIndex(ctx, indexUrl, creds, index); err != nil {
+		return err
+	}
+	log.Printf("indexed %v events of %v in %v entries", index.Events, run, len(index.Entries))
+	return nil
+}
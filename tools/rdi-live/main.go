@@ -8,18 +8,22 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime/pprof"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/rditech/rdi-live/data"
 	"github.com/rditech/rdi-live/live"
 	"github.com/rditech/rdi-live/live/handlers/callback"
 	"github.com/rditech/rdi-live/live/handlers/client"
+	"github.com/rditech/rdi-live/live/handlers/history"
 	"github.com/rditech/rdi-live/live/handlers/ingress"
 	"github.com/rditech/rdi-live/live/handlers/login"
 	"github.com/rditech/rdi-live/live/handlers/logout"
@@ -27,6 +31,7 @@ import (
 	"github.com/alicebob/miniredis"
 	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/skratchdot/open-golang/open"
 	"golang.org/x/net/websocket"
 )
@@ -70,6 +75,87 @@ func main() {
 		log.Printf("successfully connected to redis server at %v with status %v\n", redisAddr, ping.String())
 	}
 
+	// Configure detector map selection
+	if detmapUrl := os.Getenv("DETMAP_URL"); len(detmapUrl) > 0 {
+		data.DefaultDetmaps.Default = detmapUrl
+	}
+	data.DefaultDetmaps.MetadataKey = os.Getenv("DETMAP_KEY")
+	if err := data.DefaultDetmaps.ParseByUID(os.Getenv("DETMAP_UIDS")); err != nil {
+		log.Fatal(err)
+	}
+	if credFile := os.Getenv("DETMAP_CREDENTIALS"); len(credFile) > 0 {
+		creds, err := ioutil.ReadFile(credFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		data.DefaultDetmaps.Credentials = string(creds)
+	}
+
+	// Configure pedestal persistence
+	if pedDir := os.Getenv("PEDESTAL_DIR"); len(pedDir) > 0 {
+		live.PedestalStore = data.PedestalDir(pedDir)
+	}
+
+	// Configure alarm notification
+	live.AlarmLogFile = os.Getenv("ALARM_LOG")
+	live.AlarmWebhook = os.Getenv("ALARM_WEBHOOK")
+	for _, host := range strings.Split(os.Getenv("ALARM_WEBHOOK_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); len(host) > 0 {
+			live.AlarmWebhookHosts = append(live.AlarmWebhookHosts, host)
+		}
+	}
+
+	// Configure storage
+	for _, host := range strings.Split(os.Getenv("S3_ENDPOINT_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); len(host) > 0 {
+			data.S3EndpointHosts = append(data.S3EndpointHosts, host)
+		}
+	}
+
+	// Configure run recording
+	live.RunSpoolDir = os.Getenv("RUN_SPOOL_DIR")
+	if catalogFile := os.Getenv("RUN_CATALOG"); len(catalogFile) > 0 {
+		var err error
+		if live.Catalog, err = live.OpenRunCatalog(catalogFile); err != nil {
+			log.Fatal(err)
+		}
+		defer live.Catalog.Close()
+	}
+
+	// Configure stream processing pipelines
+	if cmPipeline := os.Getenv("CM_PIPELINE"); len(cmPipeline) > 0 {
+		live.CmPipeline = loadPipeline(cmPipeline)
+	}
+	if pmPipeline := os.Getenv("PM_PIPELINE"); len(pmPipeline) > 0 {
+		live.PmPipeline = loadPipeline(pmPipeline)
+	}
+	switch strings.ToLower(os.Getenv("PIPELINE_STATS")) {
+	case "true", "on":
+		live.PipelineStats = true
+	}
+
+	// Configure the sources exported as metrics
+	if metricSources := os.Getenv("METRICS_SOURCES"); len(metricSources) > 0 {
+		var err error
+		if live.MetricSources, err = regexp.Compile(metricSources); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Configure the sources with history
+	if historySources := os.Getenv("HISTORY_SOURCES"); len(historySources) > 0 {
+		var err error
+		if live.HistorySources, err = regexp.Compile(historySources); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if historyRetention := os.Getenv("HISTORY_RETENTION"); len(historyRetention) > 0 {
+		var err error
+		if live.HistoryRetention, err = time.ParseDuration(historyRetention); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Define handlers
 	callbackHandler := http.HandlerFunc(callback.LoginCallback)
 	clientHandler := &client.ClientHandler{Redis: redisClient, Addr: redisAddr}
@@ -83,6 +169,8 @@ func main() {
 	wsc := &ingress.WsCollector{Redis: redisClient, Addr: redisAddr}
 	ingressHandler := websocket.Handler(wsc.Collect)
 	logoutHandler := http.HandlerFunc(logout.Logout)
+	historyHandler := http.HandlerFunc(history.HistoryHandler)
+	metricsHandler := promhttp.Handler()
 	webdataHandler := http.StripPrefix("/webdata/", http.FileServer(live.WebdataBox))
 	rootHandler := http.StripPrefix("/", http.FileServer(live.WebdataBox))
 
@@ -101,6 +189,8 @@ func main() {
 		router.Handle("/client", login.LoginMiddleware(clientHandler))
 		router.Handle("/ingress", ingressHandler)
 		router.Handle("/logout", logoutHandler)
+		router.Handle("/history", login.LoginMiddleware(historyHandler))
+		router.Handle("/metrics", metricsHandler)
 		router.PathPrefix("/webdata/").Handler(webdataHandler)
 		router.PathPrefix("/").Handler(login.LoginMiddleware(rootHandler))
 	} else {
@@ -108,6 +198,8 @@ func main() {
 
 		router.Handle("/client", clientHandler)
 		router.Handle("/ingress", ingressHandler)
+		router.Handle("/history", historyHandler)
+		router.Handle("/metrics", metricsHandler)
 		router.PathPrefix("/webdata/").Handler(webdataHandler)
 		router.PathPrefix("/").Handler(rootHandler)
 	}
@@ -157,6 +249,17 @@ func main() {
 	log.Println("successful quit")
 }
 
+func loadPipeline(filename string) data.Pipeline {
+	pipeline, err := data.LoadPipeline(filename)
+	if err == nil {
+		err = pipeline.Check()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pipeline
+}
+
 // Middleware for redirecting http requests that are behind an HTTP proxy to
 // https
 func Secure(next http.Handler) http.Handler {